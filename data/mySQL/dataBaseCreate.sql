@@ -8,8 +8,9 @@ USE Clash_Royale;
 CREATE TABLE IF NOT EXISTS users (
     id INT AUTO_INCREMENT PRIMARY KEY,
     gmail VARCHAR(255) NOT NULL UNIQUE,
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
     username VARCHAR(100) NOT NULL,
-    password VARCHAR(255) NOT NULL
+    password VARCHAR(255) NOT NULL       -- chuỗi argon2id dạng PHC ($argon2id$v=19$...), dòng cũ có thể còn mật khẩu thô
 );
 
 CREATE TABLE IF NOT EXISTS user_stats (
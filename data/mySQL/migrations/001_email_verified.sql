@@ -0,0 +1,5 @@
+-- Thêm cờ xác thực email cho database tạo trước khi có tính năng xác thực email.
+-- Chạy một lần; database mới tạo bằng dataBaseCreate.sql đã có cột này.
+USE Clash_Royale;
+
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE AFTER gmail;
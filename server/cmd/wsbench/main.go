@@ -0,0 +1,254 @@
+// wsbench đo thông lượng gửi của server với nhiều trận chạy đồng thời, so sánh
+// các tổ hợp batching và permessage-deflate.
+//
+// Mỗi trận có 2 client thật kết nối qua loopback. Mỗi tick, goroutine trận tạo
+// snapshot, lô sự kiện và deck cho từng người chơi giống game loop, đẩy vào
+// OutQueue và để websocket.WriteLoop ghi xuống socket.
+//
+//	go run ./cmd/wsbench -matches 100 -ticks 100 -tick 50ms -codec msgpack
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"server/internal/codec"
+	"server/internal/types"
+	"server/internal/utils"
+	ws "server/internal/websocket"
+	"server/internal/wire"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	matches  = flag.Int("matches", 100, "số trận chạy đồng thời")
+	ticks    = flag.Int("ticks", 100, "số tick mỗi trận")
+	tick     = flag.Duration("tick", 50*time.Millisecond, "độ dài một tick (game thật: 5s)")
+	entities = flag.Int("entities", 30, "số entity trong mỗi snapshot")
+	events   = flag.Int("events", 10, "số sự kiện combat mỗi tick")
+	codecArg = flag.String("codec", "json", "json hoặc msgpack")
+)
+
+type mode struct {
+	batch   bool
+	deflate bool
+}
+
+type result struct {
+	mode     mode
+	elapsed  time.Duration
+	messages int64
+	frames   int64
+	wire     int64
+}
+
+// countingConn đếm số byte thực sự đi qua socket (sau nén)
+type countingConn struct {
+	net.Conn
+	n *atomic.Int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func main() {
+	flag.Parse()
+
+	c := codec.ForSubprotocol(codec.SubprotocolJSON)
+	if *codecArg == "msgpack" {
+		c = codec.Msgpack
+	}
+
+	fmt.Printf("%d matches x %d ticks @ %s, %d entities, %d events/tick, codec %s\n\n",
+		*matches, *ticks, *tick, *entities, *events, c.Name())
+	fmt.Printf("%-8s %-8s %10s %12s %12s %12s %10s\n", "batch", "deflate", "elapsed", "msgs/s", "frames/s", "wire KB/s", "B/msg")
+
+	for _, m := range []mode{{false, false}, {true, false}, {false, true}, {true, true}} {
+		r := run(m, c)
+		secs := r.elapsed.Seconds()
+		fmt.Printf("%-8v %-8v %10s %12.0f %12.0f %12.1f %10.1f\n",
+			m.batch, m.deflate, r.elapsed.Round(time.Millisecond),
+			float64(r.messages)/secs, float64(r.frames)/secs,
+			float64(r.wire)/secs/1024, float64(r.wire)/float64(r.messages))
+	}
+}
+
+func run(m mode, c codec.Codec) result {
+	clients := make(chan *types.Client, 2**matches)
+	upgrader := websocket.Upgrader{
+		Subprotocols:      codec.Subprotocols,
+		EnableCompression: m.deflate,
+		CheckOrigin:       func(*http.Request) bool { return true },
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("upgrade: %v", err)
+			return
+		}
+		if m.deflate {
+			conn.EnableWriteCompression(true)
+			conn.SetCompressionLevel(1)
+		}
+		client := &types.Client{
+			Conn:  conn,
+			Out:   types.NewOutQueue(1<<20, 0),
+			Codec: codec.ForSubprotocol(conn.Subprotocol()),
+			Done:  make(chan struct{}),
+		}
+		client.Out.SetBatching(m.batch)
+		clients <- client
+		ws.WriteLoop(client)
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// Client đọc và đếm frame, byte trên dây
+	var frames, wireBytes atomic.Int64
+	var readers sync.WaitGroup
+	dialer := websocket.Dialer{
+		Subprotocols:      []string{c.Name()},
+		EnableCompression: m.deflate,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return countingConn{Conn: conn, n: &wireBytes}, nil
+		},
+	}
+	for i := 0; i < 2**matches; i++ {
+		conn, _, err := dialer.Dial("ws://"+ln.Addr().String()+"/ws", nil)
+		if err != nil {
+			log.Fatalf("dial: %v", err)
+		}
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+				frames.Add(1)
+			}
+		}()
+	}
+
+	server := make([]*types.Client, 0, 2**matches)
+	for i := 0; i < 2**matches; i++ {
+		server = append(server, <-clients)
+	}
+
+	var messages atomic.Int64
+	var producers sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *matches; i++ {
+		producers.Add(1)
+		go func(players []*types.Client) {
+			defer producers.Done()
+			playMatch(players, &messages)
+		}(server[2*i : 2*i+2])
+	}
+	producers.Wait()
+
+	// Chờ hàng đợi gửi hết rồi đóng kết nối
+	for _, sc := range server {
+		for {
+			critical, updates := sc.Out.Len()
+			if critical == 0 && updates == 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	elapsed := time.Since(start)
+	for _, sc := range server {
+		sc.Out.Close()
+		sc.Conn.Close()
+	}
+	readers.Wait()
+
+	return result{mode: m, elapsed: elapsed, messages: messages.Load(), frames: frames.Load(), wire: wireBytes.Load()}
+}
+
+// playMatch mô phỏng các message game loop gửi cho 2 người chơi mỗi tick
+func playMatch(players []*types.Client, messages *atomic.Int64) {
+	ticker := time.NewTicker(*tick)
+	defer ticker.Stop()
+
+	for t := int64(1); t <= int64(*ticks); t++ {
+		<-ticker.C
+		for side, c := range players {
+			c.Out.Cork()
+			push(c, "state", snapshot(t, side))
+			push(c, "events", eventBatch(t))
+			// Deck sau khi thả bài: critical, khoảng 1/4 số tick
+			if t%4 == int64(side) {
+				data, _ := c.Encode(utils.OutgoingMessage{ID: "deck", Type: "deck", Data: wire.Deck{V: wire.Version, Player: player()}})
+				c.Out.Push(data)
+				messages.Add(1)
+			}
+			c.Out.Uncork()
+			messages.Add(2)
+		}
+	}
+}
+
+func push(c *types.Client, key string, v interface{}) {
+	data, err := c.Encode(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.Out.PushUpdate(key, data)
+}
+
+func snapshot(t int64, side int) utils.OutgoingMessage {
+	list := make([]wire.Entity, *entities)
+	for i := range list {
+		list[i] = wire.Entity{
+			ID: fmt.Sprintf("00000000-0000-0000-0000-%012d", i), Kind: wire.KindTroop,
+			Side: i % 2, Own: i%2 == side, Name: "Knight", Level: 3,
+			X: (i * 7) % 18, Y: (i*11 + int(t)) % 32, Width: 1, Height: 1,
+			HP: 600 - int(t)%600, MaxHP: 600, TargetID: "00000000-0000-0000-0000-000000000001",
+		}
+	}
+	return utils.OutgoingMessage{ID: "snapshot", Type: "snapshot", Data: wire.Snapshot{
+		V: wire.Version, Tick: t, ServerTime: time.Now().UnixMilli(), Entities: list, Player: player(),
+	}}
+}
+
+func eventBatch(t int64) utils.OutgoingMessage {
+	batch := wire.EventBatch{V: wire.Version, Tick: t, ServerTime: time.Now().UnixMilli()}
+	for i := 0; i < *events; i++ {
+		batch.Events = append(batch.Events, wire.Event{
+			Type: "damage_dealt", Tick: t, Side: i % 2, Kind: wire.KindTroop, Name: "Knight",
+			SourceID: "00000000-0000-0000-0000-000000000002", TargetID: "00000000-0000-0000-0000-000000000003",
+			Amount: 120, Position: &wire.Point{X: i % 18, Y: i % 32},
+		})
+	}
+	return utils.OutgoingMessage{ID: "events", Type: "events", Data: batch}
+}
+
+func player() wire.PlayerView {
+	hand := make([]wire.HandCard, 4)
+	for i := range hand {
+		hand[i] = wire.HandCard{Slot: i, CardID: i + 1, Name: "Pawn", Mana: 2}
+	}
+	return wire.PlayerView{Hand: hand, Next: &wire.HandCard{Slot: -1, CardID: 5, Name: "Queen", Mana: 5},
+		Elixir: wire.Elixir{Value: 6.5, Max: 10, Progress: 0.4}}
+}
@@ -0,0 +1,356 @@
+// Package admin là HTTP API cho người vận hành xem và can thiệp trạng thái đang chạy.
+//
+// Chỉ bật khi có ADMIN_TOKEN; mọi request phải gửi "Authorization: Bearer <ADMIN_TOKEN>".
+// Lỗi trả về dạng {"error": "<mã>", "message": "..."} giống package api.
+//
+//	GET  /admin/clients                                   -> []clientInfo
+//	GET  /admin/lobbies                                   -> []lobbyInfo
+//	GET  /admin/matches                                   -> []matchInfo
+//	GET  /admin/metrics                                   -> []router.TypeStats
+//	POST /admin/clients/kick       {user_id | session_id, reason}
+//	POST /admin/lobbies/{id}/close {reason}
+//	POST /admin/matches/{id}/end   {result: side0|side1|draw, reason}
+//	POST /admin/broadcast          {message, level}
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"server/internal/config"
+	"server/internal/router"
+	"server/internal/session"
+	"server/internal/types"
+	"server/internal/utils"
+)
+
+type clientInfo struct {
+	RemoteAddr      string   `json:"remote_addr"`
+	UserID          int      `json:"user_id"`
+	SessionID       string   `json:"session_id,omitempty"`
+	Codec           string   `json:"codec"`
+	Platform        string   `json:"platform,omitempty"`
+	Build           string   `json:"build,omitempty"`
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	Features        []string `json:"features,omitempty"`
+	RoomType        string   `json:"room_type,omitempty"`
+	RTTMillis       float64  `json:"rtt_ms"`
+	IdleSeconds     float64  `json:"idle_seconds"`
+	ConnectedFor    float64  `json:"connected_seconds"`
+	QueuedCritical  int      `json:"queued_critical"`
+	QueuedUpdates   int      `json:"queued_updates"`
+}
+
+type slotInfo struct {
+	Slot   int `json:"slot"`
+	UserID int `json:"user_id"`
+}
+
+type lobbyInfo struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	MaxSize    int        `json:"max_size"`
+	Matching   bool       `json:"matching"`
+	Players    []slotInfo `json:"players"`
+	AgeSeconds float64    `json:"age_seconds"`
+}
+
+type playerInfo struct {
+	UserID    int  `json:"user_id"`
+	Connected bool `json:"connected"`
+}
+
+type matchInfo struct {
+	ID             string       `json:"id"`
+	Type           string       `json:"type"`
+	Players        []playerInfo `json:"players"`
+	Tick           int64        `json:"tick"`
+	ElapsedSeconds float64      `json:"elapsed_seconds"`
+}
+
+type kickRequest struct {
+	UserID    int    `json:"user_id"`
+	SessionID string `json:"session_id"`
+	Reason    string `json:"reason"`
+}
+
+type closeLobbyRequest struct {
+	Reason string `json:"reason"`
+}
+
+type endMatchRequest struct {
+	Result string `json:"result"` // side0, side1 hoặc draw
+	Reason string `json:"reason"`
+}
+
+type broadcastRequest struct {
+	Message string `json:"message"`
+	Level   string `json:"level"` // info, warning; mặc định info
+}
+
+// Register gắn các route admin vào mux. Không có ADMIN_TOKEN thì API bị tắt.
+// metrics có thể nil nếu không thống kê theo type message.
+func Register(mux *http.ServeMux, metrics *router.Metrics) {
+	if config.Config.AdminToken == "" {
+		log.Println("Admin API disabled: ADMIN_TOKEN is not set")
+		return
+	}
+
+	mux.Handle("GET /admin/clients", requireAdmin(handleClients))
+	mux.Handle("GET /admin/lobbies", requireAdmin(handleLobbies))
+	mux.Handle("GET /admin/matches", requireAdmin(handleMatches))
+	mux.Handle("GET /admin/metrics", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if metrics == nil {
+			writeJSON(w, http.StatusOK, []router.TypeStats{})
+			return
+		}
+		writeJSON(w, http.StatusOK, metrics.Snapshot())
+	}))
+	mux.Handle("POST /admin/clients/kick", requireAdmin(handleKick))
+	mux.Handle("POST /admin/lobbies/{id}/close", requireAdmin(handleCloseLobby))
+	mux.Handle("POST /admin/matches/{id}/end", requireAdmin(handleEndMatch))
+	mux.Handle("POST /admin/broadcast", requireAdmin(handleBroadcast))
+}
+
+func handleClients(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	list := make([]clientInfo, 0)
+	for _, c := range clients() {
+		critical, updates := c.Out.Len()
+		// Các trường này do goroutine kết nối/phòng chờ ghi, chỉ đọc qua bản chụp có khóa
+		st := c.State()
+		list = append(list, clientInfo{
+			RemoteAddr:      c.RemoteAddr,
+			UserID:          st.UserID,
+			SessionID:       st.SessionID,
+			Codec:           c.Codec.Name(),
+			Platform:        st.Info.Platform,
+			Build:           st.Info.Build,
+			ProtocolVersion: st.Info.ProtocolVersion,
+			Features:        st.Info.Features,
+			RoomType:        st.RoomType,
+			RTTMillis:       float64(c.RTT()) / float64(time.Millisecond),
+			IdleSeconds:     now.Sub(time.Unix(0, c.LastSeen.Load())).Seconds(),
+			ConnectedFor:    now.Sub(c.ConnectedAt).Seconds(),
+			QueuedCritical:  critical,
+			QueuedUpdates:   updates,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ConnectedFor > list[j].ConnectedFor })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func handleLobbies(w http.ResponseWriter, r *http.Request) {
+	session.LobbyMu.RLock()
+	list := make([]lobbyInfo, 0, len(session.Lobbies))
+	for _, room := range session.Lobbies {
+		info := lobbyInfo{
+			ID:         room.ID,
+			Type:       room.Type,
+			MaxSize:    room.MaxSize,
+			Matching:   room.Match,
+			Players:    make([]slotInfo, 0, len(room.Slots)),
+			AgeSeconds: time.Since(room.CreatedAt).Seconds(),
+		}
+		for _, slot := range room.Slots {
+			if slot.Client != nil {
+				info.Players = append(info.Players, slotInfo{Slot: slot.ID, UserID: slot.Client.UserID()})
+			}
+		}
+		list = append(list, info)
+	}
+	session.LobbyMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].AgeSeconds > list[j].AgeSeconds })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func handleMatches(w http.ResponseWriter, r *http.Request) {
+	session.MatchesMu.RLock()
+	list := make([]matchInfo, 0, len(session.Matches))
+	for _, match := range session.Matches {
+		info := matchInfo{
+			ID:             match.ID,
+			Type:           match.Type,
+			Players:        make([]playerInfo, 0, len(match.User)),
+			Tick:           match.Tick.Load(),
+			ElapsedSeconds: time.Since(match.StartedAt).Seconds(),
+		}
+		for _, user := range match.User {
+			c := user.CurrentClient()
+			info.Players = append(info.Players, playerInfo{
+				UserID:    user.ID,
+				Connected: c != nil && !c.IsClosed(),
+			})
+		}
+		list = append(list, info)
+	}
+	session.MatchesMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ElapsedSeconds > list[j].ElapsedSeconds })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func handleKick(w http.ResponseWriter, r *http.Request) {
+	var req kickRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.UserID == 0 && req.SessionID == "" {
+		writeJSON(w, http.StatusBadRequest, errorBody("missing_fields", "user_id or session_id required"))
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "Disconnected by an administrator"
+	}
+
+	kicked := 0
+	for _, c := range clients() {
+		st := c.State()
+		if (req.UserID != 0 && st.UserID == req.UserID) || (req.SessionID != "" && st.SessionID == req.SessionID) {
+			utils.Kick(c, "kicked", req.Reason)
+			kicked++
+		}
+	}
+	if kicked == 0 {
+		writeJSON(w, http.StatusNotFound, errorBody("client_not_found", "No matching client connected"))
+		return
+	}
+	log.Printf("Admin kicked %d client(s): %s", kicked, req.Reason)
+	writeJSON(w, http.StatusOK, map[string]int{"kicked": kicked})
+}
+
+func handleCloseLobby(w http.ResponseWriter, r *http.Request) {
+	var req closeLobbyRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "Lobby was closed by an administrator"
+	}
+
+	id := r.PathValue("id")
+	if !utils.CloseLobbyRoom(id, "lobby_closed", req.Reason) {
+		writeJSON(w, http.StatusNotFound, errorBody("lobby_not_found", "Lobby not found"))
+		return
+	}
+	log.Printf("Admin closed lobby %s: %s", id, req.Reason)
+	writeJSON(w, http.StatusOK, map[string]string{"lobby_id": id})
+}
+
+func handleEndMatch(w http.ResponseWriter, r *http.Request) {
+	var req endMatchRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	var winner int
+	switch req.Result {
+	case "side0":
+		winner = 0
+	case "side1":
+		winner = 1
+	case "draw":
+		winner = -1
+	default:
+		writeJSON(w, http.StatusBadRequest, errorBody("invalid_result", "result must be side0, side1 or draw"))
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "admin"
+	}
+
+	id := r.PathValue("id")
+	match := session.FindMatch(id)
+	if match == nil || match.ForceEnd == nil {
+		writeJSON(w, http.StatusNotFound, errorBody("match_not_found", "Match not found or already finished"))
+		return
+	}
+
+	select {
+	case match.ForceEnd <- session.ForceEndRequest{Winner: winner, Reason: req.Reason}:
+	default:
+		writeJSON(w, http.StatusConflict, errorBody("already_ending", "Match is already being ended"))
+		return
+	}
+	log.Printf("Admin ended match %s with %s: %s", id, req.Result, req.Reason)
+	writeJSON(w, http.StatusAccepted, map[string]string{"match_id": id, "result": req.Result})
+}
+
+func handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	var req broadcastRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		writeJSON(w, http.StatusBadRequest, errorBody("missing_fields", "message required"))
+		return
+	}
+	if req.Level == "" {
+		req.Level = "info"
+	}
+
+	sent := 0
+	for _, c := range clients() {
+		if !c.Greeted() {
+			continue
+		}
+		utils.SendMessage(c, "", "server_message", map[string]string{
+			"message": req.Message,
+			"level":   req.Level,
+		})
+		sent++
+	}
+	log.Printf("Admin broadcast to %d client(s): %s", sent, req.Message)
+	writeJSON(w, http.StatusOK, map[string]int{"sent": sent})
+}
+
+// clients chụp danh sách client đang kết nối để không giữ khóa khi gửi message
+func clients() []*types.Client {
+	session.ClientsMu.RLock()
+	defer session.ClientsMu.RUnlock()
+	list := make([]*types.Client, 0, len(session.Clients))
+	for c := range session.Clients {
+		list = append(list, c)
+	}
+	return list
+}
+
+// requireAdmin so khớp Bearer token với ADMIN_TOKEN (so sánh thời gian hằng)
+func requireAdmin(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.Config.AdminToken)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, errorBody("unauthorized", "Invalid admin token"))
+			return
+		}
+		h(w, r)
+	})
+}
+
+// decode đọc body JSON (tối đa 64KB); body rỗng được coi là {}
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, errorBody("invalid_payload", "Invalid JSON body"))
+		return false
+	}
+	return true
+}
+
+func errorBody(code, message string) map[string]string {
+	return map[string]string{"error": code, "message": message}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,382 @@
+// Package api là HTTP JSON API cho tài khoản, bộ sưu tập và deck.
+//
+// Dùng chung logic với handler WebSocket qua package service. Endpoint cần
+// đăng nhập nhận token trong header "Authorization: Bearer <token>". Lỗi trả về
+// dạng {"error": "<mã>", "message": "..."} với cùng mã lỗi như WebSocket. Endpoint
+// đăng nhập, tài khoản và email bị giới hạn tần suất theo IP (429 rate_limited).
+//
+//	POST /api/register    {gmail, username, password} -> tokenResponse
+//	POST /api/login       {gmail, password}           -> tokenResponse
+//	POST /api/refresh     {refresh_token}             -> tokenResponse
+//	POST /api/logout                                  -> {sessions}
+//	POST /api/logout_all                              -> {sessions}
+//	POST /api/email/verify       {token}              -> {user_id}
+//	POST /api/email/resend                            -> {sent}
+//	POST /api/password/forgot    {gmail}              -> {sent}
+//	POST /api/password/reset     {token, password}    -> {reset}
+//	POST /api/account/username   {password, username} -> service.Profile
+//	POST /api/account/email      {password, gmail}    -> service.Profile
+//	POST /api/account/password   {old_password, new_password} -> {changed}
+//	POST /api/account/delete     {password}           -> {deleted}
+//	GET  /api/profile                                 -> service.Profile
+//	GET  /api/cards                                   -> service.UserCards
+//	GET  /api/deck                                    -> service.UserDeck
+//	POST /api/deck/swap   {card_name, slot_index}     -> service.UserDeck
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"server/internal/config"
+	"server/internal/service"
+	"server/internal/session"
+	"server/internal/utils"
+)
+
+type credentialsRequest struct {
+	Gmail    string `json:"gmail"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	Username     string `json:"username"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func newTokenResponse(acc *service.Account) tokenResponse {
+	return tokenResponse{
+		Token:        acc.Token,
+		Username:     acc.Username,
+		RefreshToken: acc.RefreshToken,
+		ExpiresIn:    acc.ExpiresIn(),
+	}
+}
+
+type tokenRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type swapCardRequest struct {
+	CardName  string `json:"card_name"`
+	SlotIndex int    `json:"slot_index"`
+}
+
+// authedHandler là handler cần đăng nhập, nhận tài khoản đã xác thực
+type authedHandler func(w http.ResponseWriter, r *http.Request, acc *service.Account)
+
+// Register gắn các route API vào mux
+func Register(mux *http.ServeMux) {
+	// Endpoint gọi được khi chưa đăng nhập bị giới hạn theo IP, cùng mức với route
+	// WebSocket tương ứng, để chặn dò mật khẩu và lợi dụng server gửi thư
+	mux.Handle("POST /api/register", cors(rateLimit(5, time.Minute, http.HandlerFunc(handleRegister))))
+	mux.Handle("POST /api/login", cors(rateLimit(10, time.Minute, http.HandlerFunc(handleLogin))))
+	mux.Handle("POST /api/refresh", cors(rateLimit(10, time.Minute, http.HandlerFunc(handleRefresh))))
+	mux.Handle("POST /api/logout", cors(requireAuth(handleLogout)))
+	mux.Handle("POST /api/logout_all", cors(requireAuth(handleLogoutAll)))
+	mux.Handle("POST /api/email/verify", cors(rateLimit(10, time.Minute, http.HandlerFunc(handleVerifyEmail))))
+	mux.Handle("POST /api/email/resend", cors(rateLimit(3, time.Minute, requireAuth(handleResendVerification))))
+	mux.Handle("POST /api/password/forgot", cors(rateLimit(3, time.Minute, http.HandlerFunc(handleForgotPassword))))
+	mux.Handle("POST /api/password/reset", cors(rateLimit(5, time.Minute, http.HandlerFunc(handleResetPassword))))
+	mux.Handle("POST /api/account/username", cors(rateLimit(5, time.Minute, requireAuth(handleChangeUsername))))
+	mux.Handle("POST /api/account/email", cors(rateLimit(3, time.Minute, requireAuth(handleChangeEmail))))
+	mux.Handle("POST /api/account/password", cors(rateLimit(5, time.Minute, requireAuth(handleChangePassword))))
+	mux.Handle("POST /api/account/delete", cors(rateLimit(3, time.Minute, requireAuth(handleDeleteAccount))))
+	mux.Handle("GET /api/profile", cors(requireAuth(handleProfile)))
+	mux.Handle("GET /api/cards", cors(requireAuth(handleCards)))
+	mux.Handle("GET /api/deck", cors(requireAuth(handleDeck)))
+	mux.Handle("POST /api/deck/swap", cors(requireAuth(handleSwapCard)))
+
+	// Preflight của trình duyệt
+	mux.Handle("OPTIONS /api/", cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+}
+
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	var req credentialsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	acc, err := service.Register(req.Gmail, req.Username, req.Password)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newTokenResponse(acc))
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req credentialsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	acc, err := service.Login(req.Gmail, req.Password)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newTokenResponse(acc))
+}
+
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	acc, err := service.Refresh(req.RefreshToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newTokenResponse(acc))
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request, acc *service.Account) {
+	if err := service.Logout(acc.UserID, acc.SessionID); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"sessions": 1})
+}
+
+func handleLogoutAll(w http.ResponseWriter, r *http.Request, acc *service.Account) {
+	n, err := service.LogoutAll(acc.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"sessions": n})
+}
+
+func handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	userID, err := service.VerifyEmail(req.Token)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"user_id": userID})
+}
+
+func handleResendVerification(w http.ResponseWriter, r *http.Request, acc *service.Account) {
+	if err := service.ResendVerification(acc.UserID); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"sent": true})
+}
+
+func handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req credentialsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := service.RequestPasswordReset(req.Gmail); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"sent": true})
+}
+
+func handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := service.ResetPassword(req.Token, req.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"reset": true})
+}
+
+func handleChangeUsername(w http.ResponseWriter, r *http.Request, acc *service.Account) {
+	var req credentialsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	profile, err := service.ChangeUsername(acc.UserID, req.Password, req.Username)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func handleChangeEmail(w http.ResponseWriter, r *http.Request, acc *service.Account) {
+	var req credentialsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	profile, err := service.ChangeEmail(acc.UserID, req.Password, req.Gmail)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func handleChangePassword(w http.ResponseWriter, r *http.Request, acc *service.Account) {
+	var req changePasswordRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := service.ChangePassword(acc.UserID, req.OldPassword, req.NewPassword, acc.SessionID); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"changed": true})
+}
+
+// handleDeleteAccount xóa tài khoản; kết nối WebSocket đang mở của user bị ngắt.
+// Xóa chưa xong (deletion_pending) vẫn trả 202 kèm mã lỗi để client biết server sẽ tự hoàn tất.
+func handleDeleteAccount(w http.ResponseWriter, r *http.Request, acc *service.Account) {
+	var req credentialsRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if session.FindMatchByUser(acc.UserID) != nil {
+		writeJSON(w, http.StatusConflict, errorBody("in_match", "Cannot delete account during a match"))
+		return
+	}
+	// Kết nối WebSocket của user đang giữ slot phòng chờ: trận bắt đầu sẽ ghi thưởng cho id đã xóa
+	if utils.LobbyOf(session.ClientByUser(acc.UserID)) != "" {
+		writeJSON(w, http.StatusConflict, errorBody("in_lobby", "Leave the lobby before you delete the account"))
+		return
+	}
+
+	err := service.DeleteAccount(acc.UserID, req.Password)
+	if err != nil && service.AsError(err).Code != "deletion_pending" {
+		writeError(w, err)
+		return
+	}
+
+	if c := session.ClientByUser(acc.UserID); c != nil {
+		session.UnbindUser(c)
+		utils.Kick(c, "account_deleted", "This account has been deleted")
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"deleted": true})
+}
+
+func handleProfile(w http.ResponseWriter, r *http.Request, acc *service.Account) {
+	profile, err := service.GetProfile(acc.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func handleCards(w http.ResponseWriter, r *http.Request, acc *service.Account) {
+	cards, err := service.GetUserCards(acc.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cards)
+}
+
+func handleDeck(w http.ResponseWriter, r *http.Request, acc *service.Account) {
+	deck, err := service.GetUserDeck(acc.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deck)
+}
+
+func handleSwapCard(w http.ResponseWriter, r *http.Request, acc *service.Account) {
+	var req swapCardRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	deck, err := service.SwapCard(acc.UserID, req.CardName, req.SlotIndex)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deck)
+}
+
+// requireAuth xác thực Bearer token trước khi gọi h
+func requireAuth(h authedHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			writeJSON(w, http.StatusUnauthorized, errorBody("unauthorized", "Missing bearer token"))
+			return
+		}
+		acc, err := service.ValidateToken(token)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		h(w, r, acc)
+	})
+}
+
+// cors thêm header CORS cho origin nằm trong CORS_ORIGINS ("*" = mọi origin)
+func cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && originAllowed(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func originAllowed(origin string) bool {
+	return utils.OriginAllowed(origin, config.Config.CORSOrigins)
+}
+
+// decode đọc body JSON (tối đa 1MB), trả false và ghi lỗi nếu không hợp lệ
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody("invalid_payload", "Invalid JSON body"))
+		return false
+	}
+	return true
+}
+
+func errorBody(code, message string) map[string]string {
+	return map[string]string{"error": code, "message": message}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	e := service.AsError(err)
+	writeJSON(w, e.Status, errorBody(e.Code, e.Message))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
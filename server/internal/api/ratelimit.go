@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ipLimiter giới hạn tần suất gọi một endpoint theo địa chỉ IP (token bucket),
+// cùng cách tính với router.RateLimiter của WebSocket
+type ipLimiter struct {
+	mu      sync.Mutex
+	n       int
+	per     time.Duration
+	buckets map[string]*ipBucket
+	swept   time.Time
+}
+
+type ipBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newIPLimiter(n int, per time.Duration) *ipLimiter {
+	return &ipLimiter{n: n, per: per, buckets: make(map[string]*ipBucket), swept: time.Now()}
+}
+
+func (l *ipLimiter) allow(ip string) (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, found := l.buckets[ip]
+	if !found {
+		b = &ipBucket{tokens: float64(l.n), last: now}
+		l.buckets[ip] = b
+	}
+
+	rate := float64(l.n) / l.per.Seconds()
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(l.n) {
+		b.tokens = float64(l.n)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep xóa bucket đã nạp đầy (IP không gọi trong cả khoảng per), tối đa mỗi phút một lần
+func (l *ipLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for ip, b := range l.buckets {
+		if now.Sub(b.last) >= l.per {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// rateLimit cho phép mỗi IP gọi next tối đa n lần trong khoảng per, vượt quá trả 429
+func rateLimit(n int, per time.Duration, next http.Handler) http.Handler {
+	l := newIPLimiter(n, per)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := l.allow(clientIP(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			writeJSON(w, http.StatusTooManyRequests, errorBody("rate_limited", "Too many requests, slow down"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP lấy IP từ kết nối; không tin X-Forwarded-For vì client tự đặt được
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -2,108 +2,370 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"log"
 	"time"
 
 	"server/internal/config"
 	"server/internal/db"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var jwtKey = []byte(config.Config.JWTSecret)
+// Mỗi lần đăng nhập tạo một phiên (một document trong user_tokens):
+//   - access token: JWT sống ACCESS_TOKEN_TTL phút, mang session id (sid)
+//   - refresh token: chuỗi ngẫu nhiên, DB chỉ lưu sha256; mỗi lần refresh được
+//     thay bằng token mới. Dùng lại refresh token đã thay = bị lộ → thu hồi cả phiên.
+//   - expires_at của phiên lùi lại mỗi lần refresh; TTL index tự xóa phiên hết hạn.
+
+const tokensCollection = "user_tokens"
+
+var (
+	ErrTokenInvalid = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenRevoked = errors.New("token revoked")
+	ErrRefreshReuse = errors.New("refresh token reused, session revoked")
+)
 
 type Claims struct {
-	ID       int    `json:"id"`
-	Gmail    string `json:"gmail"`
-	Username string `json:"username"`
+	ID        int    `json:"id"`
+	Gmail     string `json:"gmail"`
+	Username  string `json:"username"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken tạo JWT token không có thời hạn và lưu vào MongoDB (tạo bản ghi mới)
-func GenerateToken(id int, gmail, username string) (string, error) {
-	claims := &Claims{
-		ID:       id,
-		Gmail:    gmail,
-		Username: username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt: jwt.NewNumericDate(time.Now()),
-			// Không có ExpiresAt => token vĩnh viễn
+// Tokens là cặp token trả cho client sau khi đăng nhập hoặc refresh
+type Tokens struct {
+	UserID       int
+	Gmail        string
+	Username     string
+	SessionID    string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time // hạn của access token
+}
+
+// maxPreviousHashes là số refresh token đã thay được giữ lại để phát hiện dùng lại.
+// Token cũ hơn nữa bị dùng lại chỉ nhận ErrTokenInvalid, không khóa cả phiên.
+const maxPreviousHashes = 20
+
+// tokenSession là document trong user_tokens
+type tokenSession struct {
+	SessionID      string    `bson:"session_id"`
+	UserID         int       `bson:"id"`
+	Gmail          string    `bson:"gmail"`
+	Username       string    `bson:"username"`
+	RefreshHash    string    `bson:"refresh_hash"`
+	PreviousHashes []string  `bson:"previous_hashes"` // tối đa maxPreviousHashes hash gần nhất
+	Revoked        bool      `bson:"revoked"`
+	RevokedReason  string    `bson:"revoked_reason,omitempty"`
+	CreatedAt      time.Time `bson:"created_at"`
+	ActiveAt       time.Time `bson:"active_at"`
+	ExpiresAt      time.Time `bson:"expires_at"`
+}
+
+// sessionStore lưu phiên đăng nhập. rotate phải đổi refresh hash nguyên tử để một
+// refresh token chỉ đổi được một lần. Test thay bằng bản lưu trong bộ nhớ.
+type sessionStore interface {
+	insert(ctx context.Context, s tokenSession) error
+	// rotate thay refreshHash bằng nextHash trên phiên còn hiệu lực, trả phiên sau khi
+	// đổi hoặc mongo.ErrNoDocuments khi refreshHash không phải token hiện tại
+	rotate(ctx context.Context, refreshHash, nextHash string, now time.Time) (*tokenSession, error)
+	// revokeReused thu hồi phiên có refreshHash trong previous_hashes, trả true nếu có
+	revokeReused(ctx context.Context, refreshHash string) (bool, error)
+	// find trả mongo.ErrNoDocuments khi không có phiên
+	find(ctx context.Context, sessionID string, userID int) (*tokenSession, error)
+	touch(ctx context.Context, sessionID string, userID int, now time.Time) error
+}
+
+var sessions sessionStore = mongoSessions{}
+
+type mongoSessions struct{}
+
+func (mongoSessions) insert(ctx context.Context, s tokenSession) error {
+	_, err := db.MongoDatabase.Collection(tokensCollection).InsertOne(ctx, s)
+	return err
+}
+
+func (mongoSessions) rotate(ctx context.Context, refreshHash, nextHash string, now time.Time) (*tokenSession, error) {
+	var s tokenSession
+	err := db.MongoDatabase.Collection(tokensCollection).FindOneAndUpdate(ctx,
+		bson.M{"refresh_hash": refreshHash, "revoked": false, "expires_at": bson.M{"$gt": now}},
+		bson.M{
+			"$set":  bson.M{"refresh_hash": nextHash, "active_at": now, "expires_at": now.Add(refreshTTL())},
+			"$push": bson.M{"previous_hashes": bson.M{"$each": []string{refreshHash}, "$slice": -maxPreviousHashes}},
 		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&s)
+	if err != nil {
+		return nil, err
 	}
+	return &s, nil
+}
 
-	// Tạo chuỗi token
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+func (mongoSessions) revokeReused(ctx context.Context, refreshHash string) (bool, error) {
+	res, err := db.MongoDatabase.Collection(tokensCollection).UpdateOne(ctx,
+		bson.M{"previous_hashes": refreshHash, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_reason": "refresh_reuse"}},
+	)
 	if err != nil {
-		return "", err
+		return false, err
 	}
+	return res.MatchedCount > 0, nil
+}
 
-	// Lưu bản ghi mới vào MongoDB
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (mongoSessions) find(ctx context.Context, sessionID string, userID int) (*tokenSession, error) {
+	var s tokenSession
+	err := db.MongoDatabase.Collection(tokensCollection).FindOne(ctx, bson.M{"session_id": sessionID, "id": userID}).Decode(&s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (mongoSessions) touch(ctx context.Context, sessionID string, userID int, now time.Time) error {
+	_, err := db.MongoDatabase.Collection(tokensCollection).UpdateOne(ctx,
+		bson.M{"session_id": sessionID, "id": userID},
+		bson.M{"$set": bson.M{"active_at": now}},
+	)
+	return err
+}
+
+func accessTTL() time.Duration {
+	return time.Duration(config.Config.AccessTokenTTL) * time.Minute
+}
+
+func refreshTTL() time.Duration {
+	return time.Duration(config.Config.RefreshTokenTTL) * time.Hour
+}
+
+// EnsureTokenIndexes tạo index cho user_tokens (gồm TTL index trên expires_at)
+// và xóa token vĩnh viễn kiểu cũ không thuộc phiên nào
+func EnsureTokenIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	collection := db.MongoDatabase.Collection("user_tokens") // tạo riêng collection nếu cần
-	doc := bson.M{
-		"id":        id,
-		"gmail":     gmail,
-		"username":  username,
-		"token":     token,
-		"active_at": time.Now(),
+	collection := db.MongoDatabase.Collection(tokensCollection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "session_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"session_id": bson.M{"$exists": true}})},
+		{Keys: bson.D{{Key: "refresh_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "previous_hashes", Value: 1}}},
+		{Keys: bson.D{{Key: "id", Value: 1}}},
+	})
+	if err != nil {
+		return err
 	}
 
-	_, err = collection.InsertOne(ctx, doc)
+	res, err := collection.DeleteMany(ctx, bson.M{"session_id": bson.M{"$exists": false}})
 	if err != nil {
-		return "", err
+		return err
 	}
+	if res.DeletedCount > 0 {
+		log.Printf("Removed %d legacy non-expiring tokens", res.DeletedCount)
+	}
+	return nil
+}
 
-	return token, nil
+// GenerateToken mở phiên đăng nhập mới và trả về access token + refresh token
+func GenerateToken(id int, gmail, username string) (*Tokens, error) {
+	refresh, hash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s := tokenSession{
+		SessionID:      uuid.NewString(),
+		UserID:         id,
+		Gmail:          gmail,
+		Username:       username,
+		RefreshHash:    hash,
+		PreviousHashes: []string{},
+		CreatedAt:      now,
+		ActiveAt:       now,
+		ExpiresAt:      now.Add(refreshTTL()),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sessions.insert(ctx, s); err != nil {
+		return nil, err
+	}
+
+	return issue(&s, refresh)
 }
 
+// RefreshToken đổi refresh token lấy cặp token mới. Refresh token cũ hết hiệu lực;
+// nếu nó bị dùng lại, cả phiên bị thu hồi và trả ErrRefreshReuse.
+func RefreshToken(refresh string) (*Tokens, error) {
+	if refresh == "" {
+		return nil, ErrTokenInvalid
+	}
+	hash := hashToken(refresh)
+	next, nextHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := sessions.rotate(ctx, hash, nextHash, time.Now())
+	if err == nil {
+		return issue(s, next)
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	// Không khớp token hiện tại: kiểm tra có phải token đã bị thay (dùng lại) không
+	reused, err := sessions.revokeReused(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		log.Printf("Refresh token reuse detected, session revoked")
+		return nil, ErrRefreshReuse
+	}
+	return nil, ErrTokenInvalid
+}
+
+// ValidateTokenWithMongo kiểm tra chữ ký và hạn của access token, rồi kiểm tra
+// phiên của token vẫn còn hiệu lực trong MongoDB
 func ValidateTokenWithMongo(tokenStr string) (*Claims, error) {
-	// 1. Giải mã token bằng JWT
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if err != nil || !token.Valid {
-		return nil, errors.New("invalid or expired token")
+	token, err := jwt.ParseWithClaims(tokenStr, claims, verifyKey, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrTokenExpired
+	}
+	if err != nil || !token.Valid || claims.SessionID == "" {
+		return nil, ErrTokenInvalid
 	}
 
-	// 2. Truy vấn MongoDB để kiểm tra token
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	collection := db.MongoDatabase.Collection("user_tokens")
+	now := time.Now()
+	s, err := sessions.find(ctx, claims.SessionID, claims.ID)
+	if err != nil {
+		return nil, ErrTokenRevoked
+	}
+	// TTL index xóa phiên hết hạn trễ tới vài phút nên vẫn phải so expires_at
+	if s.Revoked || !s.ExpiresAt.After(now) {
+		return nil, ErrTokenRevoked
+	}
 
-	filter := bson.M{
-		"id":    claims.ID,
-		"token": tokenStr,
+	_ = sessions.touch(ctx, claims.SessionID, claims.ID, now)
+	return claims, nil
+}
+
+// RevokeSession thu hồi một phiên (logout trên thiết bị hiện tại)
+func RevokeSession(userID int, sessionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.MongoDatabase.Collection(tokensCollection).UpdateOne(ctx,
+		bson.M{"session_id": sessionID, "id": userID},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_reason": "logout"}},
+	)
+	return err
+}
+
+// RevokeAllSessions thu hồi mọi phiên của user, trả về số phiên bị thu hồi
+func RevokeAllSessions(userID int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := db.MongoDatabase.Collection(tokensCollection).UpdateMany(ctx,
+		bson.M{"id": userID, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_reason": "logout_all"}},
+	)
+	if err != nil {
+		return 0, err
 	}
+	return res.ModifiedCount, nil
+}
 
-	var result struct {
-		Gmail    string `bson:"gmail"`
-		Username string `bson:"username"`
+// issue ký access token mới cho phiên s
+func issue(s *tokenSession, refresh string) (*Tokens, error) {
+	now := time.Now()
+	expires := now.Add(accessTTL())
+	claims := &Claims{
+		ID:        s.UserID,
+		Gmail:     s.Gmail,
+		Username:  s.Username,
+		SessionID: s.SessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
 	}
 
-	err = collection.FindOne(ctx, filter).Decode(&result)
+	access, err := sign(claims)
 	if err != nil {
-		return nil, errors.New("token not found in database")
+		return nil, err
 	}
+	return &Tokens{
+		UserID:       s.UserID,
+		Gmail:        s.Gmail,
+		Username:     s.Username,
+		SessionID:    s.SessionID,
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresAt:    expires,
+	}, nil
+}
 
-	// 3. Kiểm tra thông tin có khớp với token không
-	if result.Gmail != claims.Gmail || result.Username != claims.Username {
-		return nil, errors.New("token data mismatch")
+func newRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
 	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
 
-	// 4. Cập nhật trường active_at
-	update := bson.M{
-		"$set": bson.M{
-			"active_at": time.Now(),
-		},
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RevokeOtherSessions thu hồi mọi phiên của user trừ phiên keep (ví dụ sau khi đổi mật khẩu)
+func RevokeOtherSessions(userID int, keep string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := db.MongoDatabase.Collection(tokensCollection).UpdateMany(ctx,
+		bson.M{"id": userID, "revoked": false, "session_id": bson.M{"$ne": keep}},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_reason": "credentials_changed"}},
+	)
+	if err != nil {
+		return 0, err
 	}
-	_, _ = collection.UpdateOne(ctx, filter, update)
+	return res.ModifiedCount, nil
+}
 
-	// 5. Trả về toàn bộ claims
-	return claims, nil
+// UpdateSessionIdentity cập nhật gmail/username lưu trong các phiên của user để
+// token cấp lại khi refresh mang thông tin mới
+func UpdateSessionIdentity(userID int, gmail, username string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.MongoDatabase.Collection(tokensCollection).UpdateMany(ctx,
+		bson.M{"id": userID},
+		bson.M{"$set": bson.M{"gmail": gmail, "username": username}},
+	)
+	return err
+}
+
+// DeleteSessions xóa hẳn mọi phiên của user (dùng khi xóa tài khoản)
+func DeleteSessions(ctx context.Context, userID int) error {
+	_, err := db.MongoDatabase.Collection(tokensCollection).DeleteMany(ctx, bson.M{"id": userID})
+	return err
 }
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memSessions là sessionStore trong bộ nhớ, cùng ngữ nghĩa với bản MongoDB
+type memSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*tokenSession
+}
+
+func (m *memSessions) insert(ctx context.Context, s tokenSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.SessionID] = &s
+	return nil
+}
+
+func (m *memSessions) rotate(ctx context.Context, refreshHash, nextHash string, now time.Time) (*tokenSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.sessions {
+		if s.RefreshHash == refreshHash && !s.Revoked && s.ExpiresAt.After(now) {
+			s.RefreshHash = nextHash
+			s.ActiveAt = now
+			s.ExpiresAt = now.Add(refreshTTL())
+			s.PreviousHashes = append(s.PreviousHashes, refreshHash)
+			if len(s.PreviousHashes) > maxPreviousHashes {
+				s.PreviousHashes = s.PreviousHashes[len(s.PreviousHashes)-maxPreviousHashes:]
+			}
+			copied := *s
+			return &copied, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memSessions) revokeReused(ctx context.Context, refreshHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.sessions {
+		if s.Revoked {
+			continue
+		}
+		for _, h := range s.PreviousHashes {
+			if h == refreshHash {
+				s.Revoked = true
+				s.RevokedReason = "refresh_reuse"
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (m *memSessions) find(ctx context.Context, sessionID string, userID int) (*tokenSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sessionID]
+	if !ok || s.UserID != userID {
+		return nil, mongo.ErrNoDocuments
+	}
+	copied := *s
+	return &copied, nil
+}
+
+func (m *memSessions) touch(ctx context.Context, sessionID string, userID int, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[sessionID]; ok && s.UserID == userID {
+		s.ActiveAt = now
+	}
+	return nil
+}
+
+// useTestSessions thay kho phiên và khóa ký bằng bản dùng cho test
+func useTestSessions(t *testing.T) *memSessions {
+	t.Helper()
+	k, err := ParseKeyring("a:"+strongA, "a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyring(k)
+	store := &memSessions{sessions: make(map[string]*tokenSession)}
+	old := sessions
+	sessions = store
+	t.Cleanup(func() {
+		sessions = old
+		SetKeyring(nil)
+	})
+	return store
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	useTestSessions(t)
+
+	first, err := GenerateToken(7, "a@example.com", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := RefreshToken(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+	if second.SessionID != first.SessionID || second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh did not rotate the token within the same session")
+	}
+	if _, err := ValidateTokenWithMongo(second.AccessToken); err != nil {
+		t.Fatalf("new access token rejected: %v", err)
+	}
+	if _, err := RefreshToken("never-issued"); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("unknown refresh token: %v, want ErrTokenInvalid", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	store := useTestSessions(t)
+
+	first, err := GenerateToken(7, "a@example.com", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := RefreshToken(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Token đã thay bị dùng lại: cả phiên bị thu hồi, kể cả token mới nhất
+	if _, err := RefreshToken(first.RefreshToken); !errors.Is(err, ErrRefreshReuse) {
+		t.Fatalf("reused refresh token: %v, want ErrRefreshReuse", err)
+	}
+	if s := store.sessions[first.SessionID]; !s.Revoked || s.RevokedReason != "refresh_reuse" {
+		t.Fatalf("session after reuse = revoked %v (%s), want revoked refresh_reuse", s.Revoked, s.RevokedReason)
+	}
+	if _, err := RefreshToken(second.RefreshToken); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("latest refresh token after reuse: %v, want ErrTokenInvalid", err)
+	}
+	if _, err := ValidateTokenWithMongo(second.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("access token after reuse: %v, want ErrTokenRevoked", err)
+	}
+}
+
+func TestValidateTokenRejectsExpiredSession(t *testing.T) {
+	store := useTestSessions(t)
+
+	tok, err := GenerateToken(7, "a@example.com", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Phiên đã hết hạn nhưng TTL index chưa kịp xóa
+	store.sessions[tok.SessionID].ExpiresAt = time.Now().Add(-time.Minute)
+
+	if _, err := ValidateTokenWithMongo(tok.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("access token of expired session: %v, want ErrTokenRevoked", err)
+	}
+	if _, err := RefreshToken(tok.RefreshToken); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("refresh of expired session: %v, want ErrTokenInvalid", err)
+	}
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"server/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Keyring giữ các khóa ký JWT theo kid. Khóa active ký token mới; các khóa còn lại
+// chỉ dùng để kiểm tra token cũ cho tới khi bị gỡ khỏi JWT_KEYS.
+//
+// Xoay khóa: thêm khóa mới vào JWT_KEYS, đổi JWT_ACTIVE_KID sang khóa mới, rồi gỡ
+// khóa cũ sau khi mọi access token ký bằng nó đã hết hạn (ACCESS_TOKEN_TTL).
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+// minKeyLength là độ dài tối thiểu (byte) của một khóa HS256
+const minKeyLength = 32
+
+// legacyKID là kid của khóa lấy từ JWT_SECRET khi không cấu hình JWT_KEYS
+const legacyKID = "default"
+
+var (
+	keyringMu sync.RWMutex
+	keyring   *Keyring
+)
+
+// ParseKeyring đọc danh sách "kid:secret,kid:secret". spec rỗng thì dùng
+// legacySecret với kid "default". Trả lỗi nếu thiếu khóa, khóa yếu hoặc active
+// không có trong danh sách.
+func ParseKeyring(spec, active, legacySecret string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte)}
+
+	if strings.TrimSpace(spec) == "" {
+		if legacySecret == "" {
+			return nil, errors.New("no JWT signing key: set JWT_KEYS or JWT_SECRET")
+		}
+		spec = legacyKID + ":" + legacySecret
+		if active == "" {
+			active = legacyKID
+		}
+	}
+
+	// Lỗi chỉ nêu vị trí mục, không in nội dung để khóa không lọt vào log khởi động
+	for i, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, secret, ok := strings.Cut(entry, ":")
+		kid = strings.TrimSpace(kid)
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("JWT_KEYS entry #%d is not kid:secret", i+1)
+		}
+		if _, dup := k.keys[kid]; dup {
+			return nil, fmt.Errorf("duplicate JWT key id %q", kid)
+		}
+		if err := checkKeyStrength(secret); err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", kid, err)
+		}
+		k.keys[kid] = []byte(secret)
+	}
+
+	if len(k.keys) == 0 {
+		return nil, errors.New("no JWT signing key configured")
+	}
+	if active == "" {
+		return nil, errors.New("JWT_ACTIVE_KID must be set when JWT_KEYS has keys")
+	}
+	if _, ok := k.keys[active]; !ok {
+		return nil, fmt.Errorf("JWT_ACTIVE_KID %q is not in JWT_KEYS", active)
+	}
+	k.active = active
+	return k, nil
+}
+
+// checkKeyStrength từ chối khóa quá ngắn hoặc có quá ít ký tự khác nhau
+// (ví dụ "aaaa..." hay giá trị mẫu bị lặp lại)
+func checkKeyStrength(secret string) error {
+	if len(secret) < minKeyLength {
+		return fmt.Errorf("secret is %d bytes, need at least %d", len(secret), minKeyLength)
+	}
+	distinct := make(map[byte]bool)
+	for i := 0; i < len(secret); i++ {
+		distinct[secret[i]] = true
+	}
+	if len(distinct) < 10 {
+		return errors.New("secret has too little variety")
+	}
+	return nil
+}
+
+// InitKeyring nạp khóa ký từ config; dừng server nếu cấu hình thiếu hoặc yếu
+func InitKeyring() {
+	k, err := ParseKeyring(config.Config.JWTKeys, config.Config.JWTActiveKID, config.Config.JWTSecret)
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+	SetKeyring(k)
+	log.Printf("Loaded %d JWT signing keys, active kid %q", len(k.keys), k.active)
+}
+
+// SetKeyring thay keyring đang dùng
+func SetKeyring(k *Keyring) {
+	keyringMu.Lock()
+	keyring = k
+	keyringMu.Unlock()
+}
+
+func currentKeyring() (*Keyring, error) {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	if keyring == nil {
+		return nil, errors.New("JWT keyring not initialized")
+	}
+	return keyring, nil
+}
+
+// sign ký claims bằng khóa active và ghi kid vào header
+func sign(claims jwt.Claims) (string, error) {
+	k, err := currentKeyring()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = k.active
+	return token.SignedString(k.keys[k.active])
+}
+
+// verifyKey là jwt.Keyfunc: chọn khóa theo kid trong header token
+func verifyKey(token *jwt.Token) (interface{}, error) {
+	k, err := currentKeyring()
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = legacyKID
+	}
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	strongA = "k1-9f8e7d6c5b4a3928170615f4e3d2c1b0"
+	strongB = "k2-0a1b2c3d4e5f60718293a4b5c6d7e8f9"
+)
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       string
+		active     string
+		legacy     string
+		wantActive string
+		wantErr    bool
+	}{
+		{name: "legacy secret", legacy: strongA, wantActive: legacyKID},
+		{name: "keys with active", spec: "a:" + strongA + ", b:" + strongB, active: "b", wantActive: "b"},
+		{name: "keys override legacy", spec: "a:" + strongA, active: "a", legacy: strongB, wantActive: "a"},
+		{name: "no key", wantErr: true},
+		{name: "missing active", spec: "a:" + strongA, wantErr: true},
+		{name: "unknown active", spec: "a:" + strongA, active: "c", wantErr: true},
+		{name: "short key", spec: "a:short", active: "a", wantErr: true},
+		{name: "low variety key", spec: "a:" + "abababababababababababababababababab", active: "a", wantErr: true},
+		{name: "weak legacy secret", legacy: "changeme", wantErr: true},
+		{name: "missing colon", spec: strongA, active: "a", wantErr: true},
+		{name: "duplicate kid", spec: "a:" + strongA + ",a:" + strongB, active: "a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKeyring(tt.spec, tt.active, tt.legacy)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseKeyring succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeyring: %v", err)
+			}
+			if k.active != tt.wantActive {
+				t.Fatalf("active = %q, want %q", k.active, tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestParseKeyringErrorHidesSecret(t *testing.T) {
+	_, err := ParseKeyring("a:"+strongA+","+strongB, "a", "")
+	if err == nil {
+		t.Fatal("ParseKeyring succeeded, want error")
+	}
+	if strings.Contains(err.Error(), strongB) || !strings.Contains(err.Error(), "#2") {
+		t.Fatalf("error = %q, want entry position without the secret", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old, err := ParseKeyring("a:"+strongA, "a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyring(old)
+	t.Cleanup(func() { SetKeyring(nil) })
+
+	claims := jwt.MapClaims{"sub": "1"}
+	signedOld, err := sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Khóa mới ký token mới, token ký bằng khóa cũ vẫn hợp lệ khi khóa cũ còn trong danh sách
+	rotated, err := ParseKeyring("a:"+strongA+",b:"+strongB, "b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyring(rotated)
+	signedNew, err := sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{signedOld, signedNew} {
+		if _, err := jwt.Parse(s, verifyKey); err != nil {
+			t.Fatalf("verify after rotation: %v", err)
+		}
+	}
+	tok, _, err := jwt.NewParser().ParseUnverified(signedNew, jwt.MapClaims{})
+	if err != nil || tok.Header["kid"] != "b" {
+		t.Fatalf("new token kid = %v, want b", tok.Header["kid"])
+	}
+
+	// Gỡ khóa cũ: token cũ bị từ chối
+	removed, err := ParseKeyring("b:"+strongB, "b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyring(removed)
+	if _, err := jwt.Parse(signedOld, verifyKey); err == nil {
+		t.Fatal("token signed with removed key still verifies")
+	}
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"server/internal/config"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Mật khẩu được lưu dạng chuỗi PHC của argon2id, mỗi user một salt ngẫu nhiên:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt base64>$<hash base64>
+//
+// Tham số nằm ngay trong chuỗi nên đổi ARGON2_* không làm hỏng hash cũ;
+// hash tạo bằng tham số cũ được băm lại ở lần đăng nhập thành công kế tiếp.
+
+const argon2Prefix = "$argon2id$"
+
+var errInvalidHash = errors.New("invalid argon2id hash")
+
+type argon2Params struct {
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+	KeyLen  uint32
+}
+
+// validate kiểm tra tham số mà argon2.IDKey chấp nhận (giá trị 0 làm IDKey panic)
+func (p argon2Params) validate() error {
+	if p.Time < 1 {
+		return errors.New("argon2 time must be at least 1")
+	}
+	if p.Threads < 1 {
+		return errors.New("argon2 threads must be between 1 and 255")
+	}
+	if p.Memory < 8*uint32(p.Threads) {
+		return fmt.Errorf("argon2 memory must be at least 8 KiB per thread (%d KiB)", 8*uint32(p.Threads))
+	}
+	return nil
+}
+
+// CheckPasswordParams kiểm tra ARGON2_* lúc khởi động, trước khi có ai đăng nhập
+func CheckPasswordParams() error {
+	c := config.Config
+	if c.Argon2Memory < 1 || c.Argon2Time < 1 || c.Argon2Threads < 1 || c.Argon2Threads > 255 {
+		return fmt.Errorf("invalid ARGON2_MEMORY=%d, ARGON2_TIME=%d, ARGON2_THREADS=%d: all must be at least 1 and threads at most 255",
+			c.Argon2Memory, c.Argon2Time, c.Argon2Threads)
+	}
+	if err := currentParams().validate(); err != nil {
+		return fmt.Errorf("invalid ARGON2_* settings: %w", err)
+	}
+	return nil
+}
+
+func currentParams() argon2Params {
+	return argon2Params{
+		Memory:  uint32(config.Config.Argon2Memory),
+		Time:    uint32(config.Config.Argon2Time),
+		Threads: uint8(config.Config.Argon2Threads),
+		KeyLen:  32,
+	}
+}
+
+// HashPassword băm mật khẩu bằng argon2id với salt ngẫu nhiên và tham số hiện tại
+func HashPassword(password string) (string, error) {
+	p := currentParams()
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version,
+		p.Memory, p.Time, p.Threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// VerifyPassword so mật khẩu với giá trị lưu trong DB (so sánh thời gian hằng).
+// needsRehash = true khi giá trị lưu là mật khẩu thô (dữ liệu cũ) hoặc hash dùng
+// tham số khác hiện tại; khi đó nên lưu lại HashPassword(password).
+func VerifyPassword(password, stored string) (ok bool, needsRehash bool) {
+	if !strings.HasPrefix(stored, argon2Prefix) {
+		// Dòng cũ lưu mật khẩu thô
+		ok = subtle.ConstantTimeCompare([]byte(password), []byte(stored)) == 1
+		return ok, ok
+	}
+
+	p, salt, key, err := decodeHash(stored)
+	if err != nil {
+		return false, false
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false
+	}
+	cur := currentParams()
+	return true, p.Memory != cur.Memory || p.Time != cur.Time || p.Threads != cur.Threads || p.KeyLen != cur.KeyLen
+}
+
+var (
+	dummyOnce sync.Once
+	dummyHash string
+)
+
+// VerifyDummyPassword chạy argon2 giống VerifyPassword với một hash cố định, dùng khi
+// không có user: thời gian phản hồi không cho biết gmail có tồn tại hay không
+func VerifyDummyPassword(password string) {
+	dummyOnce.Do(func() {
+		dummyHash, _ = HashPassword("dummy password for timing equalization")
+	})
+	VerifyPassword(password, dummyHash)
+}
+
+func decodeHash(stored string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, errInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+
+	b64 := base64.RawStdEncoding
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, errInvalidHash
+	}
+	p.KeyLen = uint32(len(key))
+	if p.validate() != nil {
+		return p, nil, nil, errInvalidHash
+	}
+	return p, salt, key, nil
+}
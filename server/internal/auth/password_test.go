@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"server/internal/config"
+)
+
+// useArgon2 đặt tham số argon2 nhỏ cho test và khôi phục khi xong
+func useArgon2(t *testing.T, memory, time, threads int) {
+	t.Helper()
+	c := config.Config
+	old := [3]int{c.Argon2Memory, c.Argon2Time, c.Argon2Threads}
+	c.Argon2Memory, c.Argon2Time, c.Argon2Threads = memory, time, threads
+	t.Cleanup(func() {
+		c.Argon2Memory, c.Argon2Time, c.Argon2Threads = old[0], old[1], old[2]
+	})
+}
+
+func TestHashAndVerifyPassword(t *testing.T) {
+	useArgon2(t, 64, 1, 1)
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected hash format %q", hash)
+	}
+	if other, _ := HashPassword("correct horse"); other == hash {
+		t.Fatal("two hashes of the same password are equal, salt not random")
+	}
+
+	if ok, rehash := VerifyPassword("correct horse", hash); !ok || rehash {
+		t.Fatalf("VerifyPassword = %v, %v; want true, false", ok, rehash)
+	}
+	if ok, _ := VerifyPassword("wrong horse", hash); ok {
+		t.Fatal("wrong password accepted")
+	}
+}
+
+func TestVerifyPasswordNeedsRehash(t *testing.T) {
+	useArgon2(t, 64, 1, 1)
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tăng tham số: hash cũ vẫn đúng nhưng cần băm lại
+	useArgon2(t, 128, 2, 1)
+	if ok, rehash := VerifyPassword("secret", hash); !ok || !rehash {
+		t.Fatalf("VerifyPassword with old params = %v, %v; want true, true", ok, rehash)
+	}
+	// Sai mật khẩu thì không báo cần băm lại
+	if ok, rehash := VerifyPassword("nope", hash); ok || rehash {
+		t.Fatalf("VerifyPassword wrong password = %v, %v; want false, false", ok, rehash)
+	}
+}
+
+func TestVerifyPasswordLegacyPlaintext(t *testing.T) {
+	useArgon2(t, 64, 1, 1)
+
+	if ok, rehash := VerifyPassword("plain", "plain"); !ok || !rehash {
+		t.Fatalf("legacy match = %v, %v; want true, true", ok, rehash)
+	}
+	if ok, rehash := VerifyPassword("other", "plain"); ok || rehash {
+		t.Fatalf("legacy mismatch = %v, %v; want false, false", ok, rehash)
+	}
+}
+
+func TestVerifyPasswordRejectsMalformedHash(t *testing.T) {
+	useArgon2(t, 64, 1, 1)
+
+	for _, stored := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$bad",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5", // t=0 sẽ làm IDKey panic
+		"$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$",
+	} {
+		if ok, _ := VerifyPassword("x", stored); ok {
+			t.Fatalf("malformed hash %q accepted", stored)
+		}
+	}
+}
+
+func TestCheckPasswordParams(t *testing.T) {
+	tests := []struct {
+		memory, time, threads int
+		ok                    bool
+	}{
+		{19456, 2, 1, true},
+		{32, 1, 4, true},
+		{0, 2, 1, false},
+		{19456, 0, 1, false},
+		{19456, 2, 0, false},
+		{19456, 2, 256, false},
+		{31, 1, 4, false},
+	}
+	for _, tt := range tests {
+		useArgon2(t, tt.memory, tt.time, tt.threads)
+		if err := CheckPasswordParams(); (err == nil) != tt.ok {
+			t.Errorf("CheckPasswordParams(m=%d,t=%d,p=%d) = %v, want ok=%v", tt.memory, tt.time, tt.threads, err, tt.ok)
+		}
+	}
+}
+
+func TestVerifyDummyPasswordUsesArgon2(t *testing.T) {
+	useArgon2(t, 64, 1, 1)
+	dummyOnce = sync.Once{}
+	t.Cleanup(func() { dummyOnce = sync.Once{} })
+
+	VerifyDummyPassword("anything")
+	if !strings.HasPrefix(dummyHash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("dummy hash %q does not use current argon2 params", dummyHash)
+	}
+}
@@ -0,0 +1,147 @@
+// Package codec mã hóa/giải mã message WebSocket theo subprotocol client chọn.
+//
+// Client chọn định dạng bằng header Sec-WebSocket-Protocol khi upgrade:
+//   - "clash.json.v1" hoặc không gửi subprotocol: JSON text frame (mặc định)
+//   - "clash.msgpack.v1": MessagePack binary frame
+//
+// Cả hai định dạng dùng cùng cấu trúc message {id, type, data} và cùng tên
+// trường (lấy từ tag json), nên handler không cần biết client dùng định dạng nào.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	SubprotocolJSON    = "clash.json.v1"
+	SubprotocolMsgpack = "clash.msgpack.v1"
+)
+
+// Subprotocols là danh sách server hỗ trợ, theo thứ tự ưu tiên khi client gửi nhiều lựa chọn
+var Subprotocols = []string{SubprotocolMsgpack, SubprotocolJSON}
+
+type Codec interface {
+	// Name trả về subprotocol tương ứng
+	Name() string
+	// Marshal mã hóa message gửi đi
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal giải mã message nhận được vào v (struct có tag json)
+	Unmarshal(data []byte, v interface{}) error
+	// FrameType là loại frame WebSocket dùng khi ghi
+	FrameType() int
+	// Batch gói nhiều message đã mã hóa vào một message {id: "batch", type: "batch", data: [...]}
+	Batch(msgs [][]byte) ([]byte, error)
+}
+
+// BatchType là type của message gói nhiều message trong một frame
+const BatchType = "batch"
+
+var (
+	JSON    Codec = jsonCodec{}
+	Msgpack Codec = msgpackCodec{}
+)
+
+// ForSubprotocol trả về codec theo subprotocol đã thương lượng, mặc định JSON
+func ForSubprotocol(name string) Codec {
+	if name == SubprotocolMsgpack {
+		return Msgpack
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return SubprotocolJSON }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) FrameType() int { return websocket.TextMessage }
+
+func (jsonCodec) Batch(msgs [][]byte) ([]byte, error) {
+	data := make([]json.RawMessage, len(msgs))
+	for i, m := range msgs {
+		data[i] = m
+	}
+	return json.Marshal(batchEnvelope[json.RawMessage]{ID: BatchType, Type: BatchType, Data: data})
+}
+
+type batchEnvelope[T any] struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data []T    `json:"data"`
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return SubprotocolMsgpack }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal giải mã MessagePack rồi chuyển qua JSON để giữ nguyên các payload
+// json.RawMessage mà handler đang dùng. Message từ client nhỏ nên chi phí này không đáng kể.
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetMapDecoder(func(d *msgpack.Decoder) (interface{}, error) {
+		return d.DecodeUntypedMap()
+	})
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(stringKeys(generic))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func (msgpackCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (c msgpackCodec) Batch(msgs [][]byte) ([]byte, error) {
+	data := make([]msgpack.RawMessage, len(msgs))
+	for i, m := range msgs {
+		data[i] = m
+	}
+	return c.Marshal(batchEnvelope[msgpack.RawMessage]{ID: BatchType, Type: BatchType, Data: data})
+}
+
+// stringKeys đổi map[interface{}]interface{} sang map[string]interface{} để json.Marshal được
+func stringKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			if ks, ok := k.(string); ok {
+				m[ks] = stringKeys(val)
+			}
+		}
+		return m
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = stringKeys(val)
+		}
+		return t
+	case []interface{}:
+		for i, val := range t {
+			t[i] = stringKeys(val)
+		}
+		return t
+	}
+	return v
+}
@@ -0,0 +1,164 @@
+package codec
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// incoming có cùng dạng với message client gửi lên: data giữ nguyên để handler tự giải mã
+type incoming struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type payload struct {
+	UserID   int      `json:"user_id"`
+	Features []string `json:"features"`
+	Ratio    float64  `json:"ratio"`
+	Nested   struct {
+		Name string `json:"name"`
+	} `json:"nested"`
+}
+
+func testPayload() payload {
+	p := payload{UserID: 7, Features: []string{"batch", "msgpack"}, Ratio: 0.5}
+	p.Nested.Name = "knight"
+	return p
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSON, Msgpack} {
+		t.Run(c.Name(), func(t *testing.T) {
+			want := testPayload()
+			data, err := c.Marshal(map[string]interface{}{"id": "1", "type": "hello", "data": want})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var msg incoming
+			if err := c.Unmarshal(data, &msg); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if msg.ID != "1" || msg.Type != "hello" {
+				t.Fatalf("envelope = %+v", msg)
+			}
+			var got payload
+			if err := json.Unmarshal(msg.Data, &got); err != nil {
+				t.Fatalf("data is not JSON for handlers: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("data = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestMsgpackUsesJSONFieldNames(t *testing.T) {
+	data, err := Msgpack.Marshal(testPayload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := msgpack.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"user_id", "features", "ratio", "nested"} {
+		if _, ok := m[key]; !ok {
+			t.Fatalf("msgpack map has no %q key: %v", key, m)
+		}
+	}
+	if text, _ := JSON.Marshal(testPayload()); len(data) >= len(text) {
+		t.Fatalf("msgpack %d bytes, JSON %d bytes; want msgpack smaller", len(data), len(text))
+	}
+}
+
+func TestMsgpackRejectsGarbage(t *testing.T) {
+	var msg incoming
+	if err := Msgpack.Unmarshal([]byte{0xc1}, &msg); err == nil {
+		t.Fatal("Unmarshal accepted an invalid msgpack byte")
+	}
+}
+
+func TestForSubprotocol(t *testing.T) {
+	tests := []struct {
+		name      string
+		want      Codec
+		frameType int
+	}{
+		{SubprotocolMsgpack, Msgpack, websocket.BinaryMessage},
+		{SubprotocolJSON, JSON, websocket.TextMessage},
+		{"", JSON, websocket.TextMessage},
+		{"unknown.v9", JSON, websocket.TextMessage},
+	}
+	for _, tt := range tests {
+		c := ForSubprotocol(tt.name)
+		if c != tt.want || c.FrameType() != tt.frameType {
+			t.Errorf("ForSubprotocol(%q) = %s (frame %d), want %s (frame %d)", tt.name, c.Name(), c.FrameType(), tt.want.Name(), tt.frameType)
+		}
+	}
+	if Subprotocols[0] != SubprotocolMsgpack {
+		t.Fatalf("Subprotocols = %v, want msgpack preferred", Subprotocols)
+	}
+}
+
+func TestBatchEnvelope(t *testing.T) {
+	for _, c := range []Codec{JSON, Msgpack} {
+		t.Run(c.Name(), func(t *testing.T) {
+			var msgs [][]byte
+			for _, typ := range []string{"delta", "events", "input_ack"} {
+				m, err := c.Marshal(map[string]interface{}{"id": typ, "type": typ, "data": map[string]int{"tick": 3}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				msgs = append(msgs, m)
+			}
+
+			frame, err := c.Batch(msgs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var batch struct {
+				ID   string            `json:"id"`
+				Type string            `json:"type"`
+				Data []json.RawMessage `json:"data"`
+			}
+			if err := c.Unmarshal(frame, &batch); err != nil {
+				t.Fatalf("Unmarshal batch: %v", err)
+			}
+			if batch.ID != BatchType || batch.Type != BatchType || len(batch.Data) != len(msgs) {
+				t.Fatalf("batch = %s/%s with %d messages, want batch/batch with %d", batch.ID, batch.Type, len(batch.Data), len(msgs))
+			}
+
+			// Mỗi phần tử giải mã giống hệt message gửi riêng, theo đúng thứ tự
+			for i, raw := range batch.Data {
+				var inner, single incoming
+				if err := json.Unmarshal(raw, &inner); err != nil {
+					t.Fatal(err)
+				}
+				if err := c.Unmarshal(msgs[i], &single); err != nil {
+					t.Fatal(err)
+				}
+				if inner.Type != single.Type || string(inner.Data) != string(single.Data) {
+					t.Fatalf("batch[%d] = %s %s, want %s %s", i, inner.Type, inner.Data, single.Type, single.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchEmbedsMessagesVerbatim(t *testing.T) {
+	// JSON: các message đã mã hóa được nhúng nguyên văn, không mã hóa lại thành chuỗi
+	frame, err := JSON.Batch([][]byte{[]byte(`{"id":"a","type":"delta","data":{}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"batch","type":"batch","data":[{"id":"a","type":"delta","data":{}}]}`
+	if string(frame) != want {
+		t.Fatalf("JSON batch = %s, want %s", frame, want)
+	}
+}
@@ -21,7 +21,78 @@ type ConfigStruct struct {
 	WSHost string
 	WSPort int
 
-	JWTSecret string
+	JWTSecret    string // khóa ký duy nhất (kid "default"), chỉ dùng khi JWT_KEYS trống
+	JWTKeys      string // "kid:secret,kid:secret", khóa không còn trong danh sách coi như đã gỡ
+	JWTActiveKID string // kid của khóa dùng để ký token mới
+
+	PvEWaveSet string
+
+	SyncSnapshotInterval int  // số tick giữa hai snapshot đầy đủ
+	SyncHistory          int  // số tick giữ lại làm baseline cho delta
+	SyncStats            bool // đo thêm kích thước update đầy đủ để so sánh băng thông
+
+	ReconnectGrace int // số giây giữ chỗ cho người chơi mất kết nối trước khi xử thua
+
+	SpectatorDelay int // số giây trễ khi phát trận cho người xem
+
+	MinProtocolVersion int // client có protocol_version thấp hơn bị yêu cầu cập nhật
+
+	SendQueueSize    int // số message critical tối đa chờ gửi cho một client
+	SendStuckTimeout int // số giây message critical được phép chờ trước khi ngắt client
+	WriteTimeout     int // số giây tối đa cho một lần ghi xuống socket
+
+	PingInterval int // số giây giữa hai lần ping client
+	PongTimeout  int // số giây không nhận được gì từ client thì coi là mất kết nối
+
+	InputDelayTicks  int // số tick từ client_tick tới tick thực thi input
+	InputMaxLagTicks int // input có client_tick cũ hơn số tick này bị từ chối
+
+	WSCompression      bool // bật permessage-deflate
+	WSCompressionLevel int  // mức nén flate, -2..9 (1 = nhanh nhất)
+
+	CORSOrigins string // danh sách origin được gọi HTTP API, phân cách bởi dấu phẩy; "*" = tất cả
+
+	// Origin được mở WebSocket, phân cách bởi dấu phẩy; "*" = tất cả.
+	// Để trống: chỉ chấp nhận client không gửi Origin (app native) và origin cùng host.
+	WSAllowedOrigins string
+
+	EventQueueSize      int    // sức chứa hàng đợi của mỗi sink sự kiện
+	EventLogFile        string // file JSONL ghi sự kiện, để trống = tắt
+	EventWebhookURL     string // URL nhận sự kiện qua POST, để trống = tắt
+	EventWebhookSecret  string // khóa ký HMAC-SHA256 cho webhook
+	EventWebhookRetries int    // số lần thử lại khi webhook lỗi
+	EventWebhookTimeout int    // giây chờ mỗi request webhook
+	EventCloseTimeout   int    // giây chờ các sink gửi nốt sự kiện khi tắt server
+
+	AdminToken string // token của admin API, để trống = tắt admin API
+
+	ShutdownTimeout int // giây chờ các trận đang chạy kết thúc khi tắt server
+
+	// Tham số argon2id cho mật khẩu. Tăng lên được bất cứ lúc nào: hash cũ vẫn
+	// kiểm tra được và sẽ được băm lại khi user đăng nhập. Server không khởi động
+	// nếu giá trị nhỏ hơn 1 hoặc memory < 8 × threads.
+	Argon2Memory  int // KiB
+	Argon2Time    int // số vòng lặp
+	Argon2Threads int
+
+	AccessTokenTTL  int // phút sống của access token
+	RefreshTokenTTL int // giờ sống của refresh token (tính từ lần refresh gần nhất)
+
+	// Đăng nhập khi tài khoản đang có kết nối khác: "reject" (mặc định) từ chối,
+	// "takeover" ngắt kết nối cũ và chuyển trận đang chơi sang kết nối mới
+	SessionPolicy string
+
+	MailDriver    string // smtp hoặc file
+	MailFrom      string
+	MailOutboxDir string // thư mục ghi thư khi MAIL_DRIVER=file
+	SMTPHost      string
+	SMTPPort      int
+	SMTPUser      string
+	SMTPPassword  string
+	AppURL        string // URL trang web của game, dùng tạo link trong email; trống = chỉ gửi mã
+
+	EmailVerifyTTL   int // giờ sống của mã xác thực email
+	PasswordResetTTL int // phút sống của mã đặt lại mật khẩu
 }
 
 // Global config biến public
@@ -46,6 +117,27 @@ func init() {
 		return val
 	}
 
+	toString := func(envVar string, defaultVal string) string {
+		val := os.Getenv(envVar)
+		if val == "" {
+			return defaultVal
+		}
+		return val
+	}
+
+	toBool := func(envVar string, defaultVal bool) bool {
+		valStr := os.Getenv(envVar)
+		if valStr == "" {
+			return defaultVal
+		}
+		val, err := strconv.ParseBool(valStr)
+		if err != nil {
+			log.Printf("Invalid value for %s: %v\n", envVar, err)
+			return defaultVal
+		}
+		return val
+	}
+
 	Config = &ConfigStruct{
 		MySQLHost:     os.Getenv("MYSQL_HOST"),
 		MySQLPort:     toInt("MYSQL_PORT", 3306),
@@ -56,8 +148,74 @@ func init() {
 		MongoURI: os.Getenv("MONGO_URI"),
 		MongoDB:  os.Getenv("MONGO_DB"),
 
-		WSHost:    os.Getenv("WS_HOST"),
-		WSPort:    toInt("WS_PORT", 8080),
-		JWTSecret: os.Getenv("JWT_SECRET"),
+		WSHost: os.Getenv("WS_HOST"),
+		WSPort: toInt("WS_PORT", 8080),
+
+		JWTSecret:    os.Getenv("JWT_SECRET"),
+		JWTKeys:      os.Getenv("JWT_KEYS"),
+		JWTActiveKID: os.Getenv("JWT_ACTIVE_KID"),
+
+		PvEWaveSet: toString("PVE_WAVE_SET", "Basic Defense"),
+
+		SyncSnapshotInterval: toInt("SYNC_SNAPSHOT_INTERVAL", 10),
+		SyncHistory:          toInt("SYNC_HISTORY", 32),
+		SyncStats:            toBool("SYNC_STATS", false),
+
+		ReconnectGrace: toInt("RECONNECT_GRACE", 30),
+
+		SpectatorDelay: toInt("SPECTATOR_DELAY", 10),
+
+		MinProtocolVersion: toInt("MIN_PROTOCOL_VERSION", 1),
+
+		SendQueueSize:    toInt("SEND_QUEUE_SIZE", 256),
+		SendStuckTimeout: toInt("SEND_STUCK_TIMEOUT", 10),
+		WriteTimeout:     toInt("WRITE_TIMEOUT", 10),
+
+		PingInterval: toInt("PING_INTERVAL", 15),
+		PongTimeout:  toInt("PONG_TIMEOUT", 45),
+
+		InputDelayTicks:  toInt("INPUT_DELAY_TICKS", 1),
+		InputMaxLagTicks: toInt("INPUT_MAX_LAG_TICKS", 2),
+
+		WSCompression:      toBool("WS_COMPRESSION", false),
+		WSCompressionLevel: toInt("WS_COMPRESSION_LEVEL", 1),
+
+		CORSOrigins: toString("CORS_ORIGINS", "*"),
+
+		WSAllowedOrigins: toString("WS_ALLOWED_ORIGINS", ""),
+
+		EventQueueSize:      toInt("EVENT_QUEUE_SIZE", 1024),
+		EventLogFile:        toString("EVENT_LOG_FILE", ""),
+		EventWebhookURL:     toString("EVENT_WEBHOOK_URL", ""),
+		EventWebhookSecret:  toString("EVENT_WEBHOOK_SECRET", ""),
+		EventWebhookRetries: toInt("EVENT_WEBHOOK_RETRIES", 3),
+		EventWebhookTimeout: toInt("EVENT_WEBHOOK_TIMEOUT", 5),
+		EventCloseTimeout:   toInt("EVENT_CLOSE_TIMEOUT", 10),
+
+		AdminToken: toString("ADMIN_TOKEN", ""),
+
+		ShutdownTimeout: toInt("SHUTDOWN_TIMEOUT", 120),
+
+		// Mặc định theo khuyến nghị OWASP: 19 MiB, 2 vòng, 1 luồng
+		Argon2Memory:  toInt("ARGON2_MEMORY", 19456),
+		Argon2Time:    toInt("ARGON2_TIME", 2),
+		Argon2Threads: toInt("ARGON2_THREADS", 1),
+
+		AccessTokenTTL:  toInt("ACCESS_TOKEN_TTL", 15),
+		RefreshTokenTTL: toInt("REFRESH_TOKEN_TTL", 30*24),
+
+		SessionPolicy: toString("SESSION_POLICY", "reject"),
+
+		MailDriver:    toString("MAIL_DRIVER", "file"),
+		MailFrom:      toString("MAIL_FROM", "noreply@localhost"),
+		MailOutboxDir: toString("MAIL_OUTBOX_DIR", "outbox"),
+		SMTPHost:      toString("SMTP_HOST", ""),
+		SMTPPort:      toInt("SMTP_PORT", 587),
+		SMTPUser:      toString("SMTP_USER", ""),
+		SMTPPassword:  toString("SMTP_PASSWORD", ""),
+		AppURL:        toString("APP_URL", ""),
+
+		EmailVerifyTTL:   toInt("EMAIL_VERIFY_TTL", 48),
+		PasswordResetTTL: toInt("PASSWORD_RESET_TTL", 30),
 	}
 }
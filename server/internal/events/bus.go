@@ -0,0 +1,174 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sink nhận sự kiện từ bus. Handle chạy trên goroutine riêng của sink;
+// ctx bị hủy khi bus đóng quá thời hạn, sink nên bỏ lần gửi đang chạy.
+type Sink interface {
+	Name() string
+	Handle(ctx context.Context, e Event) error
+	Close() error
+}
+
+// subscriber là một hàng đợi sự kiện kèm bộ lọc type
+type subscriber struct {
+	name    string
+	types   map[Type]bool // rỗng = nhận tất cả
+	queue   chan Event
+	handle  func(context.Context, Event) error
+	onClose func() error
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+func (s *subscriber) wants(t Type) bool {
+	return len(s.types) == 0 || s.types[t]
+}
+
+func (s *subscriber) run(ctx context.Context) {
+	defer close(s.done)
+	abandoned := 0
+	for e := range s.queue {
+		// Bus đã hết thời hạn đóng: bỏ phần còn lại của hàng đợi
+		if ctx.Err() != nil {
+			abandoned++
+			continue
+		}
+		if err := s.handle(ctx, e); err != nil {
+			log.Printf("Event sink %s failed on %s %s: %v", s.name, e.Type, e.ID, err)
+		}
+	}
+	if abandoned > 0 {
+		log.Printf("Event sink %s abandoned %d events on shutdown", s.name, abandoned)
+	}
+}
+
+// Bus phát sự kiện tới các subscriber
+type Bus struct {
+	mu        sync.RWMutex
+	subs      []*subscriber
+	queueSize int
+	closed    bool
+
+	ctx    context.Context // bị hủy khi Close quá thời hạn
+	cancel context.CancelFunc
+}
+
+func NewBus(queueSize int) *Bus {
+	if queueSize <= 0 {
+		queueSize = 256
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Bus{queueSize: queueSize, ctx: ctx, cancel: cancel}
+}
+
+// Subscribe đăng ký hàm xử lý cho các type (không truyền = tất cả),
+// trả về hàm hủy đăng ký
+func (b *Bus) Subscribe(name string, fn func(Event), types ...Type) func() {
+	s := b.add(name, func(_ context.Context, e Event) error { fn(e); return nil }, nil, types)
+	return func() { b.remove(s) }
+}
+
+// AddSink gắn một sink vào bus; sink được Close khi bus đóng
+func (b *Bus) AddSink(sink Sink, types ...Type) {
+	b.add(sink.Name(), sink.Handle, sink.Close, types)
+}
+
+func (b *Bus) add(name string, handle func(context.Context, Event) error, onClose func() error, types []Type) *subscriber {
+	s := &subscriber{
+		name:    name,
+		types:   make(map[Type]bool, len(types)),
+		queue:   make(chan Event, b.queueSize),
+		handle:  handle,
+		onClose: onClose,
+		done:    make(chan struct{}),
+	}
+	for _, t := range types {
+		s.types[t] = true
+	}
+	go s.run(b.ctx)
+
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+	return s
+}
+
+func (b *Bus) remove(s *subscriber) {
+	b.mu.Lock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			close(s.queue)
+			break
+		}
+	}
+	b.mu.Unlock()
+}
+
+// Publish tạo sự kiện và đẩy vào hàng đợi của từng subscriber, không bao giờ chặn
+func (b *Bus) Publish(typ Type, data any) {
+	e := newEvent(typ, data)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
+	for _, s := range b.subs {
+		if !s.wants(typ) {
+			continue
+		}
+		select {
+		case s.queue <- e:
+		default:
+			if n := s.dropped.Add(1); n == 1 || n%100 == 0 {
+				log.Printf("Event sink %s is full, dropped %d events", s.name, n)
+			}
+		}
+	}
+}
+
+// Close ngừng nhận sự kiện, chờ các subscriber xử lý hết hàng đợi rồi đóng sink.
+// Quá timeout thì hủy lần gửi đang chạy và bỏ các sự kiện còn lại để server tắt được.
+func (b *Bus) Close(timeout time.Duration) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	subs := b.subs
+	b.subs = nil
+	for _, s := range subs {
+		close(s.queue)
+	}
+	b.mu.Unlock()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for _, s := range subs {
+		select {
+		case <-s.done:
+		case <-deadline.C:
+			log.Printf("Event sinks did not drain within %s, abandoning pending events", timeout)
+			b.cancel()
+			<-s.done
+		}
+	}
+	b.cancel()
+
+	for _, s := range subs {
+		if s.onClose != nil {
+			if err := s.onClose(); err != nil {
+				log.Printf("Error closing event sink %s: %v", s.name, err)
+			}
+		}
+	}
+}
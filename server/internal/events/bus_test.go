@@ -0,0 +1,121 @@
+package events
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBusFiltersByType(t *testing.T) {
+	b := NewBus(16)
+	var all, matches atomic.Int32
+	b.Subscribe("all", func(Event) { all.Add(1) })
+	b.Subscribe("matches", func(Event) { matches.Add(1) }, MatchStarted, MatchEnded)
+
+	b.Publish(LobbyCreated, nil)
+	b.Publish(MatchStarted, nil)
+	b.Publish(MatchEnded, nil)
+	b.Close(time.Second)
+
+	if all.Load() != 3 || matches.Load() != 2 {
+		t.Fatalf("all = %d, matches = %d; want 3, 2", all.Load(), matches.Load())
+	}
+}
+
+func TestBusDropsWhenQueueFull(t *testing.T) {
+	b := NewBus(2)
+	release := make(chan struct{})
+	var handled atomic.Int32
+	b.Subscribe("slow", func(Event) {
+		<-release
+		handled.Add(1)
+	})
+
+	// Một sự kiện đang xử lý, hai sự kiện trong hàng đợi, phần còn lại bị bỏ
+	b.Publish(MatchStarted, nil)
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		b.Publish(MatchStarted, nil)
+	}
+	b.mu.RLock()
+	dropped := b.subs[0].dropped.Load()
+	b.mu.RUnlock()
+	if dropped != 3 {
+		t.Fatalf("dropped = %d, want 3", dropped)
+	}
+
+	close(release)
+	b.Close(time.Second)
+	if handled.Load() != 3 {
+		t.Fatalf("handled = %d, want 3", handled.Load())
+	}
+}
+
+func TestBusCloseDrainsQueueAndClosesSinks(t *testing.T) {
+	b := NewBus(16)
+	sink := &recordSink{}
+	b.AddSink(sink)
+	for i := 0; i < 5; i++ {
+		b.Publish(MatchEnded, nil)
+	}
+	b.Close(time.Second)
+
+	if sink.handled.Load() != 5 || !sink.closed.Load() {
+		t.Fatalf("handled = %d, closed = %v; want 5, true", sink.handled.Load(), sink.closed.Load())
+	}
+
+	// Sau khi đóng, Publish không gửi gì và Close lần nữa không treo
+	b.Publish(MatchEnded, nil)
+	b.Close(time.Second)
+	if sink.handled.Load() != 5 {
+		t.Fatal("event delivered after Close")
+	}
+}
+
+func TestBusCloseTimeoutCancelsSlowSink(t *testing.T) {
+	b := NewBus(16)
+	sink := &recordSink{block: true}
+	b.AddSink(sink)
+	for i := 0; i < 5; i++ {
+		b.Publish(MatchEnded, nil)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.Close(50 * time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return after its timeout")
+	}
+	// Chỉ sự kiện đang gửi bị hủy, các sự kiện còn lại bị bỏ mà không gọi Handle
+	if sink.handled.Load() != 1 || !sink.closed.Load() {
+		t.Fatalf("handled = %d, closed = %v; want 1, true", sink.handled.Load(), sink.closed.Load())
+	}
+}
+
+// recordSink đếm sự kiện nhận được; block = true thì chờ tới khi ctx bị hủy
+type recordSink struct {
+	block   bool
+	handled atomic.Int32
+	closed  atomic.Bool
+}
+
+func (s *recordSink) Name() string { return "record" }
+
+func (s *recordSink) Handle(ctx context.Context, e Event) error {
+	s.handled.Add(1)
+	if s.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (s *recordSink) Close() error {
+	s.closed.Store(true)
+	return nil
+}
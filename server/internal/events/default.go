@@ -0,0 +1,46 @@
+package events
+
+import (
+	"log"
+	"time"
+
+	"server/internal/config"
+)
+
+// Default là bus dùng chung của server
+var Default = NewBus(config.Config.EventQueueSize)
+
+// Publish phát sự kiện trên bus mặc định
+func Publish(typ Type, data any) {
+	Default.Publish(typ, data)
+}
+
+// Subscribe đăng ký hàm xử lý trên bus mặc định
+func Subscribe(name string, fn func(Event), types ...Type) func() {
+	return Default.Subscribe(name, fn, types...)
+}
+
+// InitSinks gắn các sink được bật trong config vào bus mặc định
+func InitSinks() {
+	if path := config.Config.EventLogFile; path != "" {
+		sink, err := NewFileSink(path)
+		if err != nil {
+			log.Printf("Error opening event log %s: %v", path, err)
+		} else {
+			Default.AddSink(sink)
+			log.Printf("Writing events to %s", path)
+		}
+	}
+
+	if url := config.Config.EventWebhookURL; url != "" {
+		timeout := time.Duration(config.Config.EventWebhookTimeout) * time.Second
+		Default.AddSink(NewWebhookSink(url, config.Config.EventWebhookSecret, config.Config.EventWebhookRetries, timeout))
+		log.Printf("Sending events to webhook %s", url)
+	}
+}
+
+// Close đóng bus mặc định, chờ các sink gửi hết sự kiện còn trong hàng đợi
+// tối đa EVENT_CLOSE_TIMEOUT giây
+func Close() {
+	Default.Close(time.Duration(config.Config.EventCloseTimeout) * time.Second)
+}
@@ -0,0 +1,103 @@
+// Package events là bus sự kiện nội bộ cho vòng đời lobby và trận đấu.
+//
+// Code game chỉ gọi Publish; các hệ thống bên ngoài (thống kê, bot Discord, công cụ
+// kiểm duyệt) nhận sự kiện qua sink mà không cần sửa code game:
+//   - Subscribe: hàm xử lý chạy trong cùng process
+//   - FileSink: ghi mỗi sự kiện một dòng JSON (JSONL)
+//   - WebhookSink: POST tới URL cấu hình, thử lại khi lỗi
+//
+// Mỗi subscriber có hàng đợi và goroutine riêng nên sink chậm không làm chậm
+// vòng lặp trận đấu hay các sink khác. Hàng đợi đầy thì sự kiện bị bỏ và đếm lại.
+package events
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Type là tên sự kiện, dạng "<đối tượng>.<hành động>"
+type Type string
+
+const (
+	LobbyCreated       Type = "lobby.created"
+	LobbyClosed        Type = "lobby.closed"
+	MatchStarted       Type = "match.started"
+	MatchEnded         Type = "match.ended"
+	PlayerDisconnected Type = "player.disconnected"
+	PlayerReconnected  Type = "player.reconnected"
+	RewardGranted      Type = "reward.granted"
+	AccountDeleted     Type = "account.deleted"
+)
+
+// Event là phong bì chung của mọi sự kiện; Data là một trong các struct *Data bên dưới
+type Event struct {
+	ID   string    `json:"id"`
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+func newEvent(typ Type, data any) Event {
+	return Event{ID: uuid.NewString(), Type: typ, Time: time.Now().UTC(), Data: data}
+}
+
+type LobbyCreatedData struct {
+	LobbyID  string `json:"lobby_id"`
+	RoomType string `json:"room_type"`
+	Matching bool   `json:"matching"` // phòng ghép trận tự động
+}
+
+type LobbyClosedData struct {
+	LobbyID string `json:"lobby_id"`
+	Reason  string `json:"reason"` // timeout, canceled, room_closed, empty
+}
+
+type MatchStartedData struct {
+	MatchID string `json:"match_id"`
+	Type    string `json:"type"`
+	UserIDs []int  `json:"user_ids"`
+}
+
+// PlayerResult là kết quả của một người chơi khi trận kết thúc
+type PlayerResult struct {
+	UserID int    `json:"user_id"`
+	Side   int    `json:"side"`
+	Result string `json:"result"` // win, lose, draw
+}
+
+type MatchEndedData struct {
+	MatchID  string         `json:"match_id"`
+	Type     string         `json:"type"`
+	Reason   string         `json:"reason"`
+	Duration float64        `json:"duration_seconds"`
+	Ticks    int64          `json:"ticks"`
+	Players  []PlayerResult `json:"players"`
+	Score    int            `json:"score,omitempty"` // chỉ có ở trận PvE
+}
+
+type PlayerDisconnectedData struct {
+	MatchID      string `json:"match_id"`
+	UserID       int    `json:"user_id"`
+	GraceSeconds int    `json:"grace_seconds"`
+}
+
+type PlayerReconnectedData struct {
+	MatchID string `json:"match_id"`
+	UserID  int    `json:"user_id"`
+}
+
+type AccountDeletedData struct {
+	UserID int `json:"user_id"`
+}
+
+type RewardGrantedData struct {
+	MatchID    string `json:"match_id"`
+	UserID     int    `json:"user_id"`
+	Result     string `json:"result"`
+	Experience int    `json:"experience"`
+	Gold       int    `json:"gold"`
+	Gems       int    `json:"gems"`
+	LevelUp    bool   `json:"level_up"`
+	Level      int    `json:"level"`
+}
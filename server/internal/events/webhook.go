@@ -0,0 +1,111 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WebhookSink POST từng sự kiện (JSON) tới URL. Lỗi mạng và mã 5xx/429 được thử lại
+// với thời gian chờ tăng gấp đôi; mã 4xx khác bị bỏ qua vì gửi lại cũng không thành công.
+//
+// Khi có Secret, mỗi lần gửi được ký HMAC-SHA256 trên "<X-Event-Timestamp>.<body>"
+// trong header X-Event-Signature. Bên nhận nên từ chối timestamp quá cũ để chống gửi lại.
+type WebhookSink struct {
+	URL     string
+	Secret  string
+	Retries int           // số lần thử lại sau lần gửi đầu
+	Backoff time.Duration // thời gian chờ trước lần thử lại đầu tiên
+	Client  *http.Client
+}
+
+func NewWebhookSink(url, secret string, retries int, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{
+		URL:     url,
+		Secret:  secret,
+		Retries: retries,
+		Backoff: 500 * time.Millisecond,
+		Client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *WebhookSink) Name() string { return "webhook:" + s.URL }
+
+func (s *WebhookSink) Handle(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	wait := s.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, e, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.Retries {
+			return fmt.Errorf("after %d attempts: %w", attempt+1, err)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("after %d attempts: %w", attempt+1, ctx.Err())
+		}
+		wait *= 2
+	}
+}
+
+// post gửi một lần, trả về lỗi và việc có nên thử lại hay không
+func (s *WebhookSink) post(ctx context.Context, e Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Type", string(e.Type))
+	req.Header.Set("X-Event-ID", e.ID)
+	if s.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Event-Timestamp", ts)
+		req.Header.Set("X-Event-Signature", "sha256="+Sign(s.Secret, ts, body))
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		// Bus đang đóng quá hạn: không thử lại
+		return ctx.Err() == nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+// Sign trả về chữ ký hex của body gửi lúc timestamp (giây Unix), bên nhận dùng để kiểm tra
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookSink) Close() error {
+	s.Client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,110 @@
+package events
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookServer trả lần lượt các mã trong statuses (mã cuối lặp lại) và đếm số request
+func webhookServer(t *testing.T, statuses ...int) (*WebhookSink, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1)) - 1
+		if n >= len(statuses) {
+			n = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[n])
+	}))
+	t.Cleanup(srv.Close)
+
+	s := NewWebhookSink(srv.URL, "", 2, time.Second)
+	s.Backoff = time.Millisecond
+	return s, &calls
+}
+
+func TestWebhookRetryClassification(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantErr   bool
+		wantCalls int32
+	}{
+		{"ok", []int{http.StatusNoContent}, false, 1},
+		{"5xx then ok", []int{http.StatusBadGateway, http.StatusOK}, false, 2},
+		{"429 retried", []int{http.StatusTooManyRequests, http.StatusOK}, false, 2},
+		{"5xx gives up after retries", []int{http.StatusInternalServerError}, true, 3},
+		{"4xx not retried", []int{http.StatusBadRequest}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, calls := webhookServer(t, tt.statuses...)
+			err := s.Handle(context.Background(), newEvent(MatchEnded, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Handle error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", calls.Load(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWebhookStopsRetryingWhenCanceled(t *testing.T) {
+	s, calls := webhookServer(t, http.StatusServiceUnavailable)
+	s.Backoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() { done <- s.Handle(ctx, newEvent(MatchEnded, nil)) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Handle succeeded, want error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Handle kept waiting for retry after cancel")
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestWebhookSignsTimestampAndBody(t *testing.T) {
+	const secret = "webhook-secret"
+	got := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	s := NewWebhookSink(srv.URL, secret, 0, time.Second)
+	if err := s.Handle(context.Background(), newEvent(MatchEnded, nil)); err != nil {
+		t.Fatal(err)
+	}
+	r, body := <-got, <-bodies
+
+	ts := r.Header.Get("X-Event-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Since(time.Unix(sec, 0)) > time.Minute {
+		t.Fatalf("X-Event-Timestamp = %q, want current unix seconds", ts)
+	}
+	if sig := r.Header.Get("X-Event-Signature"); sig != "sha256="+Sign(secret, ts, body) {
+		t.Fatalf("X-Event-Signature = %q does not match timestamp and body", sig)
+	}
+	// Đổi timestamp thì chữ ký khác: không dùng lại được chữ ký cũ với timestamp mới
+	if Sign(secret, ts, body) == Sign(secret, strconv.FormatInt(sec+1, 10), body) {
+		t.Fatal("signature does not cover the timestamp")
+	}
+}
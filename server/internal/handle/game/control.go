@@ -10,11 +10,12 @@ import (
 	"time"
 
 	"server/internal/db"
+	"server/internal/events"
 	"server/internal/session"
 	"server/internal/types"
+	"server/internal/wire"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -59,7 +60,13 @@ func ControlLobby(ctx context.Context, room *session.LobbyRoom) {
 
 		case <-ctx.Done():
 			// log.Printf("Lobby %s canceled via context. Stopping control.", room.ID)
-			removeLobbyRoom(room.ID, "canceled", "Lobby was canceled")
+			session.LobbyMu.RLock()
+			errorType, errorMsg := room.CloseType, room.CloseMessage
+			session.LobbyMu.RUnlock()
+			if errorType == "" {
+				errorType, errorMsg = "canceled", "Lobby was canceled"
+			}
+			removeLobbyRoom(room.ID, errorType, errorMsg)
 			return
 		}
 	}
@@ -69,33 +76,27 @@ func ControlLobby(ctx context.Context, room *session.LobbyRoom) {
 func ActiveClientsCount(room *session.LobbyRoom) int {
 	count := 0
 	for _, slot := range room.Slots {
-		if slot.Client != nil && isClientAlive(slot.Client) {
+		if slot.Client != nil && slot.Client.IsAlive() {
 			count++
 		}
 	}
 	return count
 }
 
-// isClientAlive sends a ping to the client and checks if the connection is still alive
-func isClientAlive(c *types.Client) bool {
-	if c.Conn == nil {
-		return false
-	}
-	err := c.Conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(1*time.Second))
-	return err == nil
-}
-
 // removeLobbyRoom cleans up a lobby and notifies all clients with an error message
 func removeLobbyRoom(id string, errorType string, errorMsg string) {
 	session.LobbyMu.Lock()
 	defer session.LobbyMu.Unlock()
 
+	// Phòng trống bị LeaveLobbyRoom xóa trước rồi mới hủy context, vẫn tính là đã đóng
+	events.Publish(events.LobbyClosed, events.LobbyClosedData{LobbyID: id, Reason: errorType})
+
 	room, ok := session.Lobbies[id]
 	if ok {
 		log.Printf("Removing lobby %s: %s", id, errorMsg)
 		for _, slot := range room.Slots {
 			if slot.Client != nil {
-				sendError(slot.Client.Send, "", errorType, errorMsg)
+				sendError(slot.Client, "", errorType, errorMsg)
 			}
 		}
 		delete(session.Lobbies, id)
@@ -104,34 +105,74 @@ func removeLobbyRoom(id string, errorType string, errorMsg string) {
 	}
 }
 
-// sendJSON marshals data to JSON and sends to the send channel
-func sendJSON(send chan<- []byte, data interface{}) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Recovered from panic when sending to channel: %v", r)
-		}
-	}()
+// sendJSON encodes data with the client's codec and sends to its send channel
+func sendJSON(c *types.Client, data interface{}) {
+	encoded, err := c.Encode(data)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+
+	sendRaw(c, encoded)
+}
+
+// sendRaw queues already-encoded bytes as a critical message without blocking
+func sendRaw(c *types.Client, encoded []byte) {
+	// Người chơi đang mất kết nối: bỏ qua, sẽ nhận snapshot đầy đủ khi quay lại
+	if c == nil || c.IsClosed() {
+		return
+	}
+	c.SendRaw(encoded)
+}
 
-	jsonData, err := json.Marshal(data)
+// sendUpdate queues a state update; an unsent update with the same key is replaced
+func sendUpdate(c *types.Client, key string, typ string, data interface{}) {
+	if c == nil || c.IsClosed() {
+		return
+	}
+	encoded, err := c.Encode(outgoingMessage{ID: typ, Type: typ, Data: data})
 	if err != nil {
-		log.Printf("Error marshaling JSON: %v", err)
+		log.Printf("Error marshaling %s: %v", typ, err)
 		return
 	}
+	c.SendUpdate(key, encoded)
+}
 
-	// Safe send to avoid panic if channel closed
-	select {
-	case send <- jsonData:
-	default:
-		log.Printf("Send channel is full or closed, dropping message.")
+// corkMatch holds outgoing messages for everyone in the match while a tick is
+// processed, so each client gets the whole tick in one write. Call the returned
+// function to release them.
+func corkMatch(gs *GameState) func() {
+	var clients []*types.Client
+	cork := func(c *types.Client) {
+		if c != nil && c.Out != nil {
+			c.Out.Cork()
+			clients = append(clients, c)
+		}
+	}
+	for side := 0; side < 2; side++ {
+		for _, player := range gs.Players[side] {
+			cork(player.User.Client)
+		}
+	}
+	if gs.Spectators != nil {
+		for _, c := range gs.Spectators.Clients {
+			cork(c)
+		}
+	}
+
+	return func() {
+		for _, c := range clients {
+			c.Out.Uncork()
+		}
 	}
 }
 
 // sendError sends a standard error message to the client
-func sendError(send chan<- []byte, id, errorType, message string) {
+func sendError(c *types.Client, id, errorType, message string) {
 	if id == "" {
 		id = "unknown"
 	}
-	sendJSON(send, outgoingMessage{
+	sendJSON(c, outgoingMessage{
 		ID:   id,
 		Type: "error",
 		Data: map[string]string{
@@ -142,13 +183,13 @@ func sendError(send chan<- []byte, id, errorType, message string) {
 }
 
 // sendMessage sends a typed message to the client
-func sendMessage(send chan<- []byte, id string, typ string, data interface{}) {
+func sendMessage(c *types.Client, id string, typ string, data interface{}) {
 	msg := outgoingMessage{
 		ID:   id,
 		Type: typ,
 		Data: data,
 	}
-	sendJSON(send, msg)
+	sendJSON(c, msg)
 }
 
 func PromoteLobbyToMatch(room *session.LobbyRoom) bool {
@@ -180,6 +221,14 @@ func PromoteLobbyToMatch(room *session.LobbyRoom) bool {
 		MaxSize: room.MaxSize,
 		Type:    room.Type,
 		User:    users,
+		Resume:  make(chan *types.Client, 4),
+
+		Spectate:       make(chan session.SpectateRequest, 16),
+		ForceEnd:       make(chan session.ForceEndRequest, 1),
+		SpectatorHands: room.SpectatorHands,
+		StartedAt:      time.Now(),
+
+		TickInterval: time.Duration(matchTick) * 10 * time.Millisecond,
 	}
 
 	// Thêm vào danh sách MatchRooms
@@ -187,7 +236,11 @@ func PromoteLobbyToMatch(room *session.LobbyRoom) bool {
 	session.Matches[room.ID] = match
 	session.MatchesMu.Unlock()
 
-	go gameStart(match)
+	if IsPvEType(match.Type) {
+		go pveGameStart(match)
+	} else {
+		go gameStart(match)
+	}
 
 	return true
 }
@@ -209,286 +262,222 @@ type UserDeck struct {
 	} `bson:"cards"`
 }
 
+// ReleaseActionData là một lần thả bài gửi từ handler qua MatchRoom
 type ReleaseActionData struct {
-	MsgID  string `json:"msg_id"`
-	UserID int    `json:"user_id"`
-	CardID int    `json:"card"`
-	X      int    `json:"x"`
-	Y      int    `json:"y"`
+	MsgID      string `json:"msg_id"`
+	UserID     int    `json:"user_id"`
+	CardID     int    `json:"cardID"`
+	X          int    `json:"x"`
+	Y          int    `json:"y"`
+	ClientTick int64  `json:"client_tick"` // 0 = client cũ không gửi tick
+
+	ScheduledTick int64 `json:"-"` // tick thực thi, gán khi xếp lịch
 }
 
-func gameStart(match *session.MatchRoom) {
+// prepareMatch tải deck của từng người chơi, báo bắt đầu trận và gắn kênh MatchRoom cho client
+func prepareMatch(match *session.MatchRoom) (chan []byte, bool) {
 	for _, user := range match.User {
 		err := getUserDeck(db.MongoDatabase, user)
 		if err != nil {
 			log.Printf("Error getting user deck for user %d: %v", user.ID, err)
-			sendError(user.Client.Send, "", "error", "Failed to load user deck")
-			return
+			sendError(user.Client, "", "error", "Failed to load user deck")
+			return nil, false
 		}
 	}
 
 	// log.Printf("Lobby %s promoted to MatchRoom.", match.ID)
 
 	for _, user := range match.User {
-		if user.Client != nil && user.Client.Send != nil {
+		if user.Client != nil && !user.Client.IsClosed() {
 			startData := map[string]interface{}{
 				"roomID": match.ID,
 				"type":   match.Type,
 			}
-			sendMessage(user.Client.Send, "start game", "start game", startData)
+			sendMessage(user.Client, "start game", "start game", startData)
 		}
 	}
 
 	matchRoom := make(chan []byte, 40)
-	StopRoom := make(chan []byte, 2)
+	userIDs := make([]int, 0, len(match.User))
 	for _, client := range match.User {
 		client.Client.User.MatchRoom = matchRoom
+		userIDs = append(userIDs, client.ID)
+	}
+
+	events.Publish(events.MatchStarted, events.MatchStartedData{
+		MatchID: match.ID,
+		Type:    match.Type,
+		UserIDs: userIDs,
+	})
+	return matchRoom, true
+}
+
+// finishMatch xóa MatchRoom khỏi danh sách trận đang chạy
+func finishMatch(match *session.MatchRoom) {
+	session.MatchesMu.Lock()
+	delete(session.Matches, match.ID)
+	session.MatchesMu.Unlock()
+}
+
+func gameStart(match *session.MatchRoom) {
+	defer finishMatch(match)
+
+	matchRoom, ok := prepareMatch(match)
+	if !ok {
+		return
 	}
 
 	// ⚔️ Initialize game state
 	gameState := NewGameState(match)
 
 	SendDeckToAllClients(gameState)
+	initSync(gameState)
+	defer logSyncStats(gameState)
+	defer endSpectating(gameState)
 
 	// ⏳ Thời gian trận đấu tối đa, ví dụ 3 phút
 	gameTimer := time.NewTimer(3 * time.Minute)
+	defer gameTimer.Stop()
 	ticker := time.NewTicker(time.Duration(gameState.Tick) * 10 * time.Millisecond)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-StopRoom:
-			return
-
 		case <-ticker.C:
-			go updateGameState(gameState, StopRoom)
-
-		case data := <-matchRoom:
-			// log.Println("Received action from client:", string(data))
-			var action map[string]interface{}
-			if err := json.Unmarshal(data, &action); err != nil {
-				log.Printf("Error unmarshaling action: %v", err)
-				continue
+			if side := checkDisconnects(gameState, false); side != -1 {
+				endGame(gameState, 1-side, "forfeit")
+				return
 			}
-
-			actionType, ok := action["type"].(string)
-			if !ok {
-				log.Printf("Invalid action type: %v", action["type"])
-				continue
+			if updateGameState(gameState) {
+				return
 			}
 
-			switch actionType {
-			case "release":
-				type ReleaseActionData struct {
-					CardID int    `json:"cardID"`
-					X      int    `json:"x"`
-					Y      int    `json:"y"`
-					UserID int    `json:"user_id"`
-					MsgID  string `json:"msg_id"`
-				}
-
-				// Bắt đầu xử lý action "release"
-				dataMap, ok := action["data"].(map[string]interface{})
-				if !ok {
-					log.Printf("Invalid data format for release action: %v", action["data"])
-					continue
-				}
-
-				dataBytes, err := json.Marshal(dataMap)
-				if err != nil {
-					log.Printf("Error marshaling data for release action: %v", err)
-					continue
-				}
-
-				var releaseData ReleaseActionData
-				if err := json.Unmarshal(dataBytes, &releaseData); err != nil {
-					log.Printf("Error unmarshaling release action data: %v", err)
-					continue
-				}
-
-				var player PlayerState
-				var playerIndex int
-				var playerSubIndex int
-				top := false
-				found := false
-
-				for i, group := range gameState.Players {
-					for j, p := range group {
-						if p.User.ID == releaseData.UserID {
-							player = *p
-							playerIndex = i
-							playerSubIndex = j
-							if i == 0 {
-								top = true
-							}
-							found = true
-							break
-						}
-					}
-					if found {
-						break
-					}
-				}
+		case c := <-match.Resume:
+			handleResume(gameState, c, matchRoom)
 
-				if !found || player.User == nil {
-					log.Printf("Player with UserID %d not found in game state", releaseData.UserID)
-					continue
-				}
+		case req := <-match.Spectate:
+			handleSpectate(gameState, req)
 
-				cardFound := false
-				// Kiểm tra bài trong tay
-				for _, id := range player.Hand {
-					if id == releaseData.CardID {
-						cardFound = true
-						released := false
-
-						// Troops
-						for _, card := range player.User.DataGame.Troops {
-							if card.Index == releaseData.CardID {
-								if releaseData.Y < 0 || releaseData.Y >= len(gameState.Map)/2 ||
-									releaseData.X < 0 || releaseData.X >= len(gameState.Map[0]) {
-									sendError(player.User.Client.Send, releaseData.MsgID, "invalid_position", "Out of map bounds")
-									break
-								}
-
-								validTiles := map[int]bool{1: true}
-								tileValue := gameState.Map[releaseData.Y][releaseData.X]
-								if !validTiles[tileValue] {
-									sendError(player.User.Client.Send, releaseData.MsgID, "invalid_position", "Invalid tile type for card release")
-									break
-								}
-
-								if player.Elixir < float64(card.Info.Mana) {
-									sendError(player.User.Client.Send, releaseData.MsgID, "not_enough_elixir", "Not enough elixir to release card")
-									break
-								}
-
-								player.Elixir -= float64(card.Info.Mana)
-
-								x, y := releaseData.X, releaseData.Y
-								if !top {
-									x, y = MirrorPosition(x, y, len(gameState.Map[0]), len(gameState.Map))
-								}
-
-								troop := Troop{
-									HP:          card.Info.Hp,
-									Time_attack: 0,
-									Shield:      card.Info.Shield,
-									Location:    Position{X: x, Y: y, long: 1, wide: 1},
-									Skill_using: false,
-									CardInfo:    card,
-									Skill_info:  card.Info.Skill,
-									TargetID:    "",
-								}
-
-								allies := Allies{
-									ID:     uuid.New().String(),
-									Type:   "troop",
-									Alive:  true,
-									Troops: troop,
-								}
-
-								gameState.Allies[player.Side] = append(gameState.Allies[player.Side], allies)
-
-								UpdateHandAfterPlay(&player, releaseData.CardID)
-								SendDeckToClients(&player, releaseData.MsgID)
-								released = true
-								break
-							}
-						}
-						// Spells
-						if !released {
-							for _, card := range player.User.DataGame.Spells {
-								if card.Index == releaseData.CardID {
-									if releaseData.Y < 0 || releaseData.Y >= len(gameState.Map) ||
-										releaseData.X < 0 || releaseData.X >= len(gameState.Map[0]) {
-										sendError(player.User.Client.Send, releaseData.MsgID, "invalid_position", "Out of map bounds")
-										break
-									}
-
-									validTiles := map[int]bool{1: true, 3: true, 4: true}
-									tileValue := gameState.Map[releaseData.Y][releaseData.X]
-									if !validTiles[tileValue] {
-										sendError(player.User.Client.Send, releaseData.MsgID, "invalid_position", "Invalid tile type for card release")
-										break
-									}
-
-									if player.Elixir < float64(card.Info.Mana) {
-										sendError(player.User.Client.Send, releaseData.MsgID, "not_enough_elixir", "Not enough elixir to release card")
-										break
-									}
-
-									player.Elixir -= float64(card.Info.Mana)
-
-									x, y := releaseData.X, releaseData.Y
-									if !top {
-										x, y = MirrorPosition(x, y, len(gameState.Map[0]), len(gameState.Map))
-									}
-
-									troop := Troop{
-										HP:          card.Info.Hp,
-										Time_attack: 0,
-										Shield:      card.Info.Shield,
-										Location:    Position{X: x, Y: y, long: 1, wide: 1},
-										Skill_using: false,
-										CardInfo:    card,
-										Skill_info:  card.Info.Skill,
-										TargetID:    "",
-									}
-
-									allies := Allies{
-										ID:     uuid.New().String(),
-										Type:   "troop",
-										Alive:  true,
-										Troops: troop,
-									}
-
-									gameState.Allies[player.Side] = append(gameState.Allies[player.Side], allies)
-
-									UpdateHandAfterPlay(&player, releaseData.CardID)
-									SendDeckToClients(&player, releaseData.MsgID)
-									break
-								}
-							}
-						}
+		case req := <-match.ForceEnd:
+			log.Printf("Match %s force-ended: %s", match.ID, req.Reason)
+			endGame(gameState, req.Winner, req.Reason)
+			return
 
-						// Cập nhật player sau khi thay đổi elixir
-						gameState.Players[playerIndex][playerSubIndex] = &player
-						break
-					}
-				}
-				if !cardFound {
-					sendError(player.User.Client.Send, releaseData.MsgID, "card_not_found", "Card not found in hand")
-				}
-			default:
-				sendError(matchRoom, "", "invalid_action", "Unknown action type: "+actionType)
-			}
+		case data := <-matchRoom:
+			handleMatchAction(gameState, data)
 
 		case <-gameTimer.C:
 			// log.Printf("Match %s timed out. Evaluating final result.", match.ID)
 
 			// Đã check king chết trong checkGameEnd(), giờ gọi resolveDrawOutcome
 			winner := resolveDrawOutcome(gameState)
+			endGame(gameState, winner, "timeout")
+			return
+		}
+	}
+}
 
-			for side := 0; side < 2; side++ {
-				for _, player := range gameState.Players[side] {
-					result := "lose"
-					if winner == -1 {
-						result = "draw"
-					} else if player.Side == winner {
-						result = "win"
-					}
-					UpdateUserRewards(player.User.ID, result)
-					sendMessage(player.User.Client.Send, "end_game", "game_end", map[string]interface{}{
-						"result": result,
-					})
-				}
-			}
-			StopRoom <- []byte("s")
+// handleMatchAction giải mã một action từ client gửi qua MatchRoom và áp dụng vào gameState
+func handleMatchAction(gameState *GameState, data []byte) {
+	// log.Println("Received action from client:", string(data))
+	var action map[string]interface{}
+	if err := json.Unmarshal(data, &action); err != nil {
+		log.Printf("Error unmarshaling action: %v", err)
+		return
+	}
+
+	actionType, ok := action["type"].(string)
+	if !ok {
+		log.Printf("Invalid action type: %v", action["type"])
+		return
+	}
+
+	switch actionType {
+	case "release":
+		// Bắt đầu xử lý action "release"
+		dataMap, ok := action["data"].(map[string]interface{})
+		if !ok {
+			log.Printf("Invalid data format for release action: %v", action["data"])
+			return
+		}
+
+		dataBytes, err := json.Marshal(dataMap)
+		if err != nil {
+			log.Printf("Error marshaling data for release action: %v", err)
+			return
+		}
+
+		var releaseData ReleaseActionData
+		if err := json.Unmarshal(dataBytes, &releaseData); err != nil {
+			log.Printf("Error unmarshaling release action data: %v", err)
+			return
 		}
+
+		// Không áp dụng ngay: xếp lịch vào tick thực thi, áp dụng đầu tick đó
+		scheduleRelease(gameState, releaseData)
+
+	case "snapshot_ack":
+		dataMap, ok := action["data"].(map[string]interface{})
+		if !ok {
+			log.Printf("Invalid data format for snapshot_ack action: %v", action["data"])
+			return
+		}
+		userID, _ := dataMap["user_id"].(float64)
+		tick, _ := dataMap["tick"].(float64)
+		handleSnapshotAck(gameState, int(userID), int64(tick))
+
+	default:
+		log.Printf("Unknown action type: %s", actionType)
 	}
 }
 
+// applyRelease thả lá bài của người chơi vào sân ở tick đã xếp lịch.
+// Kiểm tra lại vì tay bài và elixir có thể đã đổi từ lúc xếp lịch.
+func applyRelease(gameState *GameState, releaseData ReleaseActionData) {
+	player := findPlayer(gameState, releaseData.UserID)
+	if player == nil || player.User == nil {
+		log.Printf("Player with UserID %d not found in game state", releaseData.UserID)
+		return
+	}
+
+	card, spell, errType, message := checkRelease(gameState, player, releaseData, player.Elixir)
+	if errType != "" {
+		rejectInput(player.User.Client, releaseData, errType, message)
+		return
+	}
+
+	player.Elixir -= float64(card.Info.Mana)
+
+	x, y := releaseData.X, releaseData.Y
+	if player.Side != 0 {
+		x, y = MirrorPosition(x, y, len(gameState.Map[0]), len(gameState.Map))
+	}
+
+	troop := Troop{
+		HP:          card.Info.Hp,
+		Time_attack: 0,
+		Shield:      card.Info.Shield,
+		Location:    Position{X: x, Y: y, long: 1, wide: 1},
+		Skill_using: false,
+		CardInfo:    card,
+		Skill_info:  card.Info.Skill,
+		TargetID:    "",
+	}
+
+	allies := Allies{
+		ID:     uuid.New().String(),
+		Type:   "troop",
+		Alive:  true,
+		Troops: troop,
+	}
+
+	gameState.Allies[player.Side] = append(gameState.Allies[player.Side], allies)
+	gameState.recordSpawn(player.Side, &allies, spell)
+
+	UpdateHandAfterPlay(player, releaseData.CardID)
+	SendDeckToClients(player, releaseData.MsgID)
+}
+
 func resolveDrawOutcome(gs *GameState) int {
 	guardCount := [2]int{}
 	minHP := [2]int{1<<31 - 1, 1<<31 - 1} // max int value
@@ -530,7 +519,16 @@ func resolveDrawOutcome(gs *GameState) int {
 	return -1 // hoà
 }
 
-func updateGameState(gs *GameState, stop chan []byte) {
+// updateGameState chạy một tick, trả true nếu trận đã kết thúc trong tick này
+func updateGameState(gs *GameState) bool {
+	defer corkMatch(gs)()
+
+	gs.TickCount++
+	gs.Match.Tick.Store(gs.TickCount)
+
+	// 0. Áp dụng các input đã xếp lịch cho tick này
+	applyDueInputs(gs)
+
 	// 1. Cập nhật tài nguyên Elixir cho mỗi người chơi
 	updateElixir(gs)
 
@@ -543,31 +541,63 @@ func updateGameState(gs *GameState, stop chan []byte) {
 	// 4. Cleanup các entity đã chết (HP <= 0 hoặc hết thời gian tồn tại)
 	CleanupAllies(gs)
 
-	// 6. Gửi sự kiện cập nhật trạng thái đến Client
-	emitGameStateEvents(gs)
+	// 6. Gửi snapshot/delta trạng thái đến Client
+	emitSyncEvents(gs)
+	updateSpectators(gs)
+	emitCombatEvents(gs)
 
 	// 5. Kiểm tra điều kiện kết thúc trận đấu (King Tower bị phá)
 	winner := checkGameEnd(gs)
 	if winner != -1 {
 		// Gửi sự kiện kết thúc trận đấu cho tất cả client
-		for side := 0; side < 2; side++ {
-			for _, player := range gs.Players[side] {
-				result := "lose"
-				if player.Side == winner {
-					result = "win"
-				}
-				UpdateUserRewards(player.User.ID, result)
-				sendMessage(player.User.Client.Send, "end_game", "game_end", map[string]interface{}{
-					"result": result,
-				})
-				stop <- []byte("s")
+		endGame(gs, winner, "king_destroyed")
+		return true
+	}
+	return false
+}
+
+// endGame phát thưởng và gửi game_end cho tất cả người chơi.
+// winner là side thắng; giá trị khác 0/1 (-1 hoặc 2) nghĩa là hòa.
+func endGame(gs *GameState, winner int, reason string) {
+	var results []events.PlayerResult
+	for side := 0; side < 2; side++ {
+		for _, player := range gs.Players[side] {
+			result := "lose"
+			if winner != 0 && winner != 1 {
+				result = "draw"
+			} else if player.Side == winner {
+				result = "win"
 			}
+			results = append(results, events.PlayerResult{UserID: player.User.ID, Side: player.Side, Result: result})
+			UpdateUserRewards(gs.Match.ID, player.User.ID, result)
+			sendMessage(player.User.Client, "end_game", "game_end", map[string]interface{}{
+				"result": result,
+				"reason": reason,
+			})
 		}
-		return
 	}
+
+	publishMatchEnded(gs, reason, results, 0)
 }
 
-func UpdateUserRewards(userID int, result string) error {
+// publishMatchEnded phát sự kiện kết thúc trận; score chỉ dùng cho PvE
+func publishMatchEnded(gs *GameState, reason string, results []events.PlayerResult, score int) {
+	data := events.MatchEndedData{
+		MatchID: gs.Match.ID,
+		Type:    gs.Match.Type,
+		Reason:  reason,
+		Ticks:   gs.TickCount,
+		Players: results,
+		Score:   score,
+	}
+	if gs.Sync != nil {
+		data.Duration = time.Since(gs.Sync.StartedAt).Seconds()
+	}
+	events.Publish(events.MatchEnded, data)
+}
+
+// UpdateUserRewards cộng thưởng theo kết quả trận, xét lên cấp và phát sự kiện reward.granted
+func UpdateUserRewards(matchID string, userID int, result string) error {
 	var expGain, gold, gems int
 
 	switch result {
@@ -615,8 +645,19 @@ func UpdateUserRewards(userID int, result string) error {
 		ExperienceRequired int `bson:"experience_required"`
 	}
 
+	granted := events.RewardGrantedData{
+		MatchID:    matchID,
+		UserID:     userID,
+		Result:     result,
+		Experience: expGain,
+		Gold:       gold,
+		Gems:       gems,
+		Level:      level,
+	}
+
 	err = collection.FindOne(ctx, bson.M{"level": level}).Decode(&levelConfig)
 	if err != nil {
+		events.Publish(events.RewardGranted, granted)
 		return nil
 	}
 
@@ -634,8 +675,11 @@ func UpdateUserRewards(userID int, result string) error {
 			log.Printf("❌ Error updating level up: %v", err)
 			return err
 		}
+		granted.LevelUp = true
+		granted.Level = newLevel
 	}
 
+	events.Publish(events.RewardGranted, granted)
 	return nil
 }
 
@@ -643,6 +687,7 @@ func UpdateAliveStatus(gs *GameState) {
 	for side := 0; side < 2; side++ {
 		for i := range gs.Allies[side] {
 			unit := &gs.Allies[side][i]
+			wasAlive := unit.Alive
 
 			// Troop
 			if unit.Type == "troop" {
@@ -664,6 +709,10 @@ func UpdateAliveStatus(gs *GameState) {
 					unit.Alive = false
 				}
 			}
+
+			if wasAlive && !unit.Alive {
+				gs.recordDeath(side, unit)
+			}
 		}
 	}
 }
@@ -692,7 +741,31 @@ func checkGameEnd(gs *GameState) int {
 	return -1 // chưa kết thúc
 }
 
-func CreateUpdateEvent(gs *GameState, side int) map[string]interface{} {
+// CreateUpdateEvent dựng message "update" đầy đủ cho client không hỗ trợ delta,
+// từ cùng view model wire như snapshot để không lộ struct nội bộ
+func CreateUpdateEvent(gs *GameState, side int) wire.Update {
+	player := gs.Players[side][0]
+	update := wire.Update{
+		V:          wire.Version,
+		Tick:       gs.TickCount,
+		ServerTime: time.Now().UnixMilli(),
+		Map:        displayMapForSide(gs, side),
+		Hand:       append([]int{}, player.Hand[:]...),
+		Elixir:     player.Elixir,
+		NextCard:   player.NextCard,
+		Player:     playerView(player),
+	}
+	for i := 0; i < 2; i++ {
+		update.Allies[i] = make([]wire.Entity, 0, len(gs.Allies[i]))
+		for j := range gs.Allies[i] {
+			update.Allies[i] = append(update.Allies[i], entityView(gs, i, &gs.Allies[i][j], side))
+		}
+	}
+	return update
+}
+
+// displayMapForSide trả về bản sao của map theo góc nhìn của side
+func displayMapForSide(gs *GameState, side int) [][]int {
 	// Sao chép map
 	var displayMap [][]int
 	for _, row := range gs.Map {
@@ -713,55 +786,13 @@ func CreateUpdateEvent(gs *GameState, side int) map[string]interface{} {
 			}
 		}
 	}
-
-	// Mirror Allies nếu side = 1
-	displayAllies := [2][]Allies{}
-	for i := 0; i < 2; i++ {
-		for _, ally := range gs.Allies[i] {
-			clone := ally // sao chép tránh modify gốc
-			if side == 1 {
-				switch ally.Type {
-				case "troop":
-					clone.Troops.Location.X, clone.Troops.Location.Y =
-						MirrorPosition(ally.Troops.Location.X, ally.Troops.Location.Y, len(gs.Map[0]), len(gs.Map))
-				case "spell":
-					clone.Spells.Location.X, clone.Spells.Location.Y =
-						MirrorPosition(ally.Spells.Location.X, ally.Spells.Location.Y, len(gs.Map[0]), len(gs.Map))
-				case "guard_tower":
-					clone.Guard.Location.X, clone.Guard.Location.Y =
-						MirrorPosition(ally.Guard.Location.X, ally.Guard.Location.Y, len(gs.Map[0]), len(gs.Map))
-					clone.Guard.Location.X -= clone.Guard.Location.wide
-					clone.Guard.Location.Y -= clone.Guard.Location.long
-				case "king_tower":
-					clone.King.Location.X, clone.King.Location.Y =
-						MirrorPosition(ally.King.Location.X, ally.King.Location.Y, len(gs.Map[0]), len(gs.Map))
-					clone.King.Location.X -= clone.King.Location.wide
-					clone.King.Location.Y -= clone.King.Location.long
-				}
-			}
-			displayAllies[i] = append(displayAllies[i], clone)
-		}
-	}
-
-	player := gs.Players[side][0]
-	return map[string]interface{}{
-		"map":      displayMap,
-		"allies":   displayAllies,
-		"elixir":   player.Elixir,
-		"hand":     player.Hand,
-		"nextCard": player.NextCard,
-	}
-}
-
-// Gửi event cho client
-func SendToClient(user *session.User, event map[string]interface{}) {
-	sendMessage(user.Client.Send, "update", "update", event)
+	return displayMap
 }
 
 func updateElixir(gs *GameState) {
 	for side := 0; side < 2; side++ {
 		for _, player := range gs.Players[side] {
-			if player.Elixir < 10 {
+			if player.Elixir < maxElixir {
 				player.ElixirTimer += float64(gs.Tick) / 1000 // Tăng theo 30 tick = 1 giây
 				if player.ElixirTimer >= 1 {
 					player.Elixir += 1
@@ -931,8 +962,9 @@ func handleTroopCombat(gs *GameState, troop *Allies, enemySide int) {
 		if t.Time_attack >= float32(1.0/t.CardInfo.Info.AttackSpeed) {
 			target := getAllyByID(gs, t.TargetID)
 			if target != nil && target.Alive {
-				damage := calculateDamage(troop, target)
+				damage, crit := calculateDamage(troop, target)
 				target.ReduceHP(damage)
+				gs.recordDamage(troop, target, damage, crit)
 				// log.Println("troop "+troop.Troops.CardInfo.Name+" Attacking target:", target.Type, "with damage:", damage)
 				t.Time_attack -= float32(1.0 / t.CardInfo.Info.AttackSpeed)
 
@@ -961,9 +993,10 @@ func handleGuardCombat(gs *GameState, guard *Allies, enemySide int) {
 		if g.Time_attack >= float32(1.0/g.GuardInfo.Info.AttackSpeed) {
 			target := getAllyByID(gs, g.TargetID)
 			if target != nil && target.IsAlive() {
-				damage := calculateDamage(guard, target)
+				damage, crit := calculateDamage(guard, target)
 				// log.Println("guard "+guard.Guard.GuardInfo.Name+" Attacking target:", target.Type, "with damage:", damage)
 				target.ReduceHP(damage)
+				gs.recordDamage(guard, target, damage, crit)
 				g.Time_attack -= float32(1.0 / g.GuardInfo.Info.AttackSpeed)
 			}
 		}
@@ -985,9 +1018,10 @@ func handleKingCombat(gs *GameState, king *Allies, enemySide int) {
 		if k.Time_attack >= float32(1.0/k.KingInfo.Info.AttackSpeed) {
 			target := getAllyByID(gs, k.TargetID)
 			if target != nil && target.IsAlive() {
-				damage := calculateDamage(king, target)
+				damage, crit := calculateDamage(king, target)
 				// log.Println("king "+king.King.KingInfo.Name+" Attacking target:", target.Type, "with damage:", damage)
 				target.ReduceHP(damage)
+				gs.recordDamage(king, target, damage, crit)
 				k.Time_attack -= float32(1.0 / k.KingInfo.Info.AttackSpeed)
 			}
 		}
@@ -1000,7 +1034,7 @@ func handleSpellEffect(gs *GameState, spell *Allies) {
 	if s.Time_effect >= 1.0/float32(s.Skill_info.Effect_speed) {
 		if s.Time < s.Skill_info.Time {
 			// log.Println("Applying spell effect at location:", s.Location, "with skill:", s.Skill_info.Name)
-			ApplySkillArea(gs, s.Location, &s.Skill_info)
+			ApplySkillArea(gs, spell, s.Location, &s.Skill_info)
 			s.Time_effect -= 1.0 / float32(s.Skill_info.Effect_speed)
 			s.Time += 1
 		}
@@ -1119,7 +1153,8 @@ func getAllyByID(gs *GameState, id string) *Allies {
 	return nil
 }
 
-func calculateDamage(attacker *Allies, defender *Allies) int {
+// calculateDamage trả về sát thương vào máu và cờ chí mạng
+func calculateDamage(attacker *Allies, defender *Allies) (int, bool) {
 	var atk, def int
 	var critRate float64
 	var shield *int
@@ -1152,7 +1187,8 @@ func calculateDamage(attacker *Allies, defender *Allies) int {
 
 	// 1. Tính sát thương cơ bản
 	effectiveAtk := float64(atk)
-	if rand.Float64() < critRate {
+	crit := rand.Float64() < critRate
+	if crit {
 		effectiveAtk *= 1.2
 	}
 	damage := int(effectiveAtk) - def
@@ -1166,12 +1202,12 @@ func calculateDamage(attacker *Allies, defender *Allies) int {
 		if *shield < 0 {
 			*shield = 0
 		}
-		return 0
+		return 0, crit
 
 	}
 
 	// 3. Nếu không có shield → damage trực tiếp vào máu
-	return damage
+	return damage, crit
 }
 
 func applySkillEffect(gs *GameState, troop *Allies) {
@@ -1189,7 +1225,8 @@ func applySkillEffect(gs *GameState, troop *Allies) {
 	}
 }
 
-func ApplySkillArea(gs *GameState, center Position, skillInfo *session.SkillLevelInfo) {
+// ApplySkillArea áp dụng hiệu ứng của spell source lên mọi entity trong vùng quanh center
+func ApplySkillArea(gs *GameState, source *Allies, center Position, skillInfo *session.SkillLevelInfo) {
 	for side := 0; side < 2; side++ {
 		for i := range gs.Allies[side] {
 			target := &gs.Allies[side][i]
@@ -1197,6 +1234,7 @@ func ApplySkillArea(gs *GameState, center Position, skillInfo *session.SkillLeve
 				switch skillInfo.Type {
 				case "damage":
 					target.ReduceHP(skillInfo.Value)
+					gs.recordDamage(source, target, skillInfo.Value, false)
 				case "heal":
 					target.Heal(skillInfo.Value)
 				}
@@ -1265,16 +1303,6 @@ func (a *Allies) Heal(amount int) {
 	}
 }
 
-func emitGameStateEvents(gs *GameState) {
-	// Duyệt tất cả người chơi để gửi cập nhật
-	for side := 0; side < 2; side++ {
-		for _, player := range gs.Players[side] {
-			event := CreateUpdateEvent(gs, player.Side)
-			SendToClient(player.User, event)
-		}
-	}
-}
-
 func UpdateHandAfterPlay(player *PlayerState, usedCardID int) {
 	for i, id := range player.Hand {
 		if id == usedCardID {
@@ -1297,25 +1325,13 @@ func UpdateHandAfterPlay(player *PlayerState, usedCardID int) {
 }
 
 func SendDeckToClients(player *PlayerState, id string) {
-	deckData := map[string]interface{}{
-		"hand":        player.Hand,
-		"nextCard":    player.NextCard,
-		"elixir":      player.Elixir,
-		"elixirTimer": player.ElixirTimer,
-	}
-	sendMessage(player.User.Client.Send, id, "deck", deckData)
+	sendMessage(player.User.Client, id, "deck", wire.Deck{V: wire.Version, Player: playerView(player)})
 }
 
 func SendDeckToAllClients(gs *GameState) {
 	for side := 0; side < 2; side++ {
 		for _, player := range gs.Players[side] {
-			deckData := map[string]interface{}{
-				"hand":        player.Hand,
-				"nextCard":    player.NextCard,
-				"elixir":      player.Elixir,
-				"elixirTimer": player.ElixirTimer,
-			}
-			sendMessage(player.User.Client.Send, "deck", "deck", deckData)
+			sendMessage(player.User.Client, "deck", "deck", wire.Deck{V: wire.Version, Player: playerView(player)})
 		}
 	}
 }
@@ -1340,6 +1356,7 @@ func CleanupAllies(gs *GameState) {
 							}
 						}
 					}
+					gs.MapVersion++
 
 				}
 
@@ -1357,6 +1374,7 @@ func CleanupAllies(gs *GameState) {
 							}
 						}
 					}
+					gs.MapVersion++
 
 				}
 				continue
@@ -1432,6 +1450,13 @@ type GameState struct {
 	Allies  [2][]Allies
 	Match   *session.MatchRoom
 	Tick    int64
+
+	TickCount  int64         // số tick đã chạy từ đầu trận
+	Events     []CombatEvent // sự kiện của tick hiện tại, gửi đi cuối tick
+	MapVersion int           // tăng mỗi khi map thay đổi (trụ bị phá)
+	Sync       *syncState
+	Spectators *spectatorState     // người xem trận, nil nếu chưa có ai
+	Inputs     []ReleaseActionData // input chờ tới tick thực thi, sắp theo ScheduledTick
 }
 
 type PlayerState struct {
@@ -1442,6 +1467,9 @@ type PlayerState struct {
 	NextCard    int    // Lá kế tiếp sẽ vào tay
 	Elixir      float64
 	ElixirTimer float64
+	Sync        playerSync
+
+	DisconnectedAt time.Time // thời điểm mất kết nối, zero nếu đang kết nối
 }
 
 // long và wide là kích thước của ô trong game, có thể dùng để tính toán vị trí
@@ -1738,7 +1766,7 @@ func NewGameState(match *session.MatchRoom) *GameState {
 			1: {bottomKingTower, bottomLeftGuardTower, bottomRightGuardTower},
 		},
 		Match: match,
-		Tick:  500,
+		Tick:  matchTick,
 	}
 }
 
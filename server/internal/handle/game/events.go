@@ -0,0 +1,174 @@
+package game
+
+import (
+	"time"
+
+	"server/internal/protocol"
+	"server/internal/wire"
+)
+
+// Các loại sự kiện combat gửi kèm snapshot mỗi tick
+const (
+	EventDamageDealt    = "damage_dealt"
+	EventUnitSpawned    = "unit_spawned"
+	EventUnitDied       = "unit_died"
+	EventSpellCast      = "spell_cast"
+	EventTowerDestroyed = "tower_destroyed"
+	EventCrit           = "crit"
+)
+
+// CombatEvent là một sự kiện xảy ra trong trận, gắn với tick mà nó diễn ra.
+// Khi gửi được chuyển sang wire.Event theo góc nhìn của từng người chơi.
+type CombatEvent struct {
+	Type     string
+	Tick     int64
+	Side     int    // phe của entity gây ra sự kiện
+	SourceID string // entity gây ra sự kiện
+	TargetID string // entity chịu tác động
+	Unit     string // troop, guard_tower, king_tower, spell
+	Card     string
+	Amount   int
+
+	loc *Position // tọa độ gốc trên map
+}
+
+// pushEvent ghi nhận sự kiện vào lô của tick hiện tại
+func (gs *GameState) pushEvent(ev CombatEvent) {
+	ev.Tick = gs.TickCount
+	gs.Events = append(gs.Events, ev)
+}
+
+// sideOf trả về phe chứa entity, -1 nếu không tìm thấy
+func (gs *GameState) sideOf(id string) int {
+	for side := 0; side < 2; side++ {
+		for i := range gs.Allies[side] {
+			if gs.Allies[side][i].ID == id {
+				return side
+			}
+		}
+	}
+	return -1
+}
+
+// unitName trả về tên lá bài / trụ của entity
+func (a *Allies) unitName() string {
+	switch a.Type {
+	case "troop":
+		return a.Troops.CardInfo.Name
+	case "spell":
+		return a.Spells.CardInfo.Name
+	case "guard_tower":
+		return a.Guard.GuardInfo.Name
+	case "king_tower":
+		return a.King.KingInfo.Name
+	}
+	return ""
+}
+
+// recordDamage ghi sự kiện damage_dealt (và crit nếu có)
+func (gs *GameState) recordDamage(attacker, target *Allies, damage int, crit bool) {
+	side := gs.sideOf(attacker.ID)
+	pos := target.GetLocation()
+	if crit {
+		gs.pushEvent(CombatEvent{
+			Type:     EventCrit,
+			Side:     side,
+			SourceID: attacker.ID,
+			TargetID: target.ID,
+			Unit:     attacker.Type,
+			Card:     attacker.unitName(),
+			Amount:   damage,
+			loc:      &pos,
+		})
+	}
+	gs.pushEvent(CombatEvent{
+		Type:     EventDamageDealt,
+		Side:     side,
+		SourceID: attacker.ID,
+		TargetID: target.ID,
+		Unit:     attacker.Type,
+		Card:     attacker.unitName(),
+		Amount:   damage,
+		loc:      &pos,
+	})
+}
+
+// recordSpawn ghi sự kiện unit_spawned hoặc spell_cast khi một lá bài được thả
+func (gs *GameState) recordSpawn(side int, ally *Allies, isSpell bool) {
+	pos := ally.GetLocation()
+	evType := EventUnitSpawned
+	if isSpell {
+		evType = EventSpellCast
+	}
+	gs.pushEvent(CombatEvent{
+		Type:     evType,
+		Side:     side,
+		SourceID: ally.ID,
+		Unit:     ally.Type,
+		Card:     ally.unitName(),
+		loc:      &pos,
+	})
+}
+
+// recordDeath ghi sự kiện unit_died hoặc tower_destroyed
+func (gs *GameState) recordDeath(side int, ally *Allies) {
+	pos := ally.GetLocation()
+	evType := EventUnitDied
+	if ally.Type == "guard_tower" || ally.Type == "king_tower" {
+		evType = EventTowerDestroyed
+	}
+	gs.pushEvent(CombatEvent{
+		Type:     evType,
+		Side:     side,
+		SourceID: ally.ID,
+		Unit:     ally.Type,
+		Card:     ally.unitName(),
+		loc:      &pos,
+	})
+}
+
+// emitCombatEvents gửi lô sự kiện của tick cho từng người chơi rồi xóa lô.
+// Sự kiện rời rạc không gộp được như snapshot nên lô được gửi dạng critical.
+func emitCombatEvents(gs *GameState) {
+	if len(gs.Events) == 0 {
+		return
+	}
+
+	for side := 0; side < 2; side++ {
+		for _, player := range gs.Players[side] {
+			if !player.User.Client.HasFeature(protocol.FeatureCombatEvents) {
+				continue
+			}
+			sendMessage(player.User.Client, "events", "events", eventBatchForSide(gs, player.Side))
+		}
+	}
+	gs.Events = gs.Events[:0]
+}
+
+// eventBatchForSide chuyển lô sự kiện sang góc nhìn của side
+func eventBatchForSide(gs *GameState, side int) wire.EventBatch {
+	batch := wire.EventBatch{
+		V:          wire.Version,
+		Tick:       gs.TickCount,
+		ServerTime: time.Now().UnixMilli(),
+		Events:     make([]wire.Event, 0, len(gs.Events)),
+	}
+	for _, ev := range gs.Events {
+		out := wire.Event{
+			Type:     ev.Type,
+			Tick:     ev.Tick,
+			Side:     ev.Side,
+			SourceID: ev.SourceID,
+			TargetID: ev.TargetID,
+			Kind:     ev.Unit,
+			Name:     ev.Card,
+			Amount:   ev.Amount,
+		}
+		if ev.loc != nil {
+			x, y := viewPoint(gs, *ev.loc, side)
+			out.Position = &wire.Point{X: x, Y: y}
+		}
+		batch.Events = append(batch.Events, out)
+	}
+	return batch
+}
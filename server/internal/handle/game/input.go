@@ -0,0 +1,179 @@
+package game
+
+import (
+	"sort"
+
+	"server/internal/config"
+	"server/internal/session"
+	"server/internal/types"
+)
+
+// Input theo tick:
+//   - Client gửi Release_card kèm client_tick là tick server mới nhất nó đã thấy.
+//   - Server xếp lịch thực thi ở client_tick + InputDelayTicks (không sớm hơn tick kế tiếp)
+//     và trả "input_ack" với scheduled_tick, hoặc "input_reject" nếu không nhận.
+//     Lá bài, vị trí và elixir (tính cả các input đang chờ) được kiểm tra trước khi ack.
+//   - Đầu tick thực thi, input được áp dụng theo thứ tự lịch rồi thứ tự nhận, nên
+//     mọi người chơi thấy lá bài xuất hiện ở cùng một tick. Nếu lúc đó không còn hợp lệ
+//     (ví dụ lá bài đã được thả bởi input khác), client nhận "input_reject".
+
+// scheduleRelease kiểm tra tick của input, xếp lịch và trả ack cho người chơi
+func scheduleRelease(gs *GameState, in ReleaseActionData) {
+	player := findPlayer(gs, in.UserID)
+	if player == nil {
+		return
+	}
+
+	now := gs.TickCount
+	delay := int64(config.Config.InputDelayTicks)
+	scheduled := now + delay
+
+	if in.ClientTick > 0 {
+		if in.ClientTick > now+1 {
+			rejectInput(player.User.Client, in, "invalid_tick", "Client tick is ahead of the server")
+			return
+		}
+		if now-in.ClientTick > int64(config.Config.InputMaxLagTicks) {
+			rejectInput(player.User.Client, in, "input_too_late", "Input arrived too late")
+			return
+		}
+		scheduled = in.ClientTick + delay
+	}
+	if scheduled <= now {
+		scheduled = now + 1
+	}
+	if errType, message := checkScheduledRelease(gs, player, in, scheduled); errType != "" {
+		rejectInput(player.User.Client, in, errType, message)
+		return
+	}
+	in.ScheduledTick = scheduled
+
+	// Chèn giữ thứ tự nhận với các input cùng tick
+	i := sort.Search(len(gs.Inputs), func(i int) bool {
+		return gs.Inputs[i].ScheduledTick > scheduled
+	})
+	gs.Inputs = append(gs.Inputs, ReleaseActionData{})
+	copy(gs.Inputs[i+1:], gs.Inputs[i:])
+	gs.Inputs[i] = in
+
+	sendMessage(player.User.Client, in.MsgID, "input_ack", map[string]interface{}{
+		"msg_id":         in.MsgID,
+		"client_tick":    in.ClientTick,
+		"server_tick":    now,
+		"scheduled_tick": scheduled,
+	})
+}
+
+// checkScheduledRelease kiểm tra input như lúc áp dụng, với tay bài và elixir dự kiến ở tick
+// thực thi: bỏ các lá đã có input chờ thả và trừ elixir của các input chờ trước nó
+func checkScheduledRelease(gs *GameState, p *PlayerState, in ReleaseActionData, scheduled int64) (string, string) {
+	future := *p
+	elixir := elixirAt(gs, p, scheduled)
+	for _, pending := range gs.Inputs {
+		if pending.UserID != in.UserID || pending.ScheduledTick > scheduled {
+			continue
+		}
+		for i, id := range future.Hand {
+			if id == pending.CardID {
+				future.Hand[i] = -1
+				break
+			}
+		}
+		if card, _, ok := findCard(p, pending.CardID); ok {
+			elixir -= float64(card.Info.Mana)
+		}
+	}
+	_, _, errType, message := checkRelease(gs, &future, in, elixir)
+	return errType, message
+}
+
+// elixirAt tính elixir của người chơi lúc input ở tick scheduled được áp dụng,
+// tức sau các lần hồi elixir từ tick kế tiếp tới trước tick đó (xem updateElixir)
+func elixirAt(gs *GameState, p *PlayerState, scheduled int64) float64 {
+	elixir, timer := p.Elixir, p.ElixirTimer
+	for t := gs.TickCount + 1; t < scheduled; t++ {
+		if elixir >= maxElixir {
+			break
+		}
+		timer += float64(gs.Tick) / 1000
+		if timer >= 1 {
+			elixir++
+			timer--
+		}
+	}
+	return elixir
+}
+
+// checkRelease kiểm tra lá bài có trên tay, vị trí thả và elixir.
+// Trả về lá bài, có phải phép không, và mã lỗi + thông điệp cho input_reject (rỗng nếu hợp lệ).
+func checkRelease(gs *GameState, p *PlayerState, in ReleaseActionData, elixir float64) (card session.Card, spell bool, errType, message string) {
+	inHand := false
+	for _, id := range p.Hand {
+		if id == in.CardID {
+			inHand = true
+			break
+		}
+	}
+	card, spell, ok := findCard(p, in.CardID)
+	if !inHand || !ok {
+		return card, spell, "card_not_found", "Card not found in hand"
+	}
+
+	// Quân chỉ thả được ở nửa sân của mình, phép thả được cả sân và lên ô trụ
+	rows := len(gs.Map) / 2
+	validTiles := map[int]bool{1: true}
+	if spell {
+		rows = len(gs.Map)
+		validTiles = map[int]bool{1: true, 3: true, 4: true}
+	}
+	if in.Y < 0 || in.Y >= rows || in.X < 0 || in.X >= len(gs.Map[0]) {
+		return card, spell, "invalid_position", "Out of map bounds"
+	}
+	if !validTiles[gs.Map[in.Y][in.X]] {
+		return card, spell, "invalid_position", "Invalid tile type for card release"
+	}
+
+	if elixir < float64(card.Info.Mana) {
+		return card, spell, "not_enough_elixir", "Not enough elixir to release card"
+	}
+	return card, spell, "", ""
+}
+
+// findCard tìm lá bài trong dữ liệu của người chơi, quân trước rồi tới phép
+func findCard(p *PlayerState, cardID int) (card session.Card, spell bool, ok bool) {
+	for _, c := range p.User.DataGame.Troops {
+		if c.Index == cardID {
+			return c, false, true
+		}
+	}
+	for _, c := range p.User.DataGame.Spells {
+		if c.Index == cardID {
+			return c, true, true
+		}
+	}
+	return session.Card{}, false, false
+}
+
+// applyDueInputs áp dụng các input có ScheduledTick tới tick hiện tại
+func applyDueInputs(gs *GameState) {
+	n := 0
+	for _, in := range gs.Inputs {
+		if in.ScheduledTick > gs.TickCount {
+			break
+		}
+		applyRelease(gs, in)
+		n++
+	}
+	gs.Inputs = gs.Inputs[n:]
+}
+
+// rejectInput báo input không được áp dụng, kèm tick đã xếp lịch (0 nếu bị từ chối ngay khi nhận)
+func rejectInput(c *types.Client, in ReleaseActionData, errorType, message string) {
+	sendMessage(c, in.MsgID, "input_reject", map[string]interface{}{
+		"msg_id":         in.MsgID,
+		"error":          errorType,
+		"message":        message,
+		"client_tick":    in.ClientTick,
+		"scheduled_tick": in.ScheduledTick,
+	})
+}
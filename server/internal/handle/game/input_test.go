@@ -0,0 +1,172 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"server/internal/config"
+	"server/internal/session"
+	"server/internal/types"
+)
+
+// inputGame tạo trận 1 người chơi trên bản đồ 4x2 toàn ô thả được, tay có lá 1 (quân, 3 elixir)
+// và lá 2 (phép, 4 elixir); trả về client để đọc các message đã gửi
+func inputGame(t *testing.T, elixir float64) (*GameState, *types.Client) {
+	t.Helper()
+	cfg := config.Config
+	oldDelay, oldLag := cfg.InputDelayTicks, cfg.InputMaxLagTicks
+	cfg.InputDelayTicks, cfg.InputMaxLagTicks = 1, 2
+	t.Cleanup(func() { cfg.InputDelayTicks, cfg.InputMaxLagTicks = oldDelay, oldLag })
+
+	c := &types.Client{Out: types.NewOutQueue(64, 0)}
+	user := &session.User{ID: 1, Client: c}
+	user.DataGame.Troops = []session.Card{{Index: 1, Info: session.CardLevelInfo{Mana: 3, Hp: 100}}}
+	user.DataGame.Spells = []session.Card{{Index: 2, Info: session.CardLevelInfo{Mana: 4}}}
+
+	gs := &GameState{
+		Map:       [][]int{{1, 1}, {1, 1}, {1, 1}, {1, 1}},
+		Tick:      100,
+		TickCount: 10,
+	}
+	gs.Players[0] = []*PlayerState{{
+		User:   user,
+		Hand:   [4]int{1, 2, 5, 6},
+		Deck:   []int{7},
+		Elixir: elixir,
+	}}
+	return gs, c
+}
+
+// sent trả về type của các message client đã nhận, kèm data của message cuối
+func sent(t *testing.T, c *types.Client) ([]string, map[string]interface{}) {
+	t.Helper()
+	var typs []string
+	var last map[string]interface{}
+	for {
+		if critical, updates := c.Out.Len(); critical+updates == 0 {
+			return typs, last
+		}
+		done := make(chan [][]byte, 1)
+		go func() { msgs, _ := c.Out.Next(); done <- msgs }()
+		var msgs [][]byte
+		select {
+		case msgs = <-done:
+		case <-time.After(time.Second):
+			t.Fatal("no message in queue")
+		}
+		for _, m := range msgs {
+			var msg struct {
+				Type string                 `json:"type"`
+				Data map[string]interface{} `json:"data"`
+			}
+			if err := json.Unmarshal(m, &msg); err != nil {
+				t.Fatal(err)
+			}
+			typs = append(typs, msg.Type)
+			last = msg.Data
+		}
+	}
+}
+
+func TestScheduleReleaseRejectsBeforeAck(t *testing.T) {
+	tests := []struct {
+		name    string
+		elixir  float64
+		in      ReleaseActionData
+		wantErr string
+	}{
+		{"card not in hand", 10, ReleaseActionData{CardID: 9}, "card_not_found"},
+		{"troop outside own half", 10, ReleaseActionData{CardID: 1, Y: 2}, "invalid_position"},
+		{"out of bounds", 10, ReleaseActionData{CardID: 2, X: 5}, "invalid_position"},
+		{"not enough elixir", 1, ReleaseActionData{CardID: 1}, "not_enough_elixir"},
+		{"tick ahead", 10, ReleaseActionData{CardID: 1, ClientTick: 20}, "invalid_tick"},
+		{"tick too old", 10, ReleaseActionData{CardID: 1, ClientTick: 5}, "input_too_late"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, c := inputGame(t, tt.elixir)
+			tt.in.UserID = 1
+			scheduleRelease(gs, tt.in)
+
+			typs, data := sent(t, c)
+			if len(typs) != 1 || typs[0] != "input_reject" || data["error"] != tt.wantErr {
+				t.Fatalf("sent %v %v, want single input_reject %s", typs, data, tt.wantErr)
+			}
+			if len(gs.Inputs) != 0 {
+				t.Fatal("rejected input was scheduled")
+			}
+		})
+	}
+}
+
+func TestScheduleReleaseCountsPendingInputs(t *testing.T) {
+	gs, c := inputGame(t, 5)
+
+	// Lá 1 được nhận; thả lá 1 lần nữa hoặc lá 2 (cần 4, còn 2) trước tick thực thi thì bị từ chối
+	scheduleRelease(gs, ReleaseActionData{UserID: 1, CardID: 1})
+	if typs, data := sent(t, c); len(typs) != 1 || typs[0] != "input_ack" || data["scheduled_tick"] != float64(11) {
+		t.Fatalf("sent %v %v, want input_ack at tick 11", typs, data)
+	}
+	scheduleRelease(gs, ReleaseActionData{UserID: 1, CardID: 1})
+	if _, data := sent(t, c); data["error"] != "card_not_found" {
+		t.Fatalf("second release of pending card = %v, want card_not_found", data)
+	}
+	scheduleRelease(gs, ReleaseActionData{UserID: 1, CardID: 2})
+	if _, data := sent(t, c); data["error"] != "not_enough_elixir" {
+		t.Fatalf("release over pending elixir = %v, want not_enough_elixir", data)
+	}
+	if len(gs.Inputs) != 1 {
+		t.Fatalf("scheduled %d inputs, want 1", len(gs.Inputs))
+	}
+}
+
+func TestScheduleReleaseCountsElixirRegen(t *testing.T) {
+	gs, c := inputGame(t, 2)
+	gs.Players[0][0].ElixirTimer = 0.95
+
+	// Hồi 1 elixir ở tick 11 nên lá 1 (3 elixir) thả được ở tick 12
+	scheduleRelease(gs, ReleaseActionData{UserID: 1, CardID: 1, ClientTick: 11})
+	if typs, data := sent(t, c); len(typs) != 1 || typs[0] != "input_ack" || data["scheduled_tick"] != float64(12) {
+		t.Fatalf("sent %v %v, want input_ack at tick 12", typs, data)
+	}
+}
+
+func TestApplyReleaseRejectsWhenNoLongerValid(t *testing.T) {
+	gs, c := inputGame(t, 5)
+	scheduleRelease(gs, ReleaseActionData{UserID: 1, CardID: 1})
+	sent(t, c)
+
+	// Elixir mất đi giữa lúc xếp lịch và lúc áp dụng
+	gs.Players[0][0].Elixir = 0
+	gs.TickCount++
+	applyDueInputs(gs)
+
+	typs, data := sent(t, c)
+	if len(typs) != 1 || typs[0] != "input_reject" || data["error"] != "not_enough_elixir" || data["scheduled_tick"] != float64(11) {
+		t.Fatalf("sent %v %v, want input_reject not_enough_elixir at tick 11", typs, data)
+	}
+	if len(gs.Allies[0]) != 0 {
+		t.Fatal("invalid input spawned a unit")
+	}
+}
+
+func TestApplyReleaseSpawnsAndCyclesHand(t *testing.T) {
+	gs, c := inputGame(t, 5)
+	scheduleRelease(gs, ReleaseActionData{UserID: 1, CardID: 2, X: 1, Y: 3})
+	sent(t, c)
+
+	gs.TickCount++
+	applyDueInputs(gs)
+
+	p := gs.Players[0][0]
+	if len(gs.Allies[0]) != 1 || p.Elixir != 1 {
+		t.Fatalf("allies = %d, elixir = %v; want 1 spawn and 1 elixir left", len(gs.Allies[0]), p.Elixir)
+	}
+	if p.Hand[1] == 2 {
+		t.Fatal("released card still in hand")
+	}
+	if typs, _ := sent(t, c); len(typs) != 1 || typs[0] != "deck" {
+		t.Fatalf("sent %v, want deck", typs)
+	}
+}
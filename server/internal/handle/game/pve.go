@@ -0,0 +1,473 @@
+package game
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"server/internal/config"
+	"server/internal/db"
+	"server/internal/events"
+	"server/internal/session"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Các loại phòng chơi co-op với quái do server điều khiển
+const (
+	RoomTypePvE  = "pve"  // 1 người chơi
+	RoomTypeCoop = "coop" // 2 người chơi cùng phòng thủ
+)
+
+// WaveSet là định nghĩa một chuỗi wave lưu trong collection "pve_waves"
+type WaveSet struct {
+	Name      string       `bson:"name"`
+	Map       string       `bson:"map"`
+	TimeLimit int          `bson:"time_limit"` // giây, 0 = mặc định 10 phút
+	Scaling   WaveScaling  `bson:"scaling"`
+	Waves     []WaveConfig `bson:"waves"`
+}
+
+// WaveScaling mô tả độ khó tăng dần theo từng wave
+type WaveScaling struct {
+	HpPerWave       float64 `bson:"hp_per_wave"`       // +% máu mỗi wave
+	AtkPerWave      float64 `bson:"atk_per_wave"`      // +% công mỗi wave
+	CoopMultiplier  float64 `bson:"coop_multiplier"`   // nhân máu khi có 2 người chơi
+	BossHpMultiple  float64 `bson:"boss_hp_multiple"`  // nhân máu cho quái boss
+	BossAtkMultiple float64 `bson:"boss_atk_multiple"` // nhân công cho quái boss
+}
+
+type WaveConfig struct {
+	Wave  int        `bson:"wave"`
+	Delay int        `bson:"delay"` // số tick chờ sau khi wave trước bị dọn sạch
+	Boss  bool       `bson:"boss"`
+	Bonus int        `bson:"bonus"` // điểm thưởng khi dọn sạch wave
+	Units []WaveUnit `bson:"units"`
+}
+
+type WaveUnit struct {
+	Name  string `bson:"name"`
+	Level int    `bson:"level"`
+	Count int    `bson:"count"`
+	X     int    `bson:"x"`
+	Y     int    `bson:"y"`
+	Boss  bool   `bson:"boss"`
+}
+
+// pveRun giữ tiến độ của một lượt chơi PvE
+type pveRun struct {
+	Set         *WaveSet
+	Wave        int // index wave tiếp theo sẽ được sinh ra
+	WaitTicks   int
+	Kills       int
+	BossKills   int
+	WavesClear  int
+	Score       int
+	activeWave  *WaveConfig
+	bossUnitIDs map[string]bool
+}
+
+// IsPvEType trả về true nếu loại phòng là chế độ co-op PvE
+func IsPvEType(roomType string) bool {
+	return roomType == RoomTypePvE || roomType == RoomTypeCoop
+}
+
+func pveGameStart(match *session.MatchRoom) {
+	defer finishMatch(match)
+
+	set, err := loadWaveSet(config.Config.PvEWaveSet)
+	if err != nil {
+		log.Printf("Error loading wave set %q: %v", config.Config.PvEWaveSet, err)
+		for _, user := range match.User {
+			sendError(user.Client, "", "error", "Failed to load wave definition")
+		}
+		return
+	}
+
+	matchRoom, ok := prepareMatch(match)
+	if !ok {
+		return
+	}
+
+	gameState := NewPvEGameState(match, set)
+	if gameState == nil {
+		for _, user := range match.User {
+			sendError(user.Client, "", "error", "Failed to load map")
+		}
+		return
+	}
+	run := &pveRun{Set: set, bossUnitIDs: make(map[string]bool)}
+	if len(set.Waves) > 0 {
+		run.WaitTicks = set.Waves[0].Delay
+	}
+
+	SendDeckToAllClients(gameState)
+	initSync(gameState)
+	defer logSyncStats(gameState)
+	defer endSpectating(gameState)
+
+	timeLimit := time.Duration(set.TimeLimit) * time.Second
+	if timeLimit <= 0 {
+		timeLimit = 10 * time.Minute
+	}
+	gameTimer := time.NewTimer(timeLimit)
+	defer gameTimer.Stop()
+	ticker := time.NewTicker(time.Duration(gameState.Tick) * 10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Co-op: chỉ xử thua khi tất cả người chơi đều hết thời gian chờ kết nối lại
+			if checkDisconnects(gameState, true) != -1 {
+				endPvERun(gameState, run, false, "forfeit")
+				return
+			}
+			if ended, victory := updatePvEState(gameState, run); ended {
+				endPvERun(gameState, run, victory, "")
+				return
+			}
+
+		case data := <-matchRoom:
+			handleMatchAction(gameState, data)
+
+		case c := <-match.Resume:
+			handleResume(gameState, c, matchRoom)
+
+		case req := <-match.Spectate:
+			handleSpectate(gameState, req)
+
+		case req := <-match.ForceEnd:
+			log.Printf("Match %s force-ended: %s", match.ID, req.Reason)
+			endPvERun(gameState, run, req.Winner == 0, req.Reason)
+			return
+
+		case <-gameTimer.C:
+			// Hết giờ mà vẫn còn trụ vua → coi như sống sót đến cuối
+			endPvERun(gameState, run, true, "timeout")
+			return
+		}
+	}
+}
+
+// NewPvEGameState tạo trạng thái trận với người chơi ở side 0, side 1 dành cho quái
+func NewPvEGameState(match *session.MatchRoom, set *WaveSet) *GameState {
+	mapName := set.Map
+	if mapName == "" {
+		mapName = "Basic Map 20x33"
+	}
+	mapData := loadMapFromMongoDB(mapName)
+	if len(mapData) == 0 {
+		return nil
+	}
+
+	// Phía dưới không có trụ: mở các ô trụ để quái có chỗ xuất hiện
+	for y := len(mapData) / 2; y < len(mapData); y++ {
+		for x := range mapData[y] {
+			if mapData[y][x] == 3 || mapData[y][x] == 4 {
+				mapData[y][x] = 1
+			}
+		}
+	}
+
+	var players []*PlayerState
+	for _, user := range match.User {
+		allCards := append(user.DataGame.Troops, user.DataGame.Spells...)
+		indexes := extractCardIndexes(shuffleCards(allCards))
+
+		players = append(players, &PlayerState{
+			User:        user,
+			Side:        0,
+			Deck:        indexes[5:],
+			Hand:        [4]int{indexes[0], indexes[1], indexes[2], indexes[3]},
+			NextCard:    indexes[4],
+			Elixir:      5.0,
+			ElixirTimer: 1.0,
+		})
+	}
+
+	// Trụ trái thuộc người chơi đầu, trụ phải thuộc người chơi cuối
+	left := players[0].User.DataGame.GuardTower
+	right := players[len(players)-1].User.DataGame.GuardTower
+	kingPlayer := players[0]
+	for _, p := range players[1:] {
+		if p.User.DataGame.KingTower.Level > kingPlayer.User.DataGame.KingTower.Level {
+			kingPlayer = p
+		}
+	}
+	king := kingPlayer.User.DataGame.KingTower
+
+	newGuard := func(info session.GuardTower, x, y int) Allies {
+		return Allies{
+			ID:    uuid.New().String(),
+			Type:  "guard_tower",
+			Alive: true,
+			Guard: Guard{
+				HP:         info.Info.Hp,
+				Shield:     info.Info.Shield,
+				Location:   Position{X: x, Y: y, long: 3, wide: 3},
+				GuardInfo:  info,
+				Skill_info: info.Info.Skill,
+			},
+		}
+	}
+
+	kingTower := Allies{
+		ID:    uuid.New().String(),
+		Type:  "king_tower",
+		Alive: true,
+		King: King{
+			HP:         king.Info.Hp,
+			Shield:     king.Info.Shield,
+			Location:   Position{X: 8, Y: 2, long: 4, wide: 4},
+			KingInfo:   king,
+			Skill_info: king.Info.Skill,
+		},
+	}
+
+	return &GameState{
+		Map: mapData,
+		Players: [2][]*PlayerState{
+			0: players,
+			1: nil,
+		},
+		Allies: [2][]Allies{
+			0: {kingTower, newGuard(left, 3, 6), newGuard(right, 14, 6)},
+			1: nil,
+		},
+		Match: match,
+		Tick:  matchTick,
+	}
+}
+
+// updatePvEState chạy một tick PvE, trả về (kết thúc, chiến thắng)
+func updatePvEState(gs *GameState, run *pveRun) (bool, bool) {
+	defer corkMatch(gs)()
+
+	gs.TickCount++
+	gs.Match.Tick.Store(gs.TickCount)
+	applyDueInputs(gs)
+
+	updateElixir(gs)
+
+	spawnDueWave(gs, run)
+
+	handleCombat(gs)
+
+	UpdateAliveStatus(gs)
+
+	countPvEKills(gs, run)
+
+	CleanupAllies(gs)
+
+	emitSyncEvents(gs)
+	updateSpectators(gs)
+	emitCombatEvents(gs)
+
+	kingAlive := false
+	for _, ally := range gs.Allies[0] {
+		if ally.Type == "king_tower" {
+			kingAlive = true
+			break
+		}
+	}
+	if !kingAlive {
+		return true, false
+	}
+
+	// Wave hiện tại đã bị dọn sạch
+	if run.activeWave != nil && len(gs.Allies[1]) == 0 {
+		run.WavesClear++
+		run.Score += run.activeWave.Bonus
+		run.activeWave = nil
+		if run.Wave < len(run.Set.Waves) {
+			run.WaitTicks = run.Set.Waves[run.Wave].Delay
+		}
+	}
+
+	if run.activeWave == nil && run.Wave >= len(run.Set.Waves) {
+		return true, true
+	}
+	return false, false
+}
+
+// spawnDueWave sinh wave tiếp theo khi wave trước đã bị dọn và hết thời gian chờ
+func spawnDueWave(gs *GameState, run *pveRun) {
+	if run.activeWave != nil || run.Wave >= len(run.Set.Waves) {
+		return
+	}
+	if run.WaitTicks > 0 {
+		run.WaitTicks--
+		return
+	}
+
+	wave := &run.Set.Waves[run.Wave]
+	difficulty := run.Wave
+	run.Wave++
+	run.activeWave = wave
+
+	sc := run.Set.Scaling
+	hpScale := 1 + sc.HpPerWave*float64(difficulty)
+	atkScale := 1 + sc.AtkPerWave*float64(difficulty)
+	if len(gs.Players[0]) > 1 && sc.CoopMultiplier > 0 {
+		hpScale *= sc.CoopMultiplier
+	}
+
+	for _, unit := range wave.Units {
+		cardInfo, cardType, err := getCardLevelInfo(db.MongoDatabase, unit.Name, unit.Level)
+		if err != nil || cardType != "troop" {
+			log.Printf("Skipping wave unit %q: %v", unit.Name, err)
+			continue
+		}
+
+		unitHp, unitAtk := hpScale, atkScale
+		if unit.Boss || wave.Boss {
+			if sc.BossHpMultiple > 0 {
+				unitHp *= sc.BossHpMultiple
+			}
+			if sc.BossAtkMultiple > 0 {
+				unitAtk *= sc.BossAtkMultiple
+			}
+		}
+		cardInfo.Hp = int(math.Round(float64(cardInfo.Hp) * unitHp))
+		cardInfo.Atk = int(math.Round(float64(cardInfo.Atk) * unitAtk))
+
+		card := session.Card{Index: -1, Name: unit.Name, Level: unit.Level, Info: cardInfo}
+
+		count := unit.Count
+		if count <= 0 {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			x, y := unit.X+i, unit.Y
+			if y < 0 || y >= len(gs.Map) || x < 0 || x >= len(gs.Map[0]) || gs.Map[y][x] != 1 {
+				log.Printf("Invalid spawn position (%d,%d) for wave unit %q", x, y, unit.Name)
+				continue
+			}
+
+			ally := Allies{
+				ID:    uuid.New().String(),
+				Type:  "troop",
+				Alive: true,
+				Troops: Troop{
+					HP:         cardInfo.Hp,
+					Shield:     cardInfo.Shield,
+					Location:   Position{X: x, Y: y, long: 1, wide: 1},
+					CardInfo:   card,
+					Skill_info: cardInfo.Skill,
+				},
+			}
+			if unit.Boss || wave.Boss {
+				run.bossUnitIDs[ally.ID] = true
+			}
+			gs.Allies[1] = append(gs.Allies[1], ally)
+			gs.recordSpawn(1, &ally, false)
+		}
+	}
+
+	for _, player := range gs.Players[0] {
+		sendMessage(player.User.Client, "wave_start", "wave_start", map[string]interface{}{
+			"wave":  run.Wave,
+			"total": len(run.Set.Waves),
+			"boss":  wave.Boss,
+		})
+	}
+}
+
+// countPvEKills cộng điểm cho các quái vừa bị tiêu diệt trong tick này
+func countPvEKills(gs *GameState, run *pveRun) {
+	for _, ally := range gs.Allies[1] {
+		if ally.Alive {
+			continue
+		}
+		if run.bossUnitIDs[ally.ID] {
+			run.BossKills++
+			run.Score += 50 * ally.Troops.CardInfo.Info.Mana
+			delete(run.bossUnitIDs, ally.ID)
+		} else {
+			run.Kills++
+			run.Score += 10 * ally.Troops.CardInfo.Info.Mana
+		}
+	}
+}
+
+// endPvERun tính điểm cuối, phát thưởng và gửi kết quả cho người chơi.
+// reason rỗng: lấy theo kết quả ("survived" hoặc "defeated").
+func endPvERun(gs *GameState, run *pveRun, victory bool, reason string) {
+	result := "lose"
+	if victory {
+		result = "win"
+		// Thưởng theo máu trụ còn lại
+		for _, ally := range gs.Allies[0] {
+			switch ally.Type {
+			case "guard_tower":
+				run.Score += ally.Guard.HP / 10
+			case "king_tower":
+				run.Score += ally.King.HP / 5
+			}
+		}
+	}
+
+	var userIDs []int
+	for _, player := range gs.Players[0] {
+		userIDs = append(userIDs, player.User.ID)
+	}
+	savePvEScore(gs.Match, run, userIDs, result)
+
+	var results []events.PlayerResult
+	for _, player := range gs.Players[0] {
+		results = append(results, events.PlayerResult{UserID: player.User.ID, Side: player.Side, Result: result})
+		UpdateUserRewards(gs.Match.ID, player.User.ID, result)
+		sendMessage(player.User.Client, "end_game", "game_end", map[string]interface{}{
+			"result":        result,
+			"score":         run.Score,
+			"waves_cleared": run.WavesClear,
+			"waves_total":   len(run.Set.Waves),
+			"kills":         run.Kills,
+			"boss_kills":    run.BossKills,
+		})
+	}
+
+	if reason == "" {
+		reason = "defeated"
+		if victory {
+			reason = "survived"
+		}
+	}
+	publishMatchEnded(gs, reason, results, run.Score)
+}
+
+func loadWaveSet(name string) (*WaveSet, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var set WaveSet
+	err := db.MongoDatabase.Collection("pve_waves").FindOne(ctx, bson.M{"name": name}).Decode(&set)
+	if err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+func savePvEScore(match *session.MatchRoom, run *pveRun, userIDs []int, result string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.MongoDatabase.Collection("pve_scores").InsertOne(ctx, bson.M{
+		"match_id":      match.ID,
+		"type":          match.Type,
+		"wave_set":      run.Set.Name,
+		"user_ids":      userIDs,
+		"result":        result,
+		"score":         run.Score,
+		"waves_cleared": run.WavesClear,
+		"kills":         run.Kills,
+		"boss_kills":    run.BossKills,
+		"ended_at":      time.Now(),
+	})
+	if err != nil {
+		log.Printf("Error saving PvE score for match %s: %v", match.ID, err)
+	}
+}
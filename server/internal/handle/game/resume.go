@@ -0,0 +1,105 @@
+package game
+
+import (
+	"log"
+	"time"
+
+	"server/internal/config"
+	"server/internal/events"
+	"server/internal/session"
+	"server/internal/types"
+	"server/internal/wire"
+)
+
+// checkDisconnects đánh dấu người chơi vừa mất kết nối và trả về side bị xử thua
+// khi hết thời gian chờ kết nối lại, -1 nếu chưa có ai bị xử thua.
+// requireAll = true: side chỉ thua khi tất cả người chơi của side đều hết thời gian chờ.
+func checkDisconnects(gs *GameState, requireAll bool) int {
+	grace := time.Duration(config.Config.ReconnectGrace) * time.Second
+
+	for side := 0; side < 2; side++ {
+		expired := 0
+		for _, player := range gs.Players[side] {
+			client := player.User.Client
+			if client == nil || !client.IsClosed() {
+				continue
+			}
+
+			if player.DisconnectedAt.IsZero() {
+				player.DisconnectedAt = time.Now()
+				log.Printf("Match %s: user %d disconnected, waiting %s for reconnect", gs.Match.ID, player.User.ID, grace)
+				broadcastToMatch(gs, "player_disconnected", map[string]interface{}{
+					"user_id":       player.User.ID,
+					"grace_seconds": config.Config.ReconnectGrace,
+				})
+				events.Publish(events.PlayerDisconnected, events.PlayerDisconnectedData{
+					MatchID:      gs.Match.ID,
+					UserID:       player.User.ID,
+					GraceSeconds: config.Config.ReconnectGrace,
+				})
+				continue
+			}
+
+			if time.Since(player.DisconnectedAt) >= grace {
+				if !requireAll {
+					log.Printf("Match %s: user %d did not reconnect in time, forfeiting", gs.Match.ID, player.User.ID)
+					return side
+				}
+				expired++
+			}
+		}
+		if requireAll && expired > 0 && expired == len(gs.Players[side]) {
+			return side
+		}
+	}
+	return -1
+}
+
+// handleResume gắn kết nối mới vào người chơi đang chờ kết nối lại và gửi lại toàn bộ trạng thái
+func handleResume(gs *GameState, c *types.Client, matchRoom chan []byte) {
+	player := findPlayer(gs, c.User.ID)
+	if player == nil {
+		return
+	}
+
+	// Kết nối cũ còn sống chỉ được thay khi c đã giữ phiên của user (takeover)
+	old := player.User.Client
+	if old != nil && !old.IsClosed() && old != c && session.ClientByUser(c.User.ID) != c {
+		sendError(c, "", "resume_failed", "Player is still connected to this match")
+		return
+	}
+
+	player.User.SetClient(c)
+	player.DisconnectedAt = time.Time{}
+	c.User.MatchRoom = matchRoom
+
+	sendMessage(c, "match_resumed", "match_resumed", map[string]interface{}{
+		"roomID": gs.Match.ID,
+		"type":   gs.Match.Type,
+		"tick":   gs.TickCount,
+	})
+	sendMessage(c, "deck", "deck", wire.Deck{V: wire.Version, Player: playerView(player)})
+
+	// Tick sau sẽ gửi lại map và snapshot đầy đủ
+	resetPlayerSync(player)
+
+	log.Printf("Match %s: user %d reconnected", gs.Match.ID, player.User.ID)
+	broadcastToMatch(gs, "player_reconnected", map[string]interface{}{
+		"user_id": player.User.ID,
+	})
+	events.Publish(events.PlayerReconnected, events.PlayerReconnectedData{
+		MatchID: gs.Match.ID,
+		UserID:  player.User.ID,
+	})
+}
+
+// broadcastToMatch gửi message cho tất cả người chơi còn kết nối trong trận
+func broadcastToMatch(gs *GameState, typ string, data interface{}) {
+	for side := 0; side < 2; side++ {
+		for _, player := range gs.Players[side] {
+			if player.User.Client != nil && !player.User.Client.IsClosed() {
+				sendMessage(player.User.Client, typ, typ, data)
+			}
+		}
+	}
+}
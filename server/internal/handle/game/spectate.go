@@ -0,0 +1,210 @@
+package game
+
+import (
+	"log"
+	"time"
+
+	"server/internal/config"
+	"server/internal/session"
+	"server/internal/types"
+	"server/internal/wire"
+)
+
+// Chế độ xem trận:
+//   - Người xem gửi "spectate_match", nhận "spectate_started" rồi "match_map" và
+//     "spectate_frame" mỗi tick, trễ SpectatorDelay giây để tránh báo bài cho người chơi.
+//   - Frame dùng góc nhìn trung lập (map gốc, không đảo) và chỉ chứa bài trên tay
+//     khi trận cho phép (MatchRoom.SpectatorHands).
+//   - Người xem chỉ nhận dữ liệu, không gửi được action vào trận.
+
+// spectatorViewer là viewer trung lập: viewPoint không đảo tọa độ, Own luôn false
+const spectatorViewer = -1
+
+type spectatorFrame struct {
+	At    time.Time
+	Map   *wire.MatchMap // khác nil khi map thay đổi so với frame trước
+	Frame wire.SpectatorFrame
+}
+
+// spectatorState giữ danh sách người xem và hàng đợi frame bị trễ
+type spectatorState struct {
+	Clients     []*types.Client
+	Frames      []spectatorFrame
+	Map         *wire.MatchMap // map tương ứng với frame gần nhất đã phát
+	lastVersion int            // MapVersion của frame gần nhất đã chụp
+}
+
+func newSpectatorState(gs *GameState) *spectatorState {
+	return &spectatorState{
+		Map:         spectatorMap(gs),
+		lastVersion: gs.MapVersion,
+	}
+}
+
+func spectatorMap(gs *GameState) *wire.MatchMap {
+	return &wire.MatchMap{
+		V:          wire.Version,
+		MapVersion: gs.MapVersion,
+		Width:      len(gs.Map[0]),
+		Height:     len(gs.Map),
+		Tiles:      displayMapForSide(gs, 0),
+	}
+}
+
+// handleSpectate thêm hoặc xóa người xem theo yêu cầu gửi vào MatchRoom
+func handleSpectate(gs *GameState, req session.SpectateRequest) {
+	if gs.Spectators == nil {
+		gs.Spectators = newSpectatorState(gs)
+	}
+	st := gs.Spectators
+	c := req.Client
+
+	if req.Leave {
+		for i, s := range st.Clients {
+			if s == c {
+				st.Clients = append(st.Clients[:i], st.Clients[i+1:]...)
+				sendMessage(c, "spectate_left", "spectate_left", map[string]interface{}{
+					"match_id": gs.Match.ID,
+				})
+				return
+			}
+		}
+		sendError(c, "", "not_spectating", "Not spectating this match")
+		return
+	}
+
+	if findPlayer(gs, c.User.ID) != nil {
+		sendError(c, "", "spectate_failed", "Players cannot spectate their own match")
+		return
+	}
+	for _, s := range st.Clients {
+		if s == c {
+			return
+		}
+	}
+	st.Clients = append(st.Clients, c)
+
+	players := make([]map[string]interface{}, 0, 4)
+	for side := 0; side < 2; side++ {
+		for _, p := range gs.Players[side] {
+			players = append(players, map[string]interface{}{
+				"user_id": p.User.ID,
+				"side":    p.Side,
+			})
+		}
+	}
+	sendMessage(c, "spectate_started", "spectate_started", map[string]interface{}{
+		"match_id":      gs.Match.ID,
+		"type":          gs.Match.Type,
+		"delay_seconds": config.Config.SpectatorDelay,
+		"show_hands":    gs.Match.SpectatorHands,
+		"players":       players,
+	})
+	sendMessage(c, "match_map", "match_map", st.Map)
+	log.Printf("Match %s: user %d started spectating", gs.Match.ID, c.User.ID)
+}
+
+// captureSpectatorFrame chụp trạng thái tick hiện tại vào hàng đợi.
+// Phải gọi trước emitCombatEvents vì hàm đó xóa gs.Events.
+func captureSpectatorFrame(gs *GameState) {
+	if gs.Spectators == nil {
+		gs.Spectators = newSpectatorState(gs)
+	}
+	st := gs.Spectators
+
+	frame := wire.SpectatorFrame{
+		V:          wire.Version,
+		Tick:       gs.TickCount,
+		ServerTime: time.Now().UnixMilli(),
+		Entities:   make([]wire.Entity, 0),
+		Players:    make([]wire.SpectatorPlayer, 0, 4),
+		Events:     eventBatchForSide(gs, spectatorViewer).Events,
+	}
+	for _, e := range buildEntityMap(gs, spectatorViewer) {
+		frame.Entities = append(frame.Entities, e)
+	}
+	for side := 0; side < 2; side++ {
+		for _, p := range gs.Players[side] {
+			view := playerView(p)
+			sp := wire.SpectatorPlayer{UserID: p.User.ID, Side: p.Side, Elixir: view.Elixir}
+			if gs.Match.SpectatorHands {
+				sp.Hand = view.Hand
+				sp.Next = view.Next
+			}
+			frame.Players = append(frame.Players, sp)
+		}
+	}
+
+	sf := spectatorFrame{At: time.Now(), Frame: frame}
+	if gs.MapVersion != st.lastVersion {
+		sf.Map = spectatorMap(gs)
+		st.lastVersion = gs.MapVersion
+	}
+	st.Frames = append(st.Frames, sf)
+}
+
+// releaseSpectatorFrames gửi các frame đã đủ thời gian trễ cho người xem.
+// flush = true gửi hết hàng đợi, dùng khi trận kết thúc.
+func releaseSpectatorFrames(gs *GameState, flush bool) {
+	st := gs.Spectators
+	if st == nil {
+		return
+	}
+
+	// Bỏ người xem đã mất kết nối
+	alive := st.Clients[:0]
+	for _, c := range st.Clients {
+		if !c.IsClosed() {
+			alive = append(alive, c)
+		}
+	}
+	st.Clients = alive
+
+	delay := time.Duration(config.Config.SpectatorDelay) * time.Second
+	n := 0
+	for _, sf := range st.Frames {
+		if !flush && time.Since(sf.At) < delay {
+			break
+		}
+		if sf.Map != nil {
+			st.Map = sf.Map
+		}
+		for _, c := range st.Clients {
+			if sf.Map != nil {
+				sendMessage(c, "match_map", "match_map", sf.Map)
+			}
+			sendUpdate(c, "spectate_frame", "spectate_frame", sf.Frame)
+		}
+		n++
+	}
+	st.Frames = st.Frames[n:]
+}
+
+// updateSpectators chụp frame của tick hiện tại và phát các frame đã đủ độ trễ.
+// Không có người xem thì không chụp, chỉ bỏ dần các frame đã quá thời gian trễ.
+func updateSpectators(gs *GameState) {
+	st := gs.Spectators
+	if st == nil {
+		return
+	}
+	if len(st.Clients) == 0 {
+		releaseSpectatorFrames(gs, false)
+		return
+	}
+	captureSpectatorFrame(gs)
+	releaseSpectatorFrames(gs, false)
+}
+
+// endSpectating phát nốt các frame còn lại và báo người xem trận đã kết thúc
+func endSpectating(gs *GameState) {
+	if gs.Spectators == nil {
+		return
+	}
+	releaseSpectatorFrames(gs, true)
+	for _, c := range gs.Spectators.Clients {
+		sendMessage(c, "spectate_ended", "spectate_ended", map[string]interface{}{
+			"match_id": gs.Match.ID,
+		})
+	}
+	gs.Spectators.Clients = nil
+}
@@ -0,0 +1,50 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"server/internal/config"
+	"server/internal/session"
+	"server/internal/types"
+)
+
+func spectateGame(t *testing.T) *GameState {
+	t.Helper()
+	old := config.Config.SpectatorDelay
+	config.Config.SpectatorDelay = 10
+	t.Cleanup(func() { config.Config.SpectatorDelay = old })
+
+	return &GameState{
+		Map:   [][]int{{1, 1}, {1, 1}},
+		Match: &session.MatchRoom{ID: "m1"},
+	}
+}
+
+func TestUpdateSpectatorsSkipsMatchWithoutSpectators(t *testing.T) {
+	gs := spectateGame(t)
+	updateSpectators(gs)
+	if gs.Spectators != nil {
+		t.Fatal("spectator state created for a match nobody watches")
+	}
+}
+
+func TestUpdateSpectatorsKeepsOnlyDelayWindowWithoutViewers(t *testing.T) {
+	gs := spectateGame(t)
+	c := &types.Client{Out: types.NewOutQueue(64, 0), User: types.User{ID: 9}}
+	handleSpectate(gs, session.SpectateRequest{Client: c})
+
+	updateSpectators(gs)
+	updateSpectators(gs)
+	if n := len(gs.Spectators.Frames); n != 2 {
+		t.Fatalf("frames while watched = %d, want 2", n)
+	}
+
+	// Người xem rời: không chụp thêm, frame cũ hơn thời gian trễ bị bỏ
+	handleSpectate(gs, session.SpectateRequest{Client: c, Leave: true})
+	gs.Spectators.Frames[0].At = time.Now().Add(-time.Minute)
+	updateSpectators(gs)
+	if n := len(gs.Spectators.Frames); n != 1 {
+		t.Fatalf("frames after viewer left = %d, want 1 inside the delay window", n)
+	}
+}
@@ -0,0 +1,242 @@
+package game
+
+import (
+	"log"
+	"time"
+
+	"server/internal/config"
+	"server/internal/protocol"
+	"server/internal/wire"
+)
+
+// Đồng bộ trạng thái theo kiểu snapshot + delta:
+//   - "match_map" gửi một lần khi bắt đầu trận (và khi map thay đổi do trụ bị phá)
+//   - "snapshot" gửi toàn bộ entity định kỳ hoặc khi client chưa có baseline
+//   - "delta" mỗi tick chỉ gửi entity thay đổi so với baseline client đã ack
+// Client ack bằng "snapshot_ack" với tick của snapshot/delta đã áp dụng.
+
+// stateUpdateKey là key gộp snapshot/delta trong hàng đợi gửi: client chậm chỉ nhận
+// bản mới nhất. Delta luôn tính từ baseline client đã ack nên bỏ delta cũ vẫn an toàn.
+const stateUpdateKey = "state"
+
+// entityMap là view của các entity theo một góc nhìn, key theo entity id
+type entityMap map[string]wire.Entity
+
+// playerSync giữ trạng thái đồng bộ của một người chơi
+type playerSync struct {
+	MapVersion   int   // phiên bản map đã gửi, -1 = chưa gửi
+	AckedTick    int64 // baseline client đã xác nhận, 0 = chưa có
+	LastFullTick int64 // tick của snapshot gần nhất
+
+	BytesSent int64 // số byte snapshot/delta đã gửi
+	FullBytes int64 // số byte nếu gửi update đầy đủ như trước (chỉ đo khi bật SyncStats)
+}
+
+// syncState là lịch sử entity theo từng góc nhìn, dùng làm baseline cho delta
+type syncState struct {
+	History   [2]map[int64]entityMap
+	StartedAt time.Time
+}
+
+func newSyncState() *syncState {
+	return &syncState{
+		History:   [2]map[int64]entityMap{{}, {}},
+		StartedAt: time.Now(),
+	}
+}
+
+// initSync khởi tạo đồng bộ cho trận và gửi map ban đầu cho tất cả người chơi
+func initSync(gs *GameState) {
+	gs.Sync = newSyncState()
+	for side := 0; side < 2; side++ {
+		for _, player := range gs.Players[side] {
+			player.Sync = playerSync{MapVersion: -1}
+			sendMatchMap(gs, player)
+		}
+	}
+}
+
+func sendMatchMap(gs *GameState, player *PlayerState) {
+	tiles := displayMapForSide(gs, player.Side)
+	sendMessage(player.User.Client, "match_map", "match_map", wire.MatchMap{
+		V:          wire.Version,
+		MapVersion: gs.MapVersion,
+		Width:      len(gs.Map[0]),
+		Height:     len(gs.Map),
+		Tiles:      tiles,
+	})
+	player.Sync.MapVersion = gs.MapVersion
+}
+
+// buildEntityMap tạo view các entity theo góc nhìn của side
+func buildEntityMap(gs *GameState, side int) entityMap {
+	entities := make(entityMap)
+	for i := 0; i < 2; i++ {
+		for j := range gs.Allies[i] {
+			ally := &gs.Allies[i][j]
+			entities[ally.ID] = entityView(gs, i, ally, side)
+		}
+	}
+	return entities
+}
+
+// emitSyncEvents gửi snapshot hoặc delta cho từng người chơi ở cuối tick
+func emitSyncEvents(gs *GameState) {
+	if gs.Sync == nil {
+		initSync(gs)
+	}
+
+	tick := gs.TickCount
+	interval := int64(config.Config.SyncSnapshotInterval)
+	historySize := int64(config.Config.SyncHistory)
+
+	var current [2]entityMap
+	for side := 0; side < 2; side++ {
+		if len(gs.Players[side]) == 0 {
+			continue
+		}
+		current[side] = buildEntityMap(gs, side)
+		gs.Sync.History[side][tick] = current[side]
+		delete(gs.Sync.History[side], tick-historySize)
+	}
+
+	for side := 0; side < 2; side++ {
+		for _, player := range gs.Players[side] {
+			// Client không hỗ trợ delta nhận update đầy đủ như trước
+			if !player.User.Client.HasFeature(protocol.FeatureSnapshotDelta) {
+				sendUpdate(player.User.Client, stateUpdateKey, "update", CreateUpdateEvent(gs, side))
+				continue
+			}
+
+			if player.Sync.MapVersion != gs.MapVersion {
+				sendMatchMap(gs, player)
+			}
+
+			ps := &player.Sync
+			base, hasBase := gs.Sync.History[side][ps.AckedTick]
+
+			var msg outgoingMessage
+			if !hasBase || ps.AckedTick == 0 || tick-ps.LastFullTick >= interval {
+				msg = snapshotMessage(player, tick, current[side])
+				ps.LastFullTick = tick
+			} else {
+				msg = deltaMessage(player, tick, ps.AckedTick, base, current[side])
+			}
+
+			data, err := player.User.Client.Encode(msg)
+			if err != nil {
+				log.Printf("Error marshaling %s: %v", msg.Type, err)
+				continue
+			}
+			ps.BytesSent += int64(len(data))
+			if config.Config.SyncStats {
+				if full, err := player.User.Client.Encode(outgoingMessage{ID: "update", Type: "update", Data: CreateUpdateEvent(gs, side)}); err == nil {
+					ps.FullBytes += int64(len(full))
+				}
+			}
+			if !player.User.Client.IsClosed() {
+				player.User.Client.SendUpdate(stateUpdateKey, data)
+			}
+		}
+	}
+}
+
+func snapshotMessage(player *PlayerState, tick int64, entities entityMap) outgoingMessage {
+	list := make([]wire.Entity, 0, len(entities))
+	for _, e := range entities {
+		list = append(list, e)
+	}
+	return outgoingMessage{
+		ID:   "snapshot",
+		Type: "snapshot",
+		Data: wire.Snapshot{
+			V:          wire.Version,
+			Tick:       tick,
+			ServerTime: time.Now().UnixMilli(),
+			Entities:   list,
+			Player:     playerView(player),
+		},
+	}
+}
+
+func deltaMessage(player *PlayerState, tick, baseTick int64, base, current entityMap) outgoingMessage {
+	delta := wire.Delta{
+		V:          wire.Version,
+		Tick:       tick,
+		ServerTime: time.Now().UnixMilli(),
+		BaseTick:   baseTick,
+		Upserts:    []wire.Entity{},
+		Removed:    []string{},
+		Player:     playerView(player),
+	}
+	for id, e := range current {
+		if old, ok := base[id]; !ok || old != e {
+			delta.Upserts = append(delta.Upserts, e)
+		}
+	}
+	for id := range base {
+		if _, ok := current[id]; !ok {
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+	return outgoingMessage{
+		ID:   "delta",
+		Type: "delta",
+		Data: delta,
+	}
+}
+
+// handleSnapshotAck ghi nhận baseline mới mà client đã áp dụng
+func handleSnapshotAck(gs *GameState, userID int, tick int64) {
+	player := findPlayer(gs, userID)
+	if player == nil || gs.Sync == nil {
+		return
+	}
+	if _, ok := gs.Sync.History[player.Side][tick]; !ok {
+		return // baseline quá cũ hoặc không tồn tại
+	}
+	if tick > player.Sync.AckedTick {
+		player.Sync.AckedTick = tick
+	}
+}
+
+// resetPlayerSync buộc gửi lại map và snapshot đầy đủ cho người chơi ở tick sau
+func resetPlayerSync(player *PlayerState) {
+	player.Sync.MapVersion = -1
+	player.Sync.AckedTick = 0
+}
+
+// logSyncStats ghi lại băng thông trung bình mỗi người chơi khi trận kết thúc
+func logSyncStats(gs *GameState) {
+	if gs.Sync == nil {
+		return
+	}
+	seconds := time.Since(gs.Sync.StartedAt).Seconds()
+	if seconds <= 0 {
+		return
+	}
+	for side := 0; side < 2; side++ {
+		for _, player := range gs.Players[side] {
+			ps := player.Sync
+			if ps.FullBytes > 0 {
+				log.Printf("Match %s user %d: sync %.1f B/s, full updates %.1f B/s (%.0f%% saved)",
+					gs.Match.ID, player.User.ID,
+					float64(ps.BytesSent)/seconds, float64(ps.FullBytes)/seconds,
+					100*(1-float64(ps.BytesSent)/float64(ps.FullBytes)))
+			} else {
+				log.Printf("Match %s user %d: sync %.1f B/s", gs.Match.ID, player.User.ID, float64(ps.BytesSent)/seconds)
+			}
+		}
+	}
+}
+
+func findPlayer(gs *GameState, userID int) *PlayerState {
+	for side := 0; side < 2; side++ {
+		for _, p := range gs.Players[side] {
+			if p.User.ID == userID {
+				return p
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package game
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"server/internal/config"
+	"server/internal/protocol"
+	"server/internal/session"
+	"server/internal/types"
+	"server/internal/wire"
+)
+
+// applyDelta áp dụng delta lên baseline như client làm
+func applyDelta(base entityMap, d wire.Delta) entityMap {
+	out := make(entityMap, len(base))
+	for id, e := range base {
+		out[id] = e
+	}
+	for _, e := range d.Upserts {
+		out[e.ID] = e
+	}
+	for _, id := range d.Removed {
+		delete(out, id)
+	}
+	return out
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	base := entityMap{
+		"a": {ID: "a", Kind: wire.KindTroop, HP: 100, X: 1, Y: 1},
+		"b": {ID: "b", Kind: wire.KindTroop, HP: 50},
+		"c": {ID: "c", Kind: wire.KindGuardTower, HP: 900},
+	}
+	current := entityMap{
+		"a": {ID: "a", Kind: wire.KindTroop, HP: 80, X: 1, Y: 2}, // đổi
+		"c": {ID: "c", Kind: wire.KindGuardTower, HP: 900},       // giữ nguyên
+		"d": {ID: "d", Kind: wire.KindTroop, HP: 30},             // mới
+	}
+	player := &PlayerState{User: &session.User{}, NextCard: -1}
+
+	msg := deltaMessage(player, 12, 10, base, current)
+	d := msg.Data.(wire.Delta)
+	if d.Tick != 12 || d.BaseTick != 10 {
+		t.Fatalf("delta ticks = %d/%d, want 12/10", d.Tick, d.BaseTick)
+	}
+	if len(d.Upserts) != 2 || len(d.Removed) != 1 || d.Removed[0] != "b" {
+		t.Fatalf("delta = %d upserts, removed %v; want 2 upserts, removed [b]", len(d.Upserts), d.Removed)
+	}
+
+	// Qua JSON như trên dây rồi áp dụng lên baseline phải ra đúng trạng thái hiện tại
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded wire.Delta
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got := applyDelta(base, decoded); !reflect.DeepEqual(got, current) {
+		t.Fatalf("applied delta = %v, want %v", got, current)
+	}
+
+	// Không có gì đổi: delta rỗng nhưng vẫn là mảng (client không phải xử lý null)
+	empty := deltaMessage(player, 13, 12, current, current).Data.(wire.Delta)
+	if empty.Upserts == nil || empty.Removed == nil || len(empty.Upserts)+len(empty.Removed) != 0 {
+		t.Fatalf("unchanged delta = %+v, want empty arrays", empty)
+	}
+}
+
+// lastState lấy message snapshot/delta mới nhất trong hàng đợi của client
+func lastState(t *testing.T, c *types.Client) (string, json.RawMessage) {
+	t.Helper()
+	var typ string
+	var data json.RawMessage
+	for {
+		if critical, updates := c.Out.Len(); critical+updates == 0 {
+			return typ, data
+		}
+		msgs, _ := c.Out.Next()
+		for _, m := range msgs {
+			var msg struct {
+				Type string          `json:"type"`
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(m, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type == "snapshot" || msg.Type == "delta" {
+				typ, data = msg.Type, msg.Data
+			}
+		}
+	}
+}
+
+func TestEmitSyncEventsSnapshotThenDelta(t *testing.T) {
+	cfg := config.Config
+	oldInterval, oldHistory := cfg.SyncSnapshotInterval, cfg.SyncHistory
+	cfg.SyncSnapshotInterval, cfg.SyncHistory = 100, 32
+	t.Cleanup(func() { cfg.SyncSnapshotInterval, cfg.SyncHistory = oldInterval, oldHistory })
+
+	c := &types.Client{Out: types.NewOutQueue(64, 0)}
+	c.SetHello("s", types.ClientInfo{Features: []string{protocol.FeatureSnapshotDelta}})
+	player := &PlayerState{User: &session.User{ID: 1, Client: c}, NextCard: -1}
+	gs := &GameState{Map: [][]int{{1, 1}, {1, 1}}, Match: &session.MatchRoom{ID: "m1"}}
+	gs.Players[0] = []*PlayerState{player}
+	gs.Allies[0] = []Allies{
+		{ID: "t1", Type: "troop", Alive: true, Troops: Troop{HP: 100, Location: Position{X: 0, Y: 0, long: 1, wide: 1}}},
+		{ID: "t2", Type: "troop", Alive: true, Troops: Troop{HP: 60, Location: Position{X: 1, Y: 1, long: 1, wide: 1}}},
+	}
+
+	// Chưa có baseline: snapshot đầy đủ
+	gs.TickCount = 1
+	emitSyncEvents(gs)
+	typ, data := lastState(t, c)
+	if typ != "snapshot" {
+		t.Fatalf("first state = %s, want snapshot", typ)
+	}
+	var snap wire.Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatal(err)
+	}
+	client := entityMap{}
+	for _, e := range snap.Entities {
+		client[e.ID] = e
+	}
+	handleSnapshotAck(gs, 1, snap.Tick)
+
+	// Sau ack: delta từ baseline, áp dụng lên bản của client ra đúng trạng thái server
+	gs.Allies[0][0].Troops.HP = 70
+	gs.Allies[0] = gs.Allies[0][:1]
+	gs.TickCount = 2
+	emitSyncEvents(gs)
+	typ, data = lastState(t, c)
+	if typ != "delta" {
+		t.Fatalf("state after ack = %s, want delta", typ)
+	}
+	var d wire.Delta
+	if err := json.Unmarshal(data, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.BaseTick != snap.Tick {
+		t.Fatalf("delta base_tick = %d, want %d", d.BaseTick, snap.Tick)
+	}
+	if got, want := applyDelta(client, d), buildEntityMap(gs, 0); !reflect.DeepEqual(got, want) {
+		t.Fatalf("client state after delta = %v, want %v", got, want)
+	}
+
+	// Ack cho tick không còn trong lịch sử bị bỏ qua
+	handleSnapshotAck(gs, 1, 99)
+	if player.Sync.AckedTick != snap.Tick {
+		t.Fatalf("AckedTick = %d after unknown ack, want %d", player.Sync.AckedTick, snap.Tick)
+	}
+}
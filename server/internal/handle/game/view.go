@@ -0,0 +1,103 @@
+package game
+
+import (
+	"server/internal/session"
+	"server/internal/wire"
+)
+
+// maxElixir là lượng elixir tối đa của một người chơi
+const maxElixir = 10
+
+// matchTick là độ dài một tick tính theo đơn vị 10ms (GameState.Tick)
+const matchTick = 500
+
+// viewPoint đổi tọa độ gốc sang góc nhìn của viewer (xoay 180 độ với side 1)
+func viewPoint(gs *GameState, loc Position, viewer int) (int, int) {
+	x, y := loc.X, loc.Y
+	if viewer == 1 {
+		x, y = MirrorPosition(x, y, len(gs.Map[0]), len(gs.Map))
+		if loc.wide > 1 || loc.long > 1 {
+			x -= loc.wide
+			y -= loc.long
+		}
+	}
+	return x, y
+}
+
+// entityView chuyển một entity nội bộ sang wire.Entity theo góc nhìn của viewer
+func entityView(gs *GameState, side int, ally *Allies, viewer int) wire.Entity {
+	loc := ally.GetLocation()
+	x, y := viewPoint(gs, loc, viewer)
+
+	e := wire.Entity{
+		ID:     ally.ID,
+		Kind:   ally.Type,
+		Side:   side,
+		Own:    side == viewer,
+		Name:   ally.unitName(),
+		X:      x,
+		Y:      y,
+		Width:  loc.wide,
+		Height: loc.long,
+	}
+
+	switch ally.Type {
+	case "troop":
+		e.Level = ally.Troops.CardInfo.Level
+		e.HP = ally.Troops.HP
+		e.MaxHP = ally.Troops.CardInfo.Info.Hp
+		e.Shield = ally.Troops.Shield
+		e.TargetID = ally.Troops.TargetID
+	case "spell":
+		e.Level = ally.Spells.CardInfo.Level
+	case "guard_tower":
+		e.Level = ally.Guard.GuardInfo.Level
+		e.HP = ally.Guard.HP
+		e.MaxHP = ally.Guard.GuardInfo.Info.Hp
+		e.Shield = ally.Guard.Shield
+		e.TargetID = ally.Guard.TargetID
+	case "king_tower":
+		e.Level = ally.King.KingInfo.Level
+		e.HP = ally.King.HP
+		e.MaxHP = ally.King.KingInfo.Info.Hp
+		e.Shield = ally.King.Shield
+		e.TargetID = ally.King.TargetID
+		e.Active = ally.King.Active
+	}
+	return e
+}
+
+// handCard tra tên và mana của lá bài theo card id trong deck người chơi
+func handCard(player *PlayerState, slot, cardID int) wire.HandCard {
+	hc := wire.HandCard{Slot: slot, CardID: cardID}
+	for _, list := range [][]session.Card{player.User.DataGame.Troops, player.User.DataGame.Spells} {
+		for _, card := range list {
+			if card.Index == cardID {
+				hc.Name = card.Name
+				hc.Mana = card.Info.Mana
+				return hc
+			}
+		}
+	}
+	return hc
+}
+
+// playerView chuyển tay bài và elixir của người chơi sang wire.PlayerView
+func playerView(player *PlayerState) wire.PlayerView {
+	view := wire.PlayerView{
+		Hand: make([]wire.HandCard, 0, len(player.Hand)),
+		Elixir: wire.Elixir{
+			Value:    player.Elixir,
+			Max:      maxElixir,
+			Progress: player.ElixirTimer,
+		},
+	}
+	for i, id := range player.Hand {
+		view.Hand = append(view.Hand, handCard(player, i, id))
+	}
+	if player.NextCard >= 0 {
+		next := handCard(player, -1, player.NextCard)
+		view.Next = &next
+	}
+	return view
+}
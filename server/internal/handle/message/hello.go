@@ -0,0 +1,98 @@
+package message
+
+import (
+	"fmt"
+	"log"
+
+	"server/internal/codec"
+	"server/internal/config"
+	"server/internal/protocol"
+	"server/internal/router"
+	"server/internal/types"
+	"server/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+type HelloRequest struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	Platform        string   `json:"platform"`
+	Build           string   `json:"build"`
+	Features        []string `json:"features"`
+}
+
+func (r *HelloRequest) Validate() error {
+	if r.ProtocolVersion <= 0 {
+		return router.Errorf("invalid_data", "Missing or invalid protocol_version")
+	}
+	return nil
+}
+
+type HelloResponse struct {
+	SessionID       string   `json:"session_id"`
+	ServerVersion   string   `json:"server_version"`
+	ProtocolVersion int      `json:"protocol_version"`
+	Features        []string `json:"features"`
+	UserID          int      `json:"user_id,omitempty"` // khác 0 khi socket đã xác thực lúc nâng cấp
+}
+
+func HandleHello(c *types.Client, incoming utils.IncomingMessage, req HelloRequest) {
+	if c.Greeted() {
+		utils.SendError(c, incoming.ID, "already_greeted", "Hello already completed")
+		return
+	}
+
+	if req.ProtocolVersion < config.Config.MinProtocolVersion {
+		log.Printf("Rejected %s client build %q: protocol %d < %d",
+			req.Platform, req.Build, req.ProtocolVersion, config.Config.MinProtocolVersion)
+		utils.SendMessage(c, incoming.ID, "upgrade_required", map[string]any{
+			"min_protocol_version": config.Config.MinProtocolVersion,
+			"protocol_version":     protocol.Version,
+			"server_version":       protocol.ServerVersion,
+			"message": fmt.Sprintf("Client protocol %d is no longer supported, please update to protocol %d or newer",
+				req.ProtocolVersion, config.Config.MinProtocolVersion),
+		})
+		return
+	}
+
+	features := protocol.Negotiate(req.Features)
+	// msgpack chỉ bật khi kết nối thực sự dùng subprotocol MessagePack
+	if c.Codec != codec.Msgpack {
+		filtered := features[:0]
+		for _, f := range features {
+			if f != protocol.FeatureMsgpack {
+				filtered = append(filtered, f)
+			}
+		}
+		features = filtered
+	}
+
+	// Client mới hơn server vẫn được nhận, nhưng nói chuyện theo phiên bản của server
+	version := req.ProtocolVersion
+	if version > protocol.Version {
+		version = protocol.Version
+	}
+
+	c.SetHello(uuid.NewString(), types.ClientInfo{
+		ProtocolVersion: version,
+		Platform:        req.Platform,
+		Build:           req.Build,
+		Features:        features,
+	})
+	if c.Out != nil {
+		c.Out.SetBatching(c.HasFeature(protocol.FeatureBatch))
+	}
+
+	utils.SendMessage(c, incoming.ID, "hello_ok", HelloResponse{
+		SessionID:       c.SessionID,
+		ServerVersion:   protocol.ServerVersion,
+		ProtocolVersion: version,
+		Features:        features,
+		UserID:          c.User.ID,
+	})
+
+	// Socket đã xác thực khi nâng cấp: vào lại trận đang dở ngay sau hello
+	if c.User.ID != 0 {
+		resumeMatch(c, incoming.ID)
+	}
+}
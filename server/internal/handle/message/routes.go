@@ -0,0 +1,54 @@
+package message
+
+import (
+	"time"
+
+	"server/internal/router"
+)
+
+// Register đăng ký tất cả message type của game vào r
+func Register(r *router.Router) {
+	router.Handle(r, "hello", HandleHello, router.Public(), router.AllowBeforeHello(), router.RateLimit(5, time.Minute))
+	router.Handle(r, "time_sync", HandleTimeSync, router.RateLimit(20, time.Minute))
+
+	// Tài khoản
+	// Route Public gọi được khi chưa đăng nhập: hello, đăng nhập/đăng ký, refresh token,
+	// xác thực email và đặt lại mật khẩu
+	router.Handle(r, "login", HandleLogin, router.Public(), router.RateLimit(10, time.Minute))
+	router.Handle(r, "re_login", HandleReLogin, router.Public(), router.RateLimit(10, time.Minute))
+	router.Handle(r, "register", HandleRegister, router.Public(), router.RateLimit(5, time.Minute))
+	router.Handle(r, "refresh_token", HandleRefreshToken, router.Public(), router.RateLimit(10, time.Minute))
+	r.HandleFunc("logout", HandleLogout, router.RateLimit(10, time.Minute))
+	r.HandleFunc("logout_all", HandleLogoutAll, router.RateLimit(5, time.Minute))
+
+	// Xác thực email và quên mật khẩu
+	router.Handle(r, "verify_email", HandleVerifyEmail, router.Public(), router.RateLimit(10, time.Minute))
+	r.HandleFunc("resend_verification", HandleResendVerification, router.RateLimit(3, time.Minute))
+	router.Handle(r, "request_password_reset", HandleRequestPasswordReset, router.Public(), router.RateLimit(3, time.Minute))
+	router.Handle(r, "reset_password", HandleResetPassword, router.Public(), router.RateLimit(5, time.Minute))
+
+	// Đổi thông tin và xóa tài khoản, đều cần nhập lại mật khẩu
+	router.Handle(r, "change_username", HandleChangeUsername, router.RateLimit(5, time.Minute))
+	router.Handle(r, "change_email", HandleChangeEmail, router.RateLimit(3, time.Minute))
+	router.Handle(r, "change_password", HandleChangePassword, router.RateLimit(5, time.Minute))
+	router.Handle(r, "delete_account", HandleDeleteAccount, router.RateLimit(3, time.Minute))
+
+	r.HandleFunc("get_profile", HandleGetProfile, router.RateLimit(30, time.Minute))
+	r.HandleFunc("get_user_cards", HandleGetUserCards, router.RateLimit(30, time.Minute))
+	r.HandleFunc("get_user_deck", HandleGetUserDeck, router.RateLimit(30, time.Minute))
+	router.Handle(r, "swap_card", HandleSwapCard, router.RateLimit(30, time.Minute))
+
+	// Lobby
+	router.Handle(r, "create_lobby", HandleCreateLobby, router.RateLimit(10, time.Minute))
+	router.Handle(r, "join_lobby", HandleJoinLobby, router.RateLimit(20, time.Minute))
+	router.Handle(r, "match_lobby", HandleMatchLobby, router.RateLimit(20, time.Minute))
+	router.Handle(r, "leave_lobby", HandleLeaveLobby, router.RateLimit(20, time.Minute))
+
+	// Trong trận
+	router.Handle(r, "Release_card", HandleReleaseCard, router.RateLimit(10, time.Second))
+	router.Handle(r, "snapshot_ack", HandleSnapshotAck)
+
+	// Xem trận
+	router.Handle(r, "spectate_match", HandleSpectateMatch, router.RateLimit(10, time.Minute))
+	router.Handle(r, "leave_spectate", HandleLeaveSpectate, router.RateLimit(10, time.Minute))
+}
@@ -0,0 +1,40 @@
+package message
+
+import (
+	"time"
+
+	"server/internal/session"
+	"server/internal/types"
+	"server/internal/utils"
+)
+
+type TimeSyncRequest struct {
+	ClientTime int64 `json:"client_time"` // thời điểm client gửi, trả lại nguyên vẹn
+}
+
+type TimeSyncResponse struct {
+	ClientTime int64   `json:"client_time"`
+	ServerTime int64   `json:"server_time"` // unix milli giây
+	RTT        float64 `json:"rtt_ms"`      // RTT server đo qua ping/pong, 0 nếu chưa có
+	Tick       int64   `json:"tick,omitempty"`
+	TickMS     int64   `json:"tick_ms,omitempty"` // độ dài một tick, chỉ có khi đang trong trận
+}
+
+// HandleTimeSync trả thời gian server để client ước lượng độ lệch đồng hồ:
+// offset ≈ server_time + rtt/2 - thời điểm client nhận phản hồi
+func HandleTimeSync(c *types.Client, incoming utils.IncomingMessage, req TimeSyncRequest) {
+	resp := TimeSyncResponse{
+		ClientTime: req.ClientTime,
+		ServerTime: time.Now().UnixMilli(),
+		RTT:        float64(c.RTT().Microseconds()) / 1000,
+	}
+
+	if c.User.ID != 0 {
+		if match := session.FindMatchByUser(c.User.ID); match != nil {
+			resp.Tick = match.Tick.Load()
+			resp.TickMS = match.TickInterval.Milliseconds()
+		}
+	}
+
+	utils.SendMessage(c, incoming.ID, "time_sync", resp)
+}
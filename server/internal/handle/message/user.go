@@ -1,21 +1,16 @@
 package message
 
 import (
-	"context"
-	"database/sql"
 	"encoding/json"
-	"errors"
 	"log"
-	"server/internal/auth"
-	"server/internal/db"
+	"server/internal/config"
+	"server/internal/router"
+	"server/internal/service"
 	"server/internal/session"
 	"server/internal/types"
 	"server/internal/utils"
-	"time"
 
 	"github.com/google/uuid"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type LoginRequest struct {
@@ -23,547 +18,529 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+func (r *LoginRequest) Validate() error {
+	if r.Gmail == "" || r.Password == "" {
+		return router.Errorf("missing_fields", "Username or password missing")
+	}
+	return nil
+}
+
 type LoginResponse struct {
-	Token    string `json:"token"`
-	Username string `json:"username"`
+	Token        string `json:"token"` // access token
+	Username     string `json:"username"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"` // số giây còn lại của access token
+}
+
+func loginResponse(acc *service.Account) LoginResponse {
+	return LoginResponse{
+		Token:        acc.Token,
+		Username:     acc.Username,
+		RefreshToken: acc.RefreshToken,
+		ExpiresIn:    acc.ExpiresIn(),
+	}
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (r *RefreshTokenRequest) Validate() error {
+	if r.RefreshToken == "" {
+		return router.Errorf("missing_fields", "Refresh token missing")
+	}
+	return nil
 }
 
 type ReLoginRequest struct {
 	Token string `json:"token"`
 }
 
+func (r *ReLoginRequest) Validate() error {
+	if r.Token == "" {
+		return router.Errorf("missing_fields", "Token missing")
+	}
+	return nil
+}
+
 type RegisterRequest struct {
 	Gmail    string `json:"gmail"`
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
-// Chỉ dùng cho King Tower (chỉ có name)
-type SimpleTowerInfo struct {
-	Name string `bson:"name" json:"name"`
+func (r *RegisterRequest) Validate() error {
+	if r.Gmail == "" || r.Username == "" || r.Password == "" {
+		return router.Errorf("missing_fields", "Missing fields")
+	}
+	return nil
 }
 
-// Dùng cho Guard Tower (có level và count)
-type TowerInfo struct {
-	Name  string `bson:"name" json:"name"`
-	Level int    `bson:"level" json:"level"`
-	Count int    `bson:"count" json:"count"`
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
 }
 
-// Dùng cho user_cards (không có slot/index)
-type CardInfo struct {
-	Name  string `bson:"name" json:"name"`
-	Level int    `bson:"level" json:"level"`
-	Count int    `bson:"count" json:"count"`
+func (r *VerifyEmailRequest) Validate() error {
+	if r.Token == "" {
+		return router.Errorf("missing_fields", "Token missing")
+	}
+	return nil
 }
 
-// Dùng cho user_decks (có slot/index)
-type DeckCard struct {
-	Index int    `bson:"index" json:"index"`
-	Name  string `bson:"name" json:"name"`
-	Level int    `bson:"level" json:"level"`
+type PasswordResetRequest struct {
+	Gmail string `json:"gmail"`
 }
 
-type UserCards struct {
-	UserID     int               `bson:"user_id" json:"user_id"`
-	KingTower  []SimpleTowerInfo `bson:"king_tower" json:"king_tower"`   // Mảng
-	GuardTower []TowerInfo       `bson:"guard_tower" json:"guard_tower"` // Mảng
-	Cards      []CardInfo        `bson:"cards" json:"cards"`
+func (r *PasswordResetRequest) Validate() error {
+	if r.Gmail == "" {
+		return router.Errorf("missing_fields", "Gmail missing")
+	}
+	return nil
 }
 
-type UserDeck struct {
-	UserID     int             `bson:"user_id" json:"user_id"`
-	KingTower  SimpleTowerInfo `bson:"king_tower" json:"king_tower"`   // Object
-	GuardTower TowerInfo       `bson:"guard_tower" json:"guard_tower"` // Object
-	Cards      []DeckCard      `bson:"cards" json:"cards"`
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
 }
 
-// Dữ liệu đầu vào chung cho lobby
-type LobbyRequest struct {
-	RoomType string `json:"room_type"`
-	LobbyID  string `json:"lobby_id,omitempty"` // Chỉ dùng khi join
+func (r *ResetPasswordRequest) Validate() error {
+	if r.Token == "" || r.Password == "" {
+		return router.Errorf("missing_fields", "Missing fields")
+	}
+	return nil
 }
 
-func HandleLogin(c *types.Client, incoming utils.IncomingMessage) {
-	if c.User.ID != 0 {
-		utils.SendError(c.Send, incoming.ID, "already_logged_in", "User already logged in")
-		return
-	}
+// ChangeCredentialsRequest dùng chung cho change_username, change_email và delete_account;
+// mọi thao tác đều cần mật khẩu hiện tại
+type ChangeCredentialsRequest struct {
+	Password string `json:"password"`
+	Username string `json:"username,omitempty"` // chỉ dùng cho change_username
+	Gmail    string `json:"gmail,omitempty"`    // chỉ dùng cho change_email
+}
 
-	var req LoginRequest
-	if err := json.Unmarshal(incoming.Data, &req); err != nil {
-		utils.SendError(c.Send, incoming.ID, "invalid_payload", "Invalid login format")
-		return
+func (r *ChangeCredentialsRequest) Validate() error {
+	if r.Password == "" {
+		return router.Errorf("missing_fields", "Current password required")
 	}
+	return nil
+}
 
-	if req.Gmail == "" || req.Password == "" {
-		utils.SendError(c.Send, incoming.ID, "missing_fields", "Username or password missing")
-		return
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+func (r *ChangePasswordRequest) Validate() error {
+	if r.OldPassword == "" || r.NewPassword == "" {
+		return router.Errorf("missing_fields", "Missing fields")
 	}
+	return nil
+}
 
-	var storedPassword, username string
-	var id int
+type SwapCardRequest struct {
+	CardName  string `json:"card_name"`  // Thẻ mới muốn thay vào
+	SlotIndex int    `json:"slot_index"` // Vị trí trong deck (1–8)
+}
 
-	query := `SELECT id, username, password FROM users WHERE gmail = ?`
-	err := db.DB.QueryRow(query, req.Gmail).Scan(&id, &username, &storedPassword)
-	if err == sql.ErrNoRows {
-		utils.SendError(c.Send, incoming.ID, "not_found", "User not found")
-		return
-	} else if err != nil {
-		log.Printf("DB error: %v", err)
-		utils.SendError(c.Send, incoming.ID, "server_error", "Database error")
-		return
+func (r *SwapCardRequest) Validate() error {
+	if r.SlotIndex < 1 || r.SlotIndex > 8 {
+		return router.Errorf("invalid_slot", "Invalid slot index (must be from 1 to 8)")
 	}
+	return nil
+}
 
-	if storedPassword != req.Password {
-		utils.SendError(c.Send, incoming.ID, "invalid_credentials", "Invalid username or password")
-		return
-	}
+type ReleaseCardRequest struct {
+	CardID     int   `json:"card_id"`
+	X          int   `json:"x"`
+	Y          int   `json:"y"`
+	ClientTick int64 `json:"client_tick"` // tick server mới nhất client đã thấy
+}
 
-	if session.IsUserLoggedIn(id) {
-		utils.SendError(c.Send, incoming.ID, "already_logged_in", "This account is already logged in on another device.")
-		return
-	} else {
-		c.User.ID = id
-		c.User.Frame = 2
+func (r *ReleaseCardRequest) Validate() error {
+	if r.CardID < 0 || r.CardID > 7 {
+		return router.Errorf("invalid_card", "Invalid card id %d (must be from 0 to 7)", r.CardID)
 	}
+	return nil
+}
 
-	token, err := auth.GenerateToken(id, req.Gmail, username)
+type SnapshotAckRequest struct {
+	Tick int64 `json:"tick"`
+}
 
-	if err != nil {
-		utils.SendError(c.Send, incoming.ID, "token_error", "Failed to generate token")
-		return
+func (r *SnapshotAckRequest) Validate() error {
+	if r.Tick <= 0 {
+		return router.Errorf("invalid_payload", "Invalid snapshot ack format")
 	}
+	return nil
+}
 
-	utils.SendMessage(c.Send, incoming.ID, "login_success", LoginResponse{
-		Token:    token,
-		Username: username,
-	})
+type SpectateRequest struct {
+	MatchID string `json:"match_id"`
+}
 
+func (r *SpectateRequest) Validate() error {
+	if r.MatchID == "" {
+		return router.Errorf("invalid_data", "Missing or invalid match ID")
+	}
+	return nil
 }
 
-func HandleReLogin(c *types.Client, incoming utils.IncomingMessage) {
+// Dữ liệu đầu vào chung cho lobby
+type LobbyRequest struct {
+	RoomType       string `json:"room_type"`
+	LobbyID        string `json:"lobby_id,omitempty"`        // Chỉ dùng khi join
+	SpectatorHands bool   `json:"spectator_hands,omitempty"` // Chỉ dùng khi tạo phòng riêng
+}
+
+func HandleLogin(c *types.Client, incoming utils.IncomingMessage, req LoginRequest) {
 	if c.User.ID != 0 {
-		utils.SendError(c.Send, incoming.ID, "already_logged_in", "User already logged in")
+		utils.SendError(c, incoming.ID, "already_logged_in", "User already logged in")
 		return
 	}
 
-	var req ReLoginRequest
-	if err := json.Unmarshal(incoming.Data, &req); err != nil {
-		utils.SendError(c.Send, incoming.ID, "invalid_payload", "Invalid re_login format")
+	acc, err := service.Authenticate(req.Gmail, req.Password)
+	if err != nil {
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
 
-	if req.Token == "" {
-		utils.SendError(c.Send, incoming.ID, "missing_fields", "Token missing")
+	// Cấp token trước khi gắn user: nếu cấp lỗi thì thiết bị kia chưa bị ngắt (takeover)
+	if config.Config.SessionPolicy != utils.SessionTakeover && session.IsUserLoggedIn(acc.UserID) {
+		utils.SendError(c, incoming.ID, "already_logged_in", "This account is already logged in on another device.")
 		return
 	}
-
-	// Sử dụng ValidateTokenWithMongo để kiểm tra token và lấy username
-	claims, err := auth.ValidateTokenWithMongo(req.Token)
-	if err != nil {
-		utils.SendError(c.Send, incoming.ID, "invalid_token", err.Error())
+	if err := service.IssueToken(acc); err != nil {
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
 
-	if session.IsUserLoggedIn(claims.ID) {
-		utils.SendError(c.Send, incoming.ID, "already_logged_in", "This account is already logged in on another device.")
+	if !utils.ClaimUser(c, acc.UserID) {
+		// Thiết bị khác vừa đăng nhập giữa lúc cấp token: thu hồi phiên vừa tạo
+		if err := service.Logout(acc.UserID, acc.SessionID); err != nil {
+			log.Printf("Error revoking unused session of user %d: %v", acc.UserID, err)
+		}
+		utils.SendError(c, incoming.ID, "already_logged_in", "This account is already logged in on another device.")
 		return
 	}
+	c.AuthSession = acc.SessionID
 
-	// Lưu thông tin user vào client
-	c.User.ID = claims.ID
-	c.User.Frame = 2
+	utils.SendMessage(c, incoming.ID, "login_success", loginResponse(acc))
 
-	// Gửi lại token cũ (vì là vĩnh viễn)
-	utils.SendMessage(c.Send, incoming.ID, "login_success", LoginResponse{
-		Token:    req.Token,
-		Username: claims.Username,
-	})
+	resumeMatch(c, incoming.ID)
 }
 
-func HandleRegister(c *types.Client, incoming utils.IncomingMessage) {
-	var req RegisterRequest
-	if err := json.Unmarshal(incoming.Data, &req); err != nil {
-		utils.SendError(c.Send, incoming.ID, "invalid_payload", "Invalid register format")
+func HandleReLogin(c *types.Client, incoming utils.IncomingMessage, req ReLoginRequest) {
+	if c.User.ID != 0 {
+		utils.SendError(c, incoming.ID, "already_logged_in", "User already logged in")
 		return
 	}
 
-	if req.Gmail == "" || req.Username == "" || req.Password == "" {
-		utils.SendError(c.Send, incoming.ID, "missing_fields", "Missing fields")
+	// Kiểm tra token với MongoDB và lấy username
+	acc, err := service.ValidateToken(req.Token)
+	if err != nil {
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
 
-	// --- Bắt đầu transaction MySQL ---
-	tx, err := db.DB.Begin()
-	if err != nil {
-		utils.SendError(c.Send, incoming.ID, "server_error", "Failed to start SQL transaction")
+	// Lưu thông tin user vào client
+	if !utils.ClaimUser(c, acc.UserID) {
+		utils.SendError(c, incoming.ID, "already_logged_in", "This account is already logged in on another device.")
 		return
 	}
+	c.AuthSession = acc.SessionID
 
-	// Đánh dấu để kiểm soát rollback thủ công
-	committed := false
-	defer func() {
-		if !committed {
-			_ = tx.Rollback()
-		}
-	}()
+	// Access token còn hạn được gửi lại nguyên vẹn; hết hạn thì client dùng refresh_token
+	utils.SendMessage(c, incoming.ID, "login_success", loginResponse(acc))
 
-	// Kiểm tra Gmail đã tồn tại chưa
-	var exists int
-	err = tx.QueryRow(`SELECT COUNT(*) FROM users WHERE gmail = ?`, req.Gmail).Scan(&exists)
-	if err != nil {
-		utils.SendError(c.Send, incoming.ID, "server_error", "Database error")
-		return
-	}
-	if exists > 0 {
-		utils.SendError(c.Send, incoming.ID, "duplicate", "Gmail already registered")
+	resumeMatch(c, incoming.ID)
+}
+
+// resumeMatch gắn client vừa đăng nhập lại vào trận đang chờ người chơi này (nếu có)
+func resumeMatch(c *types.Client, msgID string) {
+	match := session.FindMatchByUser(c.User.ID)
+	if match == nil || match.Resume == nil {
 		return
 	}
 
-	// Thêm user mới
-	result, err := tx.Exec(`INSERT INTO users (gmail, username, password) VALUES (?, ?, ?)`, req.Gmail, req.Username, req.Password)
-	if err != nil {
-		utils.SendError(c.Send, incoming.ID, "server_error", "Failed to create user")
-		return
+	select {
+	case match.Resume <- c:
+	default:
+		utils.SendError(c, msgID, "resume_failed", "Unable to rejoin match, please retry")
 	}
+}
 
-	uid64, err := result.LastInsertId()
-	if err != nil {
-		utils.SendError(c.Send, incoming.ID, "server_error", "Failed to get user ID")
+func HandleRegister(c *types.Client, incoming utils.IncomingMessage, req RegisterRequest) {
+	if c.User.ID != 0 {
+		utils.SendError(c, incoming.ID, "already_logged_in", "User already logged in")
 		return
 	}
-	userID := int(uid64)
 
-	// Thêm user_stats
-	_, err = tx.Exec(`INSERT INTO user_stats (user_id, level, experience, gold, gems) VALUES (?, 1, 0, 500, 10)`, userID)
+	acc, err := service.Register(req.Gmail, req.Username, req.Password)
 	if err != nil {
-		utils.SendError(c.Send, incoming.ID, "server_error", "Failed to insert user stats")
-		return
-	}
-
-	// --- Thêm MongoDB trước khi commit MySQL ---
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	userCards := bson.M{
-		"user_id":     userID,
-		"king_tower":  []bson.M{{"name": "King_Tower"}},
-		"guard_tower": []bson.M{{"name": "Guard_Tower", "level": 1, "count": 0}},
-		"cards": []bson.M{
-			{"name": "Pawn", "level": 1, "count": 0},
-			{"name": "Bishop", "level": 1, "count": 0},
-			{"name": "Rook", "level": 1, "count": 0},
-			{"name": "Knight", "level": 1, "count": 0},
-			{"name": "Prince", "level": 1, "count": 0},
-			{"name": "Queen", "level": 1, "count": 0},
-			{"name": "Fireball", "level": 1, "count": 0},
-			{"name": "Healing Light", "level": 1, "count": 0},
-		},
-	}
-
-	userDeck := bson.M{
-		"user_id":     userID,
-		"king_tower":  bson.M{"name": "King_Tower"},
-		"guard_tower": bson.M{"name": "Guard_Tower", "level": 1},
-		"cards": []bson.M{
-			{"index": 1, "name": "Pawn", "level": 1},
-			{"index": 2, "name": "Bishop", "level": 1},
-			{"index": 3, "name": "Rook", "level": 1},
-			{"index": 4, "name": "Knight", "level": 1},
-			{"index": 5, "name": "Prince", "level": 1},
-			{"index": 6, "name": "Queen", "level": 1},
-			{"index": 7, "name": "Fireball", "level": 1},
-			{"index": 8, "name": "Healing Light", "level": 1},
-		},
-	}
-
-	cardCol := db.MongoDatabase.Collection("user_cards")
-	deckCol := db.MongoDatabase.Collection("user_decks")
-
-	if _, err = cardCol.InsertOne(ctx, userCards); err != nil {
-		utils.SendError(c.Send, incoming.ID, "mongo_error", "Failed to insert user cards")
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
 
-	if _, err = deckCol.InsertOne(ctx, userDeck); err != nil {
-		// Nếu insert deck fail, xóa userCards để giữ đồng bộ
-		_, _ = cardCol.DeleteOne(ctx, bson.M{"user_id": userID})
-		utils.SendError(c.Send, incoming.ID, "mongo_error", "Failed to insert user deck")
+	// Tài khoản mới nên thường không có phiên nào khác; vẫn kiểm tra cho chắc
+	if !utils.ClaimUser(c, acc.UserID) {
+		if err := service.Logout(acc.UserID, acc.SessionID); err != nil {
+			log.Printf("Error revoking unused session of user %d: %v", acc.UserID, err)
+		}
+		utils.SendError(c, incoming.ID, "already_logged_in", "This account is already logged in on another device.")
 		return
 	}
+	c.AuthSession = acc.SessionID
 
-	// Nếu MongoDB thành công, commit MySQL transaction
-	if err := tx.Commit(); err != nil {
-		utils.SendError(c.Send, incoming.ID, "server_error", "Failed to commit SQL transaction")
-		return
-	}
-	committed = true
+	utils.SendMessage(c, incoming.ID, "register_success", loginResponse(acc))
+}
 
-	// Tạo token cho user mới
-	token, err := auth.GenerateToken(userID, req.Gmail, req.Username)
+// HandleRefreshToken đổi refresh token lấy cặp token mới, không đổi trạng thái đăng nhập của socket
+func HandleRefreshToken(c *types.Client, incoming utils.IncomingMessage, req RefreshTokenRequest) {
+	acc, err := service.Refresh(req.RefreshToken)
 	if err != nil {
-		utils.SendError(c.Send, incoming.ID, "token_error", "Failed to generate token")
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
+	if c.User.ID == acc.UserID {
+		c.AuthSession = acc.SessionID
+	}
 
-	// Gán userID cho client
-	c.User.ID = userID
-	c.User.Frame = 2
-
-	utils.SendMessage(c.Send, incoming.ID, "register_success", LoginResponse{
-		Token:    token,
-		Username: req.Username,
-	})
-
+	utils.SendMessage(c, incoming.ID, "token_refreshed", loginResponse(acc))
 }
 
-func HandleGetUserCards(c *types.Client, incoming utils.IncomingMessage) {
-	if c.User.ID == 0 {
-		utils.SendError(c.Send, incoming.ID, "unauthorized", "User not logged in")
-		return
-	}
-
-	userCards, err := GetUserCardsByID(c.User.ID)
+func HandleVerifyEmail(c *types.Client, incoming utils.IncomingMessage, req VerifyEmailRequest) {
+	userID, err := service.VerifyEmail(req.Token)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			utils.SendError(c.Send, incoming.ID, "not_found", "No user cards found")
-		} else {
-			utils.SendError(c.Send, incoming.ID, "mongo_error", "Failed to retrieve user cards")
-		}
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
-
-	utils.SendMessage(c.Send, incoming.ID, "user_cards", userCards)
-
+	utils.SendMessage(c, incoming.ID, "email_verified", map[string]int{"user_id": userID})
 }
 
-func GetUserCardsByID(userID int) (*UserCards, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var result UserCards
-	err := db.MongoDatabase.Collection("user_cards").
-		FindOne(ctx, bson.M{"user_id": userID}).
-		Decode(&result)
-
-	if err != nil {
-		return nil, err
+func HandleResendVerification(c *types.Client, incoming utils.IncomingMessage) {
+	if err := service.ResendVerification(c.User.ID); err != nil {
+		sendServiceError(c, incoming.ID, err)
+		return
 	}
-
-	return &result, nil
+	utils.SendMessage(c, incoming.ID, "verification_sent", map[string]bool{"sent": true})
 }
 
-func HandleGetUserDeck(c *types.Client, incoming utils.IncomingMessage) {
-	if c.User.ID == 0 {
-		utils.SendError(c.Send, incoming.ID, "unauthorized", "User not logged in")
+// HandleRequestPasswordReset luôn trả thành công để không lộ gmail nào đã đăng ký
+func HandleRequestPasswordReset(c *types.Client, incoming utils.IncomingMessage, req PasswordResetRequest) {
+	if err := service.RequestPasswordReset(req.Gmail); err != nil {
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
+	utils.SendMessage(c, incoming.ID, "password_reset_requested", map[string]bool{"sent": true})
+}
 
-	userDeck, err := GetUserDeckByID(c.User.ID)
-	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			utils.SendError(c.Send, incoming.ID, "not_found", "No user deck found")
-		} else {
-			utils.SendError(c.Send, incoming.ID, "mongo_error", "Failed to retrieve user deck")
-		}
+func HandleResetPassword(c *types.Client, incoming utils.IncomingMessage, req ResetPasswordRequest) {
+	if err := service.ResetPassword(req.Token, req.Password); err != nil {
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
-
-	utils.SendMessage(c.Send, incoming.ID, "user_deck", userDeck)
-
+	utils.SendMessage(c, incoming.ID, "password_reset", map[string]bool{"reset": true})
 }
 
-func GetUserDeckByID(userID int) (*UserDeck, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+func HandleLogout(c *types.Client, incoming utils.IncomingMessage) {
+	logout(c, incoming, false)
+}
 
-	var result UserDeck
-	err := db.MongoDatabase.Collection("user_decks").
-		FindOne(ctx, bson.M{"user_id": userID}).
-		Decode(&result)
+func HandleLogoutAll(c *types.Client, incoming utils.IncomingMessage) {
+	logout(c, incoming, true)
+}
 
-	if err != nil {
-		return nil, err
+// rejectInGame báo lỗi và trả true khi user đang trong trận hoặc giữ slot phòng chờ:
+// bỏ user khỏi socket lúc này sẽ để lại người chơi không có id khi trận bắt đầu
+func rejectInGame(c *types.Client, id string, action string) bool {
+	if session.FindMatchByUser(c.User.ID) != nil {
+		utils.SendError(c, id, "in_match", "Cannot "+action+" during a match")
+		return true
 	}
-
-	return &result, nil
+	if utils.LobbyOf(c) != "" {
+		utils.SendError(c, id, "in_lobby", "Leave the lobby before you "+action)
+		return true
+	}
+	return false
 }
 
-func HandleSwapCard(c *types.Client, incoming utils.IncomingMessage) {
-	if c.User.ID == 0 {
-		utils.SendError(c.Send, incoming.ID, "unauthorized", "User not logged in")
+// logout thu hồi token (phiên hiện tại hoặc mọi phiên) rồi đưa socket về trạng thái chưa đăng nhập
+func logout(c *types.Client, incoming utils.IncomingMessage, all bool) {
+	if rejectInGame(c, incoming.ID, "log out") {
 		return
 	}
 
-	// Đọc yêu cầu từ client
-	var req struct {
-		CardName  string `json:"card_name"`  // Thẻ mới muốn thay vào
-		SlotIndex int    `json:"slot_index"` // Vị trí trong deck (1–8)
+	var revoked int64 = 1
+	var err error
+	if all {
+		revoked, err = service.LogoutAll(c.User.ID)
+	} else {
+		err = service.Logout(c.User.ID, c.AuthSession)
 	}
-	if err := json.Unmarshal(incoming.Data, &req); err != nil {
-		utils.SendError(c.Send, incoming.ID, "invalid_payload", "Invalid swap card format")
+	if err != nil {
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
 
-	if req.SlotIndex < 1 || req.SlotIndex > 8 {
-		utils.SendError(c.Send, incoming.ID, "invalid_slot", "Invalid slot index (must be from 1 to 8)")
-		return
-	}
+	session.UnbindUser(c)
+	c.ResetUser()
+	utils.SendMessage(c, incoming.ID, "logged_out", map[string]any{
+		"all":      all,
+		"sessions": revoked,
+	})
+}
 
-	// Lấy dữ liệu deck hiện tại
-	userDeck, err := GetUserDeckByID(c.User.ID)
+func HandleChangeUsername(c *types.Client, incoming utils.IncomingMessage, req ChangeCredentialsRequest) {
+	profile, err := service.ChangeUsername(c.User.ID, req.Password, req.Username)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			utils.SendError(c.Send, incoming.ID, "not_found", "No user deck found")
-		} else {
-			utils.SendError(c.Send, incoming.ID, "mongo_error", "Failed to retrieve user deck")
-		}
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
+	utils.SendMessage(c, incoming.ID, "username_changed", profile)
+}
 
-	// Lấy danh sách thẻ người dùng sở hữu
-	userCards, err := GetUserCardsByID(c.User.ID)
+func HandleChangeEmail(c *types.Client, incoming utils.IncomingMessage, req ChangeCredentialsRequest) {
+	profile, err := service.ChangeEmail(c.User.ID, req.Password, req.Gmail)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			utils.SendError(c.Send, incoming.ID, "not_found", "No user cards found")
-		} else {
-			utils.SendError(c.Send, incoming.ID, "mongo_error", "Failed to retrieve user cards")
-		}
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
+	utils.SendMessage(c, incoming.ID, "email_changed", profile)
+}
 
-	// Kiểm tra người dùng có sở hữu thẻ mới không
-	var foundCard *CardInfo
-	for _, card := range userCards.Cards {
-		if card.Name == req.CardName {
-			foundCard = &card
-			break
-		}
+// HandleChangePassword đổi mật khẩu, giữ phiên của socket này và thu hồi mọi phiên khác
+func HandleChangePassword(c *types.Client, incoming utils.IncomingMessage, req ChangePasswordRequest) {
+	if err := service.ChangePassword(c.User.ID, req.OldPassword, req.NewPassword, c.AuthSession); err != nil {
+		sendServiceError(c, incoming.ID, err)
+		return
 	}
-	if foundCard == nil {
-		utils.SendError(c.Send, incoming.ID, "card_not_found", "Card not found in user cards")
+	utils.SendMessage(c, incoming.ID, "password_changed", map[string]bool{"changed": true})
+}
+
+// HandleDeleteAccount xóa tài khoản rồi đưa socket về trạng thái chưa đăng nhập.
+// Lỗi deletion_pending nghĩa là việc xóa đã bắt đầu và server sẽ tự hoàn tất.
+func HandleDeleteAccount(c *types.Client, incoming utils.IncomingMessage, req ChangeCredentialsRequest) {
+	if rejectInGame(c, incoming.ID, "delete the account") {
 		return
 	}
 
-	// Kiểm tra thẻ đã tồn tại trong deck hay chưa (ngoại trừ vị trí đang muốn thay)
-	for i, card := range userDeck.Cards {
-		if i != req.SlotIndex-1 && card.Name == req.CardName {
-			utils.SendError(c.Send, incoming.ID, "duplicate_card", "Card already exists in deck")
+	if err := service.DeleteAccount(c.User.ID, req.Password); err != nil {
+		if e := service.AsError(err); e.Code != "deletion_pending" {
+			sendServiceError(c, incoming.ID, err)
 			return
 		}
 	}
 
-	// (Ghi log nếu thẻ cũ trong slot không nằm trong userCards — để phòng trường hợp lỗi dữ liệu)
-	oldCard := userDeck.Cards[req.SlotIndex-1]
+	session.UnbindUser(c)
+	c.ResetUser()
+	utils.SendMessage(c, incoming.ID, "account_deleted", map[string]bool{"deleted": true})
+}
+
+// sendServiceError gửi lỗi từ package service theo mã lỗi của nó
+func sendServiceError(c *types.Client, id string, err error) {
+	e := service.AsError(err)
+	utils.SendError(c, id, e.Code, e.Message)
+}
 
-	// Kiểm tra thẻ mới có giống thẻ cũ ở vị trí đó không
-	if oldCard.Name == req.CardName {
-		utils.SendError(c.Send, incoming.ID, "no_change", "New card is the same as the current card in the slot")
+func HandleGetProfile(c *types.Client, incoming utils.IncomingMessage) {
+	profile, err := service.GetProfile(c.User.ID)
+	if err != nil {
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
 
-	hasOldCard := false
-	for _, card := range userCards.Cards {
-		if card.Name == oldCard.Name {
-			hasOldCard = true
-			break
-		}
-	}
-	if !hasOldCard {
-		log.Printf("[SECURITY WARNING] User %d tried to replace card '%s' from deck they may not own", c.User.ID, oldCard.Name)
+	utils.SendMessage(c, incoming.ID, "profile", profile)
+}
+
+func HandleGetUserCards(c *types.Client, incoming utils.IncomingMessage) {
+	userCards, err := service.GetUserCards(c.User.ID)
+	if err != nil {
+		sendServiceError(c, incoming.ID, err)
+		return
 	}
 
-	// Cập nhật vị trí trong deck với thẻ mới
-	userDeck.Cards[req.SlotIndex-1] = DeckCard{
-		Index: req.SlotIndex,
-		Name:  foundCard.Name,
-		Level: foundCard.Level,
+	utils.SendMessage(c, incoming.ID, "user_cards", userCards)
+}
+
+func HandleGetUserDeck(c *types.Client, incoming utils.IncomingMessage) {
+	userDeck, err := service.GetUserDeck(c.User.ID)
+	if err != nil {
+		sendServiceError(c, incoming.ID, err)
+		return
 	}
 
-	// Ghi lại vào MongoDB
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	utils.SendMessage(c, incoming.ID, "user_deck", userDeck)
+}
 
-	deckCol := db.MongoDatabase.Collection("user_decks")
-	_, err = deckCol.ReplaceOne(ctx, bson.M{"user_id": c.User.ID}, userDeck)
+func HandleSwapCard(c *types.Client, incoming utils.IncomingMessage, req SwapCardRequest) {
+	userDeck, err := service.SwapCard(c.User.ID, req.CardName, req.SlotIndex)
 	if err != nil {
-		utils.SendError(c.Send, incoming.ID, "mongo_error", "Failed to update user deck")
+		sendServiceError(c, incoming.ID, err)
 		return
 	}
 
 	// Phản hồi thành công
-	utils.SendMessage(c.Send, incoming.ID, "swap_card_success", userDeck)
-
+	utils.SendMessage(c, incoming.ID, "swap_card_success", userDeck)
 }
 
-func HandleCreateLobby(c *types.Client, incoming utils.IncomingMessage) {
-	if c.User.ID == 0 {
-		utils.SendError(c.Send, incoming.ID, "unauthorized", "User not logged in")
-		return
+// rejectDraining báo lỗi và trả true khi server đang tắt, không cho vào phòng chờ mới
+func rejectDraining(c *types.Client, id string) bool {
+	if !session.Draining.Load() {
+		return false
 	}
+	utils.SendError(c, id, "maintenance", "Server is shutting down for maintenance")
+	return true
+}
 
-	var req LobbyRequest
-	if err := json.Unmarshal(incoming.Data, &req); err != nil {
-		utils.SendError(c.Send, incoming.ID, "invalid_data", "Invalid lobby create data")
+func HandleCreateLobby(c *types.Client, incoming utils.IncomingMessage, req LobbyRequest) {
+	if rejectDraining(c, incoming.ID) {
 		return
 	}
-
 	lobbyID := uuid.NewString()
 
 	room := utils.CreateLobbyRoom(lobbyID, req.RoomType, false)
+	room.SpectatorHands = req.SpectatorHands
 
 	joinedRoom, success, slotIndex := utils.JoinLobbyRoom(lobbyID, c)
 	if !success {
-		utils.SendError(c.Send, incoming.ID, "join_failed", "Could not join lobby")
+		utils.SendError(c, incoming.ID, "join_failed", "Could not join lobby")
 		return
 	}
 
-	utils.SendMessage(c.Send, incoming.ID, "lobby_created", map[string]any{
+	utils.SendMessage(c, incoming.ID, "lobby_created", map[string]any{
 		"lobby_id": room.ID,
 		"type":     string(joinedRoom.Type),
 		"slot":     slotIndex,
 	})
 }
 
-func HandleJoinLobby(c *types.Client, incoming utils.IncomingMessage) {
-	if c.User.ID == 0 {
-		utils.SendError(c.Send, incoming.ID, "unauthorized", "User not logged in")
+func HandleJoinLobby(c *types.Client, incoming utils.IncomingMessage, req LobbyRequest) {
+	if rejectDraining(c, incoming.ID) {
 		return
 	}
-
-	var req LobbyRequest
-	if err := json.Unmarshal(incoming.Data, &req); err != nil || req.LobbyID == "" {
-		utils.SendError(c.Send, incoming.ID, "invalid_data", "Missing or invalid lobby ID")
+	if req.LobbyID == "" {
+		utils.SendError(c, incoming.ID, "invalid_data", "Missing or invalid lobby ID")
 		return
 	}
 
 	joinedRoom, success, slotIndex := utils.JoinLobbyRoom(req.LobbyID, c)
 	if !success {
-		utils.SendError(c.Send, incoming.ID, "join_failed", "Could not join lobby")
+		utils.SendError(c, incoming.ID, "join_failed", "Could not join lobby")
 		return
 	}
 
-	utils.SendMessage(c.Send, incoming.ID, "lobby_joined", map[string]any{
+	utils.SendMessage(c, incoming.ID, "lobby_joined", map[string]any{
 		"lobby_id": req.LobbyID,
 		"type":     string(joinedRoom.Type),
 		"slot":     slotIndex,
 	})
 }
 
-func HandleMatchLobby(c *types.Client, incoming utils.IncomingMessage) {
-	if c.User.ID == 0 {
-		utils.SendError(c.Send, incoming.ID, "unauthorized", "User not logged in")
+func HandleMatchLobby(c *types.Client, incoming utils.IncomingMessage, req LobbyRequest) {
+	if rejectDraining(c, incoming.ID) {
 		return
 	}
-
-	var req LobbyRequest
-	if err := json.Unmarshal(incoming.Data, &req); err != nil {
-		utils.SendError(c.Send, incoming.ID, "invalid_data", "Invalid match request")
-		return
-	}
-
-	room := utils.FindAvailableLobby(req.RoomType)
+	room := utils.FindAvailableLobby(req.RoomType, c.RTT())
 	if room == nil {
 		roomID := uuid.NewString()
 		// Tạo phòng mới với match = true
@@ -572,86 +549,105 @@ func HandleMatchLobby(c *types.Client, incoming utils.IncomingMessage) {
 
 	joinedRoom, success, slotIndex := utils.JoinLobbyRoom(room.ID, c)
 	if !success {
-		utils.SendError(c.Send, incoming.ID, "join_failed", "Could not join lobby")
+		utils.SendError(c, incoming.ID, "join_failed", "Could not join lobby")
 		return
 	}
 
-	utils.SendMessage(c.Send, incoming.ID, "matched_lobby", map[string]any{
+	utils.SendMessage(c, incoming.ID, "matched_lobby", map[string]any{
 		"lobby_id": room.ID,
 		"type":     string(joinedRoom.Type),
 		"slot":     slotIndex,
 	})
 }
 
-func HandleLeaveLobby(c *types.Client, incoming utils.IncomingMessage) {
-	if c.User.ID == 0 {
-		utils.SendError(c.Send, incoming.ID, "unauthorized", "User not logged in")
-		return
-	}
-
-	var req LobbyRequest
-	if err := json.Unmarshal(incoming.Data, &req); err != nil || req.LobbyID == "" {
-		utils.SendError(c.Send, incoming.ID, "invalid_data", "Missing or invalid lobby ID")
+func HandleLeaveLobby(c *types.Client, incoming utils.IncomingMessage, req LobbyRequest) {
+	if req.LobbyID == "" {
+		utils.SendError(c, incoming.ID, "invalid_data", "Missing or invalid lobby ID")
 		return
 	}
 
 	if !utils.LeaveLobbyRoom(req.LobbyID, c) {
-		utils.SendError(c.Send, incoming.ID, "leave_failed", "Could not leave lobby")
+		utils.SendError(c, incoming.ID, "leave_failed", "Could not leave lobby")
 		return
 	}
 
-	c.RoomType = ""
-	c.SlotIndex = -1
+	c.SetRoom("", -1)
 
-	utils.SendMessage(c.Send, incoming.ID, "lobby_left", map[string]string{
+	utils.SendMessage(c, incoming.ID, "lobby_left", map[string]string{
 		"lobby_id": req.LobbyID,
 	})
 }
 
-func HandleReleaseCard(c *types.Client, incoming utils.IncomingMessage) {
-	if c.User.ID == 0 {
-		utils.SendError(c.Send, incoming.ID, "unauthorized", "User not logged in")
-		return
-	}
+func HandleReleaseCard(c *types.Client, incoming utils.IncomingMessage, req ReleaseCardRequest) {
+	// Gói dữ liệu gửi đi
+	action := map[string]interface{}{
+		"type": "release",
+		"data": map[string]interface{}{
+			"msg_id":  incoming.ID,
+			"user_id": c.User.ID,
+			"cardID":  req.CardID,
+			"x":       req.X,
+			"y":       req.Y,
 
-	var req struct {
-		CardID int `json:"card_id"`
-		X      int `json:"x"`
-		Y      int `json:"y"`
+			"client_tick": req.ClientTick,
+		},
 	}
 
-	if err := json.Unmarshal(incoming.Data, &req); err != nil {
-		utils.SendError(c.Send, incoming.ID, "invalid_payload", "Invalid release card format")
+	data, err := json.Marshal(action)
+	if err != nil {
+		utils.SendError(c, incoming.ID, "internal_error", "Failed to encode action")
 		return
 	}
 
-	if req.CardID < 0 || req.CardID > 7 {
-		utils.SendError(c.Send, incoming.ID, "missing_fields", "Card name missing")
+	select {
+	case c.User.MatchRoom <- data:
 		return
+	default:
+		utils.SendError(c, incoming.ID, "match_room_busy", "Unable to send to match room")
 	}
+}
 
-	// Gói dữ liệu gửi đi
+func HandleSnapshotAck(c *types.Client, incoming utils.IncomingMessage, req SnapshotAckRequest) {
 	action := map[string]interface{}{
-		"type": "release",
+		"type": "snapshot_ack",
 		"data": map[string]interface{}{
-			"msg_id":  incoming.ID,
 			"user_id": c.User.ID,
-			"cardID":  req.CardID,
-			"x":       req.X,
-			"y":       req.Y,
+			"tick":    req.Tick,
 		},
 	}
 
 	data, err := json.Marshal(action)
 	if err != nil {
-		utils.SendError(c.Send, incoming.ID, "internal_error", "Failed to encode action")
+		utils.SendError(c, incoming.ID, "internal_error", "Failed to encode action")
 		return
 	}
 
+	// Ack bị bỏ qua khi phòng bận: tick sau client sẽ ack lại
 	select {
 	case c.User.MatchRoom <- data:
+	default:
+	}
+}
+
+func HandleSpectateMatch(c *types.Client, incoming utils.IncomingMessage, req SpectateRequest) {
+	sendSpectateRequest(c, incoming, req, false)
+}
+
+func HandleLeaveSpectate(c *types.Client, incoming utils.IncomingMessage, req SpectateRequest) {
+	sendSpectateRequest(c, incoming, req, true)
+}
+
+// sendSpectateRequest chuyển yêu cầu vào/rời chế độ xem tới goroutine của trận
+func sendSpectateRequest(c *types.Client, incoming utils.IncomingMessage, req SpectateRequest, leave bool) {
+	match := session.FindMatch(req.MatchID)
+	if match == nil || match.Spectate == nil {
+		utils.SendError(c, incoming.ID, "match_not_found", "Match not found or already finished")
 		return
+	}
+
+	select {
+	case match.Spectate <- session.SpectateRequest{Client: c, Leave: leave}:
 	default:
-		utils.SendError(c.Send, incoming.ID, "match_room_busy", "Unable to send to match room")
+		utils.SendError(c, incoming.ID, "match_room_busy", "Unable to send to match room")
 	}
 }
@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileMailer ghi thư vào thư mục outbox thay vì gửi, dùng cho môi trường dev/test
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := validateAddress(msg.To); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	// Tên file sắp xếp theo thời gian, kèm người nhận để dễ tìm
+	to := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s-%s-%s.eml", time.Now().Format("20060102T150405"), to, uuid.NewString()[:8])
+	return os.WriteFile(filepath.Join(m.Dir, name), render(m.From, msg), 0o644)
+}
@@ -0,0 +1,59 @@
+// Package mail gửi email hệ thống (xác thực email, đặt lại mật khẩu) qua interface Mailer.
+//
+// MAIL_DRIVER chọn cách gửi:
+//   - "smtp": gửi thật qua SMTP_HOST/SMTP_PORT (STARTTLS nếu server hỗ trợ)
+//   - "file": ghi mỗi thư thành một file .eml trong MAIL_OUTBOX_DIR, dùng khi chạy local
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"server/internal/config"
+)
+
+// Message là một email dạng văn bản thuần
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer gửi email; triển khai phải an toàn khi gọi từ nhiều goroutine
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Default là mailer dùng chung, gán bởi Init
+var Default Mailer = &FileMailer{Dir: "outbox", From: "noreply@localhost"}
+
+// Init tạo mailer theo MAIL_DRIVER
+func Init() error {
+	cfg := config.Config
+	switch cfg.MailDriver {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return fmt.Errorf("MAIL_DRIVER=smtp requires SMTP_HOST")
+		}
+		Default = &SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUser,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}
+		log.Printf("Sending mail via SMTP %s:%d", cfg.SMTPHost, cfg.SMTPPort)
+	case "file", "":
+		Default = &FileMailer{Dir: cfg.MailOutboxDir, From: cfg.MailFrom}
+		log.Printf("Writing mail to outbox %s", cfg.MailOutboxDir)
+	default:
+		return fmt.Errorf("unknown MAIL_DRIVER %q", cfg.MailDriver)
+	}
+	return nil
+}
+
+// Send gửi qua mailer mặc định
+func Send(ctx context.Context, msg Message) error {
+	return Default.Send(ctx, msg)
+}
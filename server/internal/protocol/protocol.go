@@ -0,0 +1,51 @@
+// Package protocol mô tả phiên bản giao thức và các tính năng server hỗ trợ.
+//
+// Ngay sau khi kết nối, client phải gửi "hello":
+//
+//	{"type": "hello", "data": {"protocol_version": 1, "platform": "web", "build": "1.4.2", "features": ["snapshot_delta", ...]}}
+//
+// Server trả "hello_ok" với session id, phiên bản server và danh sách tính năng
+// được bật (giao của features client gửi và Supported), hoặc "upgrade_required"
+// nếu protocol_version thấp hơn MinProtocolVersion trong config.
+//
+// Chỉ những tính năng làm thay đổi thứ server gửi mới được thương lượng. Resume,
+// spectate, PvE, time_sync và input_ack/input_reject luôn có ở giao thức 1 và
+// không cần khai báo trong features.
+package protocol
+
+// Version là phiên bản giao thức hiện tại của server
+const Version = 1
+
+// ServerVersion là phiên bản bản build server, gửi cho client trong hello_ok
+const ServerVersion = "1.0.0"
+
+// Các tính năng client có thể yêu cầu
+const (
+	FeatureSnapshotDelta = "snapshot_delta" // đồng bộ snapshot + delta, ack bằng snapshot_ack
+	FeatureCombatEvents  = "combat_events"  // message "events" mỗi tick
+	FeatureMsgpack       = "msgpack"        // subprotocol MessagePack
+	FeatureBatch         = "batch"          // nhiều message của một tick gói trong một frame "batch"
+)
+
+// Supported là các tính năng server hỗ trợ, theo thứ tự trả về trong hello_ok
+var Supported = []string{
+	FeatureSnapshotDelta,
+	FeatureCombatEvents,
+	FeatureMsgpack,
+	FeatureBatch,
+}
+
+// Negotiate trả về các tính năng cả client và server cùng hỗ trợ
+func Negotiate(requested []string) []string {
+	want := make(map[string]bool, len(requested))
+	for _, f := range requested {
+		want[f] = true
+	}
+	features := make([]string, 0, len(Supported))
+	for _, f := range Supported {
+		if want[f] {
+			features = append(features, f)
+		}
+	}
+	return features
+}
@@ -0,0 +1,17 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNegotiateKeepsServerOrder(t *testing.T) {
+	got := Negotiate([]string{FeatureBatch, "resume", FeatureSnapshotDelta, "unknown"})
+	want := []string{FeatureSnapshotDelta, FeatureBatch}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Negotiate = %v, want %v", got, want)
+	}
+	if got := Negotiate(nil); len(got) != 0 {
+		t.Fatalf("Negotiate(nil) = %v, want empty", got)
+	}
+}
@@ -0,0 +1,204 @@
+package router
+
+import (
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"server/internal/types"
+	"server/internal/utils"
+)
+
+// Recover bắt panic trong handler để kết nối không bị ngắt vì một message lỗi
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			defer func() {
+				if rec := recover(); rec != nil {
+					log.Printf("Panic handling %q from user %d: %v\n%s", ctx.Incoming.Type, ctx.Client.User.ID, rec, debug.Stack())
+					utils.SendError(ctx.Client, ctx.Incoming.ID, "internal_error", "Internal server error")
+				}
+			}()
+			next(ctx)
+		}
+	}
+}
+
+// Logging ghi log các handler chạy lâu hơn slow
+func Logging(slow time.Duration) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			start := time.Now()
+			next(ctx)
+			if elapsed := time.Since(start); elapsed >= slow {
+				log.Printf("Slow handler %q for user %d: %s", ctx.Incoming.Type, ctx.Client.User.ID, elapsed)
+			}
+		}
+	}
+}
+
+// RequireHello chặn message trước khi client hoàn tất hello, trừ route AllowBeforeHello
+func RequireHello() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			if !ctx.Route.PreHello && !ctx.Client.Greeted() {
+				utils.SendError(ctx.Client, ctx.Incoming.ID, "hello_required", "Send hello before any other message")
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// Auth chặn route không Public khi client chưa đăng nhập
+func Auth() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			if !ctx.Route.Public && ctx.Client.User.ID == 0 {
+				utils.SendError(ctx.Client, ctx.Incoming.ID, "unauthorized", "User not logged in")
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// Limit là số message tối đa N trong khoảng Per
+type Limit struct {
+	N   int
+	Per time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter giới hạn tần suất theo từng client và message type (token bucket)
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[*types.Client]map[string]*bucket
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[*types.Client]map[string]*bucket)}
+}
+
+// Middleware trả về middleware áp dụng Route.RateLimit
+func (l *RateLimiter) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			if limit := ctx.Route.RateLimit; limit != nil && !l.allow(ctx.Client, ctx.Route.Type, *limit) {
+				utils.SendError(ctx.Client, ctx.Incoming.ID, "rate_limited", "Too many "+ctx.Route.Type+" messages, slow down")
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+func (l *RateLimiter) allow(c *types.Client, typ string, limit Limit) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	perClient, ok := l.buckets[c]
+	if !ok {
+		perClient = make(map[string]*bucket)
+		l.buckets[c] = perClient
+	}
+	now := time.Now()
+	b, ok := perClient[typ]
+	if !ok {
+		b = &bucket{tokens: float64(limit.N), last: now}
+		perClient[typ] = b
+	}
+
+	// Nạp lại token theo thời gian đã trôi qua
+	rate := float64(limit.N) / limit.Per.Seconds()
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(limit.N) {
+		b.tokens = float64(limit.N)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Forget xóa trạng thái của client, gọi khi client ngắt kết nối
+func (l *RateLimiter) Forget(c *types.Client) {
+	l.mu.Lock()
+	delete(l.buckets, c)
+	l.mu.Unlock()
+}
+
+// TypeStats là thống kê của một message type
+type TypeStats struct {
+	Type   string        `json:"type"`
+	Count  int64         `json:"count"`
+	Panics int64         `json:"panics"`
+	Total  time.Duration `json:"total_ns"`
+	Max    time.Duration `json:"max_ns"`
+}
+
+// Metrics đếm số message, thời gian xử lý và panic theo message type
+type Metrics struct {
+	mu    sync.Mutex
+	stats map[string]*TypeStats
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{stats: make(map[string]*TypeStats)}
+}
+
+// Middleware trả về middleware ghi thống kê. Use sau Recover (Recover bọc ngoài cùng)
+// để panic đi qua Metrics và được đếm trước khi Recover bắt.
+func (m *Metrics) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			start := time.Now()
+			panicked := true
+			// Không recover ở đây: panic tiếp tục lan ra Recover phía ngoài
+			defer func() {
+				m.record(ctx.Route.Type, time.Since(start), panicked)
+			}()
+			next(ctx)
+			panicked = false
+		}
+	}
+}
+
+func (m *Metrics) record(typ string, elapsed time.Duration, panicked bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.stats[typ]
+	if !ok {
+		s = &TypeStats{Type: typ}
+		m.stats[typ] = s
+	}
+	s.Count++
+	s.Total += elapsed
+	if elapsed > s.Max {
+		s.Max = elapsed
+	}
+	if panicked {
+		s.Panics++
+	}
+}
+
+// Snapshot trả về bản sao thống kê, sắp theo type
+func (m *Metrics) Snapshot() []TypeStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]TypeStats, 0, len(m.stats))
+	for _, s := range m.stats {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Type < out[j].Type })
+	return out
+}
@@ -0,0 +1,188 @@
+// Package router chuyển message WebSocket tới handler theo trường "type".
+//
+// Handler đăng ký theo type kèm các tuỳ chọn (cho phép khi chưa đăng nhập, giới hạn
+// tần suất, cho phép trước hello). Mặc định mọi route đều cần đăng nhập. Middleware
+// bọc quanh mọi handler theo thứ tự Use, middleware đăng ký trước nằm ngoài cùng.
+// Package khác có thể đăng ký thêm message type qua Default hoặc router của riêng mình:
+//
+//	router.Handle(router.Default, "ping_room", func(c *types.Client, in utils.IncomingMessage, req PingRequest) {
+//		...
+//	}, router.RateLimit(5, time.Second))
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"server/internal/types"
+	"server/internal/utils"
+)
+
+// Context là message đang được xử lý cùng route tương ứng
+type Context struct {
+	Client   *types.Client
+	Incoming utils.IncomingMessage
+	Route    *Route
+}
+
+// HandlerFunc xử lý một message đã được định tuyến
+type HandlerFunc func(ctx *Context)
+
+// Middleware bọc một HandlerFunc và trả về HandlerFunc mới
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Route là cấu hình của một message type
+type Route struct {
+	Type      string
+	Public    bool // gọi được khi chưa đăng nhập
+	PreHello  bool // được phép gửi trước hello
+	RateLimit *Limit
+	handler   HandlerFunc
+}
+
+// Option thay đổi cấu hình route khi đăng ký
+type Option func(*Route)
+
+// Public cho phép gọi route khi chưa đăng nhập (login, register, ...)
+func Public() Option {
+	return func(r *Route) { r.Public = true }
+}
+
+// AllowBeforeHello cho phép gửi message trước khi hoàn tất hello
+func AllowBeforeHello() Option {
+	return func(r *Route) { r.PreHello = true }
+}
+
+// RateLimit giới hạn mỗi client gửi tối đa n message loại này trong khoảng per
+func RateLimit(n int, per time.Duration) Option {
+	return func(r *Route) { r.RateLimit = &Limit{N: n, Per: per} }
+}
+
+// Error là lỗi có mã trả về cho client, dùng trong Validate
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Code + ": " + e.Message }
+
+// Errorf tạo Error với mã code
+func Errorf(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Validator được gọi sau khi giải mã payload, trả lỗi nếu dữ liệu không hợp lệ
+type Validator interface {
+	Validate() error
+}
+
+type Router struct {
+	mu         sync.RWMutex
+	routes     map[string]*Route
+	middleware []Middleware
+	chain      map[string]HandlerFunc // handler đã bọc middleware, tạo lại khi đăng ký
+}
+
+// Default là router dùng cho kết nối WebSocket của game
+var Default = New()
+
+func New() *Router {
+	return &Router{
+		routes: make(map[string]*Route),
+		chain:  make(map[string]HandlerFunc),
+	}
+}
+
+// Use thêm middleware, áp dụng cho mọi route kể cả route đã đăng ký
+func (r *Router) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware, mw...)
+	for typ, route := range r.routes {
+		r.chain[typ] = r.wrap(route.handler)
+	}
+}
+
+// HandleFunc đăng ký handler không cần payload (hoặc tự giải mã)
+func (r *Router) HandleFunc(typ string, h func(c *types.Client, incoming utils.IncomingMessage), opts ...Option) {
+	r.register(typ, func(ctx *Context) { h(ctx.Client, ctx.Incoming) }, opts)
+}
+
+// Handle đăng ký handler với payload kiểu T. Payload được giải mã và kiểm tra
+// (nếu T cài Validator) trước khi gọi h; lỗi được trả cho client, h không chạy.
+func Handle[T any](r *Router, typ string, h func(c *types.Client, incoming utils.IncomingMessage, req T), opts ...Option) {
+	r.register(typ, func(ctx *Context) {
+		var req T
+		if len(ctx.Incoming.Data) > 0 {
+			if err := json.Unmarshal(ctx.Incoming.Data, &req); err != nil {
+				utils.SendError(ctx.Client, ctx.Incoming.ID, "invalid_payload", "Invalid "+typ+" format")
+				return
+			}
+		}
+		if v, ok := any(&req).(Validator); ok {
+			if err := v.Validate(); err != nil {
+				sendValidationError(ctx, err)
+				return
+			}
+		}
+		h(ctx.Client, ctx.Incoming, req)
+	}, opts)
+}
+
+func sendValidationError(ctx *Context, err error) {
+	if e, ok := err.(*Error); ok {
+		utils.SendError(ctx.Client, ctx.Incoming.ID, e.Code, e.Message)
+		return
+	}
+	utils.SendError(ctx.Client, ctx.Incoming.ID, "invalid_data", err.Error())
+}
+
+func (r *Router) register(typ string, h HandlerFunc, opts []Option) {
+	route := &Route{Type: typ, handler: h}
+	for _, opt := range opts {
+		opt(route)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.routes[typ]; exists {
+		panic("router: duplicate handler for message type " + typ)
+	}
+	r.routes[typ] = route
+	r.chain[typ] = r.wrap(h)
+}
+
+// wrap bọc handler theo thứ tự middleware, gọi khi đang giữ r.mu
+func (r *Router) wrap(h HandlerFunc) HandlerFunc {
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	return h
+}
+
+// Dispatch chuyển message tới handler của type tương ứng
+func (r *Router) Dispatch(c *types.Client, incoming utils.IncomingMessage) {
+	r.mu.RLock()
+	route, ok := r.routes[incoming.Type]
+	h := r.chain[incoming.Type]
+	r.mu.RUnlock()
+
+	if !ok {
+		utils.SendError(c, incoming.ID, "unknown_type", "Unknown message type")
+		return
+	}
+	h(&Context{Client: c, Incoming: incoming, Route: route})
+}
+
+// Routes trả về danh sách message type đã đăng ký
+func (r *Router) Routes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]string, 0, len(r.routes))
+	for typ := range r.routes {
+		types = append(types, typ)
+	}
+	return types
+}
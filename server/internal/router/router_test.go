@@ -0,0 +1,211 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"server/internal/types"
+	"server/internal/utils"
+)
+
+func newClient() *types.Client {
+	return &types.Client{Out: types.NewOutQueue(64, 0)}
+}
+
+// greeted trả về client đã hello và đăng nhập với user id
+func greeted(userID int) *types.Client {
+	c := newClient()
+	c.SetHello("sess", types.ClientInfo{})
+	c.SetUserID(userID)
+	return c
+}
+
+// errorCodes trả về mã lỗi của các message "error" client đã nhận
+func errorCodes(t *testing.T, c *types.Client) []string {
+	t.Helper()
+	var codes []string
+	for {
+		if critical, updates := c.Out.Len(); critical+updates == 0 {
+			return codes
+		}
+		msgs, _ := c.Out.Next()
+		for _, m := range msgs {
+			var msg struct {
+				Type string            `json:"type"`
+				Data map[string]string `json:"data"`
+			}
+			if err := json.Unmarshal(m, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type == "error" {
+				codes = append(codes, msg.Data["error"])
+			}
+		}
+	}
+}
+
+func record(name string, calls *[]string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			*calls = append(*calls, name+">")
+			next(ctx)
+			*calls = append(*calls, "<"+name)
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	r := New()
+	var calls []string
+	r.Use(record("a", &calls))
+	r.HandleFunc("ping", func(*types.Client, utils.IncomingMessage) { calls = append(calls, "handler") })
+	// Use sau khi đăng ký vẫn áp dụng cho route đã có, và nằm trong middleware dùng trước
+	r.Use(record("b", &calls))
+
+	r.Dispatch(greeted(1), utils.IncomingMessage{Type: "ping"})
+	want := []string{"a>", "b>", "handler", "<b", "<a"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestRequireHelloAndAuth(t *testing.T) {
+	r := New()
+	r.Use(RequireHello(), Auth())
+	ran := map[string]int{}
+	handler := func(typ string) func(*types.Client, utils.IncomingMessage) {
+		return func(*types.Client, utils.IncomingMessage) { ran[typ]++ }
+	}
+	r.HandleFunc("hello", handler("hello"), Public(), AllowBeforeHello())
+	r.HandleFunc("login", handler("login"), Public())
+	r.HandleFunc("create_lobby", handler("create_lobby"))
+
+	tests := []struct {
+		name    string
+		client  *types.Client
+		typ     string
+		wantErr string
+	}{
+		{"hello before hello", newClient(), "hello", ""},
+		{"public before hello", newClient(), "login", "hello_required"},
+		{"private before hello", newClient(), "create_lobby", "hello_required"},
+		{"public after hello", greeted(0), "login", ""},
+		{"private without login", greeted(0), "create_lobby", "unauthorized"},
+		{"private after login", greeted(1), "create_lobby", ""},
+		{"unknown type", greeted(1), "nope", "unknown_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := ran[tt.typ]
+			r.Dispatch(tt.client, utils.IncomingMessage{ID: "1", Type: tt.typ})
+			codes := errorCodes(t, tt.client)
+			if tt.wantErr == "" {
+				if len(codes) != 0 || ran[tt.typ] != before+1 {
+					t.Fatalf("errors %v, handler ran %d times; want handler to run", codes, ran[tt.typ]-before)
+				}
+				return
+			}
+			if len(codes) != 1 || codes[0] != tt.wantErr || ran[tt.typ] != before {
+				t.Fatalf("errors %v, handler ran %d times; want %s only", codes, ran[tt.typ]-before, tt.wantErr)
+			}
+		})
+	}
+}
+
+type joinRequest struct {
+	RoomType string `json:"room_type"`
+}
+
+func (r *joinRequest) Validate() error {
+	switch r.RoomType {
+	case "":
+		return Errorf("missing_fields", "room_type required")
+	case "bad":
+		return errors.New("plain error")
+	}
+	return nil
+}
+
+func TestHandleDecodesAndValidates(t *testing.T) {
+	r := New()
+	var got []string
+	Handle(r, "join", func(c *types.Client, in utils.IncomingMessage, req joinRequest) {
+		got = append(got, req.RoomType)
+	})
+
+	tests := []struct {
+		data    string
+		wantErr string
+	}{
+		{`{"room_type":"1v1"}`, ""},
+		{`{"room_type":`, "invalid_payload"},
+		{`{}`, "missing_fields"},
+		{`{"room_type":"bad"}`, "invalid_data"},
+	}
+	for _, tt := range tests {
+		c := greeted(1)
+		r.Dispatch(c, utils.IncomingMessage{ID: "1", Type: "join", Data: json.RawMessage(tt.data)})
+		codes := errorCodes(t, c)
+		if (tt.wantErr == "" && len(codes) != 0) || (tt.wantErr != "" && (len(codes) != 1 || codes[0] != tt.wantErr)) {
+			t.Errorf("data %s: errors %v, want %q", tt.data, codes, tt.wantErr)
+		}
+	}
+	if !reflect.DeepEqual(got, []string{"1v1"}) {
+		t.Fatalf("handler got %v, want only the valid request", got)
+	}
+}
+
+func TestDuplicateRoutePanics(t *testing.T) {
+	r := New()
+	r.HandleFunc("ping", func(*types.Client, utils.IncomingMessage) {})
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a duplicate type did not panic")
+		}
+	}()
+	r.HandleFunc("ping", func(*types.Client, utils.IncomingMessage) {})
+}
+
+func TestRecoverCountsPanicInMetrics(t *testing.T) {
+	r := New()
+	m := NewMetrics()
+	r.Use(Recover(), m.Middleware())
+	r.HandleFunc("boom", func(*types.Client, utils.IncomingMessage) { panic("boom") })
+
+	c := greeted(1)
+	r.Dispatch(c, utils.IncomingMessage{ID: "1", Type: "boom"})
+	if codes := errorCodes(t, c); len(codes) != 1 || codes[0] != "internal_error" {
+		t.Fatalf("errors = %v, want internal_error", codes)
+	}
+	stats := m.Snapshot()
+	if len(stats) != 1 || stats[0].Count != 1 || stats[0].Panics != 1 {
+		t.Fatalf("stats = %+v, want one panicked call", stats)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	r := New()
+	limiter := NewRateLimiter()
+	r.Use(limiter.Middleware())
+	ran := 0
+	r.HandleFunc("chat", func(*types.Client, utils.IncomingMessage) { ran++ }, RateLimit(2, time.Hour))
+
+	a, b := greeted(1), greeted(2)
+	for i := 0; i < 3; i++ {
+		r.Dispatch(a, utils.IncomingMessage{ID: "1", Type: "chat"})
+	}
+	if codes := errorCodes(t, a); ran != 2 || len(codes) != 1 || codes[0] != "rate_limited" {
+		t.Fatalf("ran %d, errors %v; want 2 runs and one rate_limited", ran, codes)
+	}
+
+	// Giới hạn tính riêng từng client; Forget xóa trạng thái của client
+	r.Dispatch(b, utils.IncomingMessage{ID: "1", Type: "chat"})
+	limiter.Forget(a)
+	r.Dispatch(a, utils.IncomingMessage{ID: "1", Type: "chat"})
+	if ran != 4 {
+		t.Fatalf("ran %d, want 4 after another client and Forget", ran)
+	}
+}
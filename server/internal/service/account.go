@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"server/internal/auth"
+	"server/internal/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Account là kết quả đăng nhập/đăng ký
+type Account struct {
+	UserID    int
+	Gmail     string
+	Username  string
+	Token     string // access token
+	SessionID string // phiên đăng nhập của token, dùng khi logout
+
+	// Chỉ có khi vừa cấp token (đăng nhập, đăng ký, refresh)
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// ExpiresIn là số giây còn lại của access token
+func (a *Account) ExpiresIn() int {
+	if a.ExpiresAt.IsZero() {
+		return 0
+	}
+	return int(time.Until(a.ExpiresAt).Seconds())
+}
+
+type Profile struct {
+	ID            int    `json:"id"`
+	Gmail         string `json:"gmail"`
+	EmailVerified bool   `json:"email_verified"`
+	Username      string `json:"username"`
+	Level         int    `json:"level"`
+	Experience    int    `json:"experience"`
+	Gold          int    `json:"gold"`
+	Gems          int    `json:"gems"`
+}
+
+// Authenticate kiểm tra gmail/mật khẩu, chưa tạo token
+func Authenticate(gmail, password string) (*Account, error) {
+	if gmail == "" || password == "" {
+		return nil, newError(http.StatusBadRequest, "missing_fields", "Username or password missing")
+	}
+
+	var storedPassword, username string
+	var id int
+
+	query := `SELECT id, username, password FROM users WHERE gmail = ?`
+	err := db.DB.QueryRow(query, gmail).Scan(&id, &username, &storedPassword)
+	// Gmail không tồn tại, sai mật khẩu hay tài khoản đang bị xóa đều trả cùng một lỗi
+	// sau cùng một lượt argon2, để không dò được gmail nào đã đăng ký
+	invalid := newError(http.StatusUnauthorized, "invalid_credentials", "Invalid username or password")
+	if err == sql.ErrNoRows {
+		auth.VerifyDummyPassword(password)
+		return nil, invalid
+	} else if err != nil {
+		log.Printf("DB error: %v", err)
+		return nil, newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+
+	ok, needsRehash := auth.VerifyPassword(password, storedPassword)
+	if !ok || deletionPending(id) {
+		return nil, invalid
+	}
+
+	// Mật khẩu thô hoặc hash với tham số cũ: băm lại bằng tham số hiện tại.
+	// Lỗi ở đây không chặn đăng nhập, lần sau sẽ thử lại.
+	if needsRehash {
+		if hash, err := auth.HashPassword(password); err != nil {
+			log.Printf("Error rehashing password for user %d: %v", id, err)
+		} else if _, err := db.DB.Exec(`UPDATE users SET password = ? WHERE id = ?`, hash, id); err != nil {
+			log.Printf("Error storing rehashed password for user %d: %v", id, err)
+		}
+	}
+
+	return &Account{UserID: id, Gmail: gmail, Username: username}, nil
+}
+
+// IssueToken tạo token đăng nhập cho tài khoản
+func IssueToken(acc *Account) error {
+	tokens, err := auth.GenerateToken(acc.UserID, acc.Gmail, acc.Username)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "token_error", "Failed to generate token")
+	}
+	setTokens(acc, tokens)
+	return nil
+}
+
+func setTokens(acc *Account, tokens *auth.Tokens) {
+	acc.Token = tokens.AccessToken
+	acc.SessionID = tokens.SessionID
+	acc.RefreshToken = tokens.RefreshToken
+	acc.ExpiresAt = tokens.ExpiresAt
+}
+
+// tokenError đổi lỗi của package auth sang mã lỗi cho client
+func tokenError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrTokenExpired):
+		return newError(http.StatusUnauthorized, "token_expired", "Access token expired, refresh it")
+	case errors.Is(err, auth.ErrTokenRevoked):
+		return newError(http.StatusUnauthorized, "token_revoked", "Session has been logged out")
+	case errors.Is(err, auth.ErrRefreshReuse):
+		return newError(http.StatusUnauthorized, "token_reused", "Refresh token was already used, please log in again")
+	case errors.Is(err, auth.ErrTokenInvalid):
+		return newError(http.StatusUnauthorized, "invalid_token", "Invalid token")
+	}
+	log.Printf("Token error: %v", err)
+	return newError(http.StatusInternalServerError, "server_error", "Database error")
+}
+
+// Login kiểm tra mật khẩu và tạo token
+func Login(gmail, password string) (*Account, error) {
+	acc, err := Authenticate(gmail, password)
+	if err != nil {
+		return nil, err
+	}
+	if err := IssueToken(acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// ValidateToken kiểm tra token và trả thông tin tài khoản
+func ValidateToken(token string) (*Account, error) {
+	if token == "" {
+		return nil, newError(http.StatusUnauthorized, "missing_fields", "Token missing")
+	}
+	claims, err := auth.ValidateTokenWithMongo(token)
+	if err != nil {
+		return nil, tokenError(err)
+	}
+	return &Account{
+		UserID:    claims.ID,
+		Gmail:     claims.Gmail,
+		Username:  claims.Username,
+		Token:     token,
+		SessionID: claims.SessionID,
+	}, nil
+}
+
+// Refresh đổi refresh token lấy cặp token mới (refresh token cũ hết hiệu lực)
+func Refresh(refreshToken string) (*Account, error) {
+	if refreshToken == "" {
+		return nil, newError(http.StatusBadRequest, "missing_fields", "Refresh token missing")
+	}
+	tokens, err := auth.RefreshToken(refreshToken)
+	if err != nil {
+		return nil, tokenError(err)
+	}
+
+	acc := &Account{UserID: tokens.UserID, Gmail: tokens.Gmail, Username: tokens.Username}
+	setTokens(acc, tokens)
+	return acc, nil
+}
+
+// Logout thu hồi phiên đăng nhập hiện tại
+func Logout(userID int, sessionID string) error {
+	if sessionID == "" {
+		return nil
+	}
+	if err := auth.RevokeSession(userID, sessionID); err != nil {
+		log.Printf("Error revoking session for user %d: %v", userID, err)
+		return newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+	return nil
+}
+
+// LogoutAll thu hồi mọi phiên đăng nhập của user trên mọi thiết bị
+func LogoutAll(userID int) (int64, error) {
+	n, err := auth.RevokeAllSessions(userID)
+	if err != nil {
+		log.Printf("Error revoking sessions for user %d: %v", userID, err)
+		return 0, newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+	return n, nil
+}
+
+// Register tạo tài khoản mới cùng bộ bài và deck mặc định, trả về tài khoản đã có token
+func Register(gmail, username, password string) (*Account, error) {
+	if gmail == "" || username == "" || password == "" {
+		return nil, newError(http.StatusBadRequest, "missing_fields", "Missing fields")
+	}
+
+	// --- Bắt đầu transaction MySQL ---
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, newError(http.StatusInternalServerError, "server_error", "Failed to start SQL transaction")
+	}
+
+	// Đánh dấu để kiểm soát rollback thủ công
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Kiểm tra Gmail đã tồn tại chưa
+	var exists int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM users WHERE gmail = ?`, gmail).Scan(&exists)
+	if err != nil {
+		return nil, newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+	if exists > 0 {
+		return nil, newError(http.StatusConflict, "duplicate", "Gmail already registered")
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, newError(http.StatusInternalServerError, "server_error", "Failed to hash password")
+	}
+
+	// Thêm user mới
+	result, err := tx.Exec(`INSERT INTO users (gmail, username, password) VALUES (?, ?, ?)`, gmail, username, hash)
+	if err != nil {
+		return nil, newError(http.StatusInternalServerError, "server_error", "Failed to create user")
+	}
+
+	uid64, err := result.LastInsertId()
+	if err != nil {
+		return nil, newError(http.StatusInternalServerError, "server_error", "Failed to get user ID")
+	}
+	userID := int(uid64)
+
+	// Thêm user_stats
+	_, err = tx.Exec(`INSERT INTO user_stats (user_id, level, experience, gold, gems) VALUES (?, 1, 0, 500, 10)`, userID)
+	if err != nil {
+		return nil, newError(http.StatusInternalServerError, "server_error", "Failed to insert user stats")
+	}
+
+	// --- Thêm MongoDB trước khi commit MySQL ---
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userCards := bson.M{
+		"user_id":     userID,
+		"king_tower":  []bson.M{{"name": "King_Tower"}},
+		"guard_tower": []bson.M{{"name": "Guard_Tower", "level": 1, "count": 0}},
+		"cards": []bson.M{
+			{"name": "Pawn", "level": 1, "count": 0},
+			{"name": "Bishop", "level": 1, "count": 0},
+			{"name": "Rook", "level": 1, "count": 0},
+			{"name": "Knight", "level": 1, "count": 0},
+			{"name": "Prince", "level": 1, "count": 0},
+			{"name": "Queen", "level": 1, "count": 0},
+			{"name": "Fireball", "level": 1, "count": 0},
+			{"name": "Healing Light", "level": 1, "count": 0},
+		},
+	}
+
+	userDeck := bson.M{
+		"user_id":     userID,
+		"king_tower":  bson.M{"name": "King_Tower"},
+		"guard_tower": bson.M{"name": "Guard_Tower", "level": 1},
+		"cards": []bson.M{
+			{"index": 1, "name": "Pawn", "level": 1},
+			{"index": 2, "name": "Bishop", "level": 1},
+			{"index": 3, "name": "Rook", "level": 1},
+			{"index": 4, "name": "Knight", "level": 1},
+			{"index": 5, "name": "Prince", "level": 1},
+			{"index": 6, "name": "Queen", "level": 1},
+			{"index": 7, "name": "Fireball", "level": 1},
+			{"index": 8, "name": "Healing Light", "level": 1},
+		},
+	}
+
+	cardCol := db.MongoDatabase.Collection("user_cards")
+	deckCol := db.MongoDatabase.Collection("user_decks")
+
+	if _, err = cardCol.InsertOne(ctx, userCards); err != nil {
+		return nil, newError(http.StatusInternalServerError, "mongo_error", "Failed to insert user cards")
+	}
+
+	if _, err = deckCol.InsertOne(ctx, userDeck); err != nil {
+		// Nếu insert deck fail, xóa userCards để giữ đồng bộ
+		_, _ = cardCol.DeleteOne(ctx, bson.M{"user_id": userID})
+		return nil, newError(http.StatusInternalServerError, "mongo_error", "Failed to insert user deck")
+	}
+
+	// Nếu MongoDB thành công, commit MySQL transaction
+	if err := tx.Commit(); err != nil {
+		return nil, newError(http.StatusInternalServerError, "server_error", "Failed to commit SQL transaction")
+	}
+	committed = true
+
+	// Lỗi gửi mã xác thực không chặn đăng ký, user có thể yêu cầu gửi lại
+	if err := SendVerification(userID, gmail); err != nil {
+		log.Printf("Error sending verification email to user %d: %v", userID, err)
+	}
+
+	acc := &Account{UserID: userID, Gmail: gmail, Username: username}
+	if err := IssueToken(acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// GetProfile trả thông tin tài khoản và chỉ số của người chơi
+func GetProfile(userID int) (*Profile, error) {
+	var p Profile
+	err := db.DB.QueryRow(`
+		SELECT u.id, u.gmail, u.email_verified, u.username, s.level, s.experience, s.gold, s.gems
+		FROM users u JOIN user_stats s ON s.user_id = u.id
+		WHERE u.id = ?`, userID).
+		Scan(&p.ID, &p.Gmail, &p.EmailVerified, &p.Username, &p.Level, &p.Experience, &p.Gold, &p.Gems)
+	if err == sql.ErrNoRows {
+		return nil, newError(http.StatusNotFound, "not_found", "User not found")
+	} else if err != nil {
+		log.Printf("DB error: %v", err)
+		return nil, newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+	return &p, nil
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"server/internal/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Chỉ dùng cho King Tower (chỉ có name)
+type SimpleTowerInfo struct {
+	Name string `bson:"name" json:"name"`
+}
+
+// Dùng cho Guard Tower (có level và count)
+type TowerInfo struct {
+	Name  string `bson:"name" json:"name"`
+	Level int    `bson:"level" json:"level"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// Dùng cho user_cards (không có slot/index)
+type CardInfo struct {
+	Name  string `bson:"name" json:"name"`
+	Level int    `bson:"level" json:"level"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// Dùng cho user_decks (có slot/index)
+type DeckCard struct {
+	Index int    `bson:"index" json:"index"`
+	Name  string `bson:"name" json:"name"`
+	Level int    `bson:"level" json:"level"`
+}
+
+type UserCards struct {
+	UserID     int               `bson:"user_id" json:"user_id"`
+	KingTower  []SimpleTowerInfo `bson:"king_tower" json:"king_tower"`   // Mảng
+	GuardTower []TowerInfo       `bson:"guard_tower" json:"guard_tower"` // Mảng
+	Cards      []CardInfo        `bson:"cards" json:"cards"`
+}
+
+type UserDeck struct {
+	UserID     int             `bson:"user_id" json:"user_id"`
+	KingTower  SimpleTowerInfo `bson:"king_tower" json:"king_tower"`   // Object
+	GuardTower TowerInfo       `bson:"guard_tower" json:"guard_tower"` // Object
+	Cards      []DeckCard      `bson:"cards" json:"cards"`
+}
+
+func GetUserCardsByID(userID int) (*UserCards, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var result UserCards
+	err := db.MongoDatabase.Collection("user_cards").
+		FindOne(ctx, bson.M{"user_id": userID}).
+		Decode(&result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func GetUserDeckByID(userID int) (*UserDeck, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var result UserDeck
+	err := db.MongoDatabase.Collection("user_decks").
+		FindOne(ctx, bson.M{"user_id": userID}).
+		Decode(&result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetUserCards trả bộ sưu tập bài của người chơi
+func GetUserCards(userID int) (*UserCards, error) {
+	userCards, err := GetUserCardsByID(userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, newError(http.StatusNotFound, "not_found", "No user cards found")
+		}
+		return nil, newError(http.StatusInternalServerError, "mongo_error", "Failed to retrieve user cards")
+	}
+	return userCards, nil
+}
+
+// GetUserDeck trả deck hiện tại của người chơi
+func GetUserDeck(userID int) (*UserDeck, error) {
+	userDeck, err := GetUserDeckByID(userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, newError(http.StatusNotFound, "not_found", "No user deck found")
+		}
+		return nil, newError(http.StatusInternalServerError, "mongo_error", "Failed to retrieve user deck")
+	}
+	return userDeck, nil
+}
+
+// SwapCard thay lá bài ở vị trí slotIndex (1–8) trong deck bằng cardName, trả deck mới
+func SwapCard(userID int, cardName string, slotIndex int) (*UserDeck, error) {
+	if slotIndex < 1 || slotIndex > 8 {
+		return nil, newError(http.StatusBadRequest, "invalid_slot", "Invalid slot index (must be from 1 to 8)")
+	}
+
+	// Lấy dữ liệu deck hiện tại
+	userDeck, err := GetUserDeck(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lấy danh sách thẻ người dùng sở hữu
+	userCards, err := GetUserCards(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Kiểm tra người dùng có sở hữu thẻ mới không
+	var foundCard *CardInfo
+	for _, card := range userCards.Cards {
+		if card.Name == cardName {
+			foundCard = &card
+			break
+		}
+	}
+	if foundCard == nil {
+		return nil, newError(http.StatusNotFound, "card_not_found", "Card not found in user cards")
+	}
+
+	// Kiểm tra thẻ đã tồn tại trong deck hay chưa (ngoại trừ vị trí đang muốn thay)
+	for i, card := range userDeck.Cards {
+		if i != slotIndex-1 && card.Name == cardName {
+			return nil, newError(http.StatusConflict, "duplicate_card", "Card already exists in deck")
+		}
+	}
+
+	// (Ghi log nếu thẻ cũ trong slot không nằm trong userCards — để phòng trường hợp lỗi dữ liệu)
+	oldCard := userDeck.Cards[slotIndex-1]
+
+	// Kiểm tra thẻ mới có giống thẻ cũ ở vị trí đó không
+	if oldCard.Name == cardName {
+		return nil, newError(http.StatusConflict, "no_change", "New card is the same as the current card in the slot")
+	}
+
+	hasOldCard := false
+	for _, card := range userCards.Cards {
+		if card.Name == oldCard.Name {
+			hasOldCard = true
+			break
+		}
+	}
+	if !hasOldCard {
+		log.Printf("[SECURITY WARNING] User %d tried to replace card '%s' from deck they may not own", userID, oldCard.Name)
+	}
+
+	// Cập nhật vị trí trong deck với thẻ mới
+	userDeck.Cards[slotIndex-1] = DeckCard{
+		Index: slotIndex,
+		Name:  foundCard.Name,
+		Level: foundCard.Level,
+	}
+
+	// Ghi lại vào MongoDB
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	deckCol := db.MongoDatabase.Collection("user_decks")
+	_, err = deckCol.ReplaceOne(ctx, bson.M{"user_id": userID}, userDeck)
+	if err != nil {
+		return nil, newError(http.StatusInternalServerError, "mongo_error", "Failed to update user deck")
+	}
+
+	return userDeck, nil
+}
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"server/internal/auth"
+	"server/internal/config"
+	"server/internal/db"
+	"server/internal/mail"
+	"server/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mã xác thực email và mã đặt lại mật khẩu lưu trong email_tokens (chỉ lưu sha256),
+// dùng một lần và tự xóa khi hết hạn nhờ TTL index trên expires_at.
+
+const emailTokensCollection = "email_tokens"
+
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposeResetPassword = "reset_password"
+)
+
+type emailToken struct {
+	TokenHash string    `bson:"token_hash"`
+	UserID    int       `bson:"user_id"`
+	Purpose   string    `bson:"purpose"`
+	Email     string    `bson:"email"`
+	CreatedAt time.Time `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// emailTokenStore lưu mã email; consume phải tìm và xóa nguyên tử để mỗi mã chỉ
+// dùng được một lần. Test thay bằng bản lưu trong bộ nhớ.
+type emailTokenStore interface {
+	insert(ctx context.Context, t emailToken) error
+	// consume trả mongo.ErrNoDocuments khi không có mã hợp lệ
+	consume(ctx context.Context, tokenHash, purpose string, now time.Time) (*emailToken, error)
+}
+
+var emailTokens emailTokenStore = mongoEmailTokens{}
+
+type mongoEmailTokens struct{}
+
+func (mongoEmailTokens) insert(ctx context.Context, t emailToken) error {
+	_, err := db.MongoDatabase.Collection(emailTokensCollection).InsertOne(ctx, t)
+	return err
+}
+
+func (mongoEmailTokens) consume(ctx context.Context, tokenHash, purpose string, now time.Time) (*emailToken, error) {
+	var t emailToken
+	err := db.MongoDatabase.Collection(emailTokensCollection).FindOneAndDelete(ctx, bson.M{
+		"token_hash": tokenHash,
+		"purpose":    purpose,
+		"expires_at": bson.M{"$gt": now},
+	}).Decode(&t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// EnsureEmailTokenIndexes tạo index cho email_tokens, gồm TTL index trên expires_at
+func EnsureEmailTokenIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := db.MongoDatabase.Collection(emailTokensCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+	})
+	return err
+}
+
+// SendVerification tạo mã xác thực mới cho email của user và gửi thư (không chờ gửi xong)
+func SendVerification(userID int, gmail string) error {
+	ttl := time.Duration(config.Config.EmailVerifyTTL) * time.Hour
+	token, err := createEmailToken(userID, gmail, purposeVerifyEmail, ttl)
+	if err != nil {
+		return err
+	}
+
+	sendMailAsync(mail.Message{
+		To:      gmail,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome!\n\nUse this code to verify your email address:\n\n    %s\n\n%sThe code expires in %d hours.\n",
+			token, linkLine("verify-email", token), config.Config.EmailVerifyTTL),
+	})
+	return nil
+}
+
+// ResendVerification gửi lại mã xác thực cho user đang đăng nhập
+func ResendVerification(userID int) error {
+	var gmail string
+	var verified bool
+	err := db.DB.QueryRow(`SELECT gmail, email_verified FROM users WHERE id = ?`, userID).Scan(&gmail, &verified)
+	if err == sql.ErrNoRows {
+		return newError(http.StatusNotFound, "not_found", "User not found")
+	} else if err != nil {
+		log.Printf("DB error: %v", err)
+		return newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+	if verified {
+		return newError(http.StatusConflict, "already_verified", "Email is already verified")
+	}
+	return SendVerification(userID, gmail)
+}
+
+// VerifyEmail dùng mã xác thực và đánh dấu email đã xác thực, trả về user id
+func VerifyEmail(token string) (int, error) {
+	if token == "" {
+		return 0, newError(http.StatusBadRequest, "missing_fields", "Token missing")
+	}
+	t, err := consumeEmailToken(token, purposeVerifyEmail)
+	if err != nil {
+		return 0, err
+	}
+
+	// Mã chỉ hợp lệ cho đúng địa chỉ đã gửi, phòng khi user đã đổi email sau đó
+	res, err := db.DB.Exec(`UPDATE users SET email_verified = TRUE WHERE id = ? AND gmail = ?`, t.UserID, t.Email)
+	if err != nil {
+		log.Printf("DB error: %v", err)
+		return 0, newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists int
+		if err := db.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ? AND gmail = ?`, t.UserID, t.Email).Scan(&exists); err != nil || exists == 0 {
+			return 0, newError(http.StatusBadRequest, "invalid_token", "Invalid or expired token")
+		}
+	}
+	return t.UserID, nil
+}
+
+// RequestPasswordReset gửi mã đặt lại mật khẩu nếu gmail tồn tại. Việc tra user,
+// tạo mã và gửi thư chạy nền nên request trả về ngay như nhau dù gmail có tồn tại
+// hay không, không lộ tài khoản nào đã đăng ký qua nội dung hay thời gian phản hồi.
+func RequestPasswordReset(gmail string) error {
+	if gmail == "" {
+		return newError(http.StatusBadRequest, "missing_fields", "Gmail missing")
+	}
+	go sendPasswordReset(gmail)
+	return nil
+}
+
+// sendPasswordReset chạy nền cho RequestPasswordReset, lỗi chỉ được ghi log
+func sendPasswordReset(gmail string) {
+	var userID int
+	err := db.DB.QueryRow(`SELECT id FROM users WHERE gmail = ?`, gmail).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		log.Printf("DB error: %v", err)
+		return
+	}
+
+	ttl := time.Duration(config.Config.PasswordResetTTL) * time.Minute
+	token, err := createEmailToken(userID, gmail, purposeResetPassword, ttl)
+	if err != nil {
+		return
+	}
+
+	msg := mail.Message{
+		To:      gmail,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for this account.\n\nUse this code to choose a new password:\n\n    %s\n\n%sThe code expires in %d minutes. If you did not ask for this, ignore this email.\n",
+			token, linkLine("reset-password", token), config.Config.PasswordResetTTL),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := mail.Send(ctx, msg); err != nil {
+		log.Printf("Error sending %q to %s: %v", msg.Subject, msg.To, err)
+	}
+}
+
+// ResetPassword đặt mật khẩu mới bằng mã đặt lại, rồi đăng xuất mọi phiên của user
+func ResetPassword(token, password string) error {
+	if token == "" || password == "" {
+		return newError(http.StatusBadRequest, "missing_fields", "Missing fields")
+	}
+	t, err := consumeEmailToken(token, purposeResetPassword)
+	if err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "server_error", "Failed to hash password")
+	}
+
+	// Nhận được mã qua email cũng chứng minh sở hữu địa chỉ đó
+	res, err := db.DB.Exec(`UPDATE users SET password = ?, email_verified = TRUE WHERE id = ? AND gmail = ?`, hash, t.UserID, t.Email)
+	if err != nil {
+		log.Printf("DB error: %v", err)
+		return newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return newError(http.StatusBadRequest, "invalid_token", "Invalid or expired token")
+	}
+
+	// Mã đặt lại khác còn hạn không còn ý nghĩa
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = db.MongoDatabase.Collection(emailTokensCollection).DeleteMany(ctx, bson.M{"user_id": t.UserID, "purpose": purposeResetPassword})
+
+	if _, err := auth.RevokeAllSessions(t.UserID); err != nil {
+		log.Printf("Error revoking sessions after password reset for user %d: %v", t.UserID, err)
+	}
+	utils.KickRevokedSessions(t.UserID, "", "session_revoked", "Your password was reset")
+	return nil
+}
+
+func createEmailToken(userID int, gmail, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", newError(http.StatusInternalServerError, "server_error", "Failed to generate token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := emailTokens.insert(ctx, emailToken{
+		TokenHash: hashEmailToken(token),
+		UserID:    userID,
+		Purpose:   purpose,
+		Email:     gmail,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		log.Printf("Error storing %s token for user %d: %v", purpose, userID, err)
+		return "", newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+	return token, nil
+}
+
+// consumeEmailToken lấy và xóa mã còn hạn; mỗi mã chỉ dùng được một lần
+func consumeEmailToken(token, purpose string) (*emailToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t, err := emailTokens.consume(ctx, hashEmailToken(token), purpose, time.Now())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, newError(http.StatusBadRequest, "invalid_token", "Invalid or expired token")
+	} else if err != nil {
+		log.Printf("Error reading %s token: %v", purpose, err)
+		return nil, newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+	return t, nil
+}
+
+func hashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// linkLine trả dòng chứa link tới trang web nếu có APP_URL
+func linkLine(path, token string) string {
+	if config.Config.AppURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("Or open this link:\n\n    %s/%s?token=%s\n\n", config.Config.AppURL, path, url.QueryEscape(token))
+}
+
+// sendMailAsync gửi thư ở goroutine riêng để handler không phải chờ SMTP
+func sendMailAsync(msg mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := mail.Send(ctx, msg); err != nil {
+			log.Printf("Error sending %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
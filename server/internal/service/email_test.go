@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"server/internal/mail"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memEmailTokens là emailTokenStore trong bộ nhớ, cùng ngữ nghĩa với bản MongoDB
+type memEmailTokens struct {
+	mu     sync.Mutex
+	tokens map[string]emailToken
+}
+
+func (m *memEmailTokens) insert(ctx context.Context, t emailToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[t.TokenHash] = t
+	return nil
+}
+
+func (m *memEmailTokens) consume(ctx context.Context, tokenHash, purpose string, now time.Time) (*emailToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[tokenHash]
+	if !ok || t.Purpose != purpose || !t.ExpiresAt.After(now) {
+		return nil, mongo.ErrNoDocuments
+	}
+	delete(m.tokens, tokenHash)
+	return &t, nil
+}
+
+// useTestMail thay kho mã và mailer bằng bản trong bộ nhớ và outbox tạm, trả về thư mục outbox
+func useTestMail(t *testing.T) (*memEmailTokens, string) {
+	t.Helper()
+	store := &memEmailTokens{tokens: make(map[string]emailToken)}
+	dir := t.TempDir()
+
+	oldStore, oldMailer := emailTokens, mail.Default
+	emailTokens = store
+	mail.Default = &mail.FileMailer{Dir: dir, From: "noreply@localhost"}
+	t.Cleanup(func() {
+		emailTokens = oldStore
+		mail.Default = oldMailer
+	})
+	return store, dir
+}
+
+var codeLine = regexp.MustCompile(`(?m)^    (\S+)\r$`)
+
+// waitForCode chờ thư đầu tiên xuất hiện trong outbox và lấy mã trong thư
+func waitForCode(t *testing.T, dir string) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+		if len(files) > 0 {
+			data, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := codeLine.FindSubmatch(data)
+			if m == nil {
+				t.Fatalf("no code in mail:\n%s", data)
+			}
+			return string(m[1])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no mail written to outbox")
+	return ""
+}
+
+func TestHashEmailToken(t *testing.T) {
+	a := hashEmailToken("token-a")
+	if a != hashEmailToken("token-a") {
+		t.Fatal("hash is not deterministic")
+	}
+	if len(a) != 64 {
+		t.Fatalf("hash length = %d, want 64 hex chars", len(a))
+	}
+	if a == hashEmailToken("token-b") {
+		t.Fatal("different tokens have the same hash")
+	}
+}
+
+func TestVerificationTokenConsumedOnce(t *testing.T) {
+	store, dir := useTestMail(t)
+
+	if err := SendVerification(7, "player@example.com"); err != nil {
+		t.Fatalf("SendVerification: %v", err)
+	}
+	code := waitForCode(t, dir)
+
+	// Chỉ lưu hash, không lưu mã gốc
+	if _, ok := store.tokens[code]; ok {
+		t.Fatal("raw token stored")
+	}
+	if _, ok := store.tokens[hashEmailToken(code)]; !ok {
+		t.Fatal("token hash not stored")
+	}
+
+	if _, err := consumeEmailToken(code, purposeResetPassword); AsError(err).Code != "invalid_token" {
+		t.Fatalf("consume with wrong purpose: %v, want invalid_token", err)
+	}
+
+	got, err := consumeEmailToken(code, purposeVerifyEmail)
+	if err != nil {
+		t.Fatalf("first consume: %v", err)
+	}
+	if got.UserID != 7 || got.Email != "player@example.com" {
+		t.Fatalf("consumed token = %+v", got)
+	}
+
+	if _, err := consumeEmailToken(code, purposeVerifyEmail); AsError(err).Code != "invalid_token" {
+		t.Fatalf("second consume: %v, want invalid_token", err)
+	}
+}
+
+func TestExpiredEmailTokenRejected(t *testing.T) {
+	useTestMail(t)
+
+	code, err := createEmailToken(7, "player@example.com", purposeResetPassword, -time.Minute)
+	if err != nil {
+		t.Fatalf("createEmailToken: %v", err)
+	}
+	if _, err := consumeEmailToken(code, purposeResetPassword); AsError(err).Code != "invalid_token" {
+		t.Fatalf("consume expired token: %v, want invalid_token", err)
+	}
+}
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"server/internal/auth"
+	"server/internal/db"
+	"server/internal/events"
+	"server/internal/mail"
+	"server/internal/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Đổi thông tin tài khoản và xóa tài khoản. Mọi thao tác đều yêu cầu nhập lại mật khẩu.
+//
+// Xóa tài khoản trải trên hai database nên không có transaction chung. Trước khi xóa,
+// một bản ghi nhật ký được tạo trong account_deletions; mỗi bước xóa xong được ghi vào
+// nhật ký. Bước nào lỗi thì nhật ký vẫn ở trạng thái pending và RetryAccountDeletions
+// chạy lại các bước còn thiếu (mọi bước đều idempotent). Tài khoản đang chờ xóa không
+// đăng nhập được.
+
+const deletionsCollection = "account_deletions"
+
+// Các bước xóa theo thứ tự: thu hồi phiên trước để token đang dùng mất hiệu lực ngay
+var deletionSteps = []string{"user_tokens", "mysql", "email_tokens", "user_cards", "user_decks", "pve_scores"}
+
+type deletionJournal struct {
+	UserID    int       `bson:"user_id"`
+	Status    string    `bson:"status"` // pending, done
+	Steps     []string  `bson:"steps"`  // các bước đã xong
+	Attempts  int       `bson:"attempts"`
+	LastError string    `bson:"last_error,omitempty"`
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// deletionStore lưu nhật ký xóa tài khoản, mỗi user một bản ghi.
+// Test thay bằng bản lưu trong bộ nhớ.
+type deletionStore interface {
+	// start mở lần xóa mới: nhật ký cũ của cùng user id (đã xong) được đặt lại từ đầu
+	start(ctx context.Context, userID int, now time.Time) error
+	load(ctx context.Context, userID int) (*deletionJournal, error)
+	stepDone(ctx context.Context, userID int, step string, now time.Time) error
+	stepFailed(ctx context.Context, userID int, reason string, now time.Time) error
+	finish(ctx context.Context, userID int, now time.Time) error
+	pending(ctx context.Context) ([]deletionJournal, error)
+	isPending(ctx context.Context, userID int) (bool, error)
+}
+
+var deletions deletionStore = mongoDeletions{}
+
+// runDeletionStep chạy một bước xóa, test thay để giả lập lỗi
+var runDeletionStep = deleteStep
+
+type mongoDeletions struct{}
+
+func (mongoDeletions) start(ctx context.Context, userID int, now time.Time) error {
+	_, err := db.MongoDatabase.Collection(deletionsCollection).UpdateOne(ctx,
+		bson.M{"user_id": userID},
+		bson.M{
+			"$set":   bson.M{"status": "pending", "steps": []string{}, "attempts": 0, "created_at": now, "updated_at": now},
+			"$unset": bson.M{"last_error": ""},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (mongoDeletions) load(ctx context.Context, userID int) (*deletionJournal, error) {
+	var j deletionJournal
+	if err := db.MongoDatabase.Collection(deletionsCollection).FindOne(ctx, bson.M{"user_id": userID}).Decode(&j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+func (mongoDeletions) stepDone(ctx context.Context, userID int, step string, now time.Time) error {
+	_, err := db.MongoDatabase.Collection(deletionsCollection).UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{
+		"$addToSet": bson.M{"steps": step},
+		"$set":      bson.M{"updated_at": now},
+	})
+	return err
+}
+
+func (mongoDeletions) stepFailed(ctx context.Context, userID int, reason string, now time.Time) error {
+	_, err := db.MongoDatabase.Collection(deletionsCollection).UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{
+		"$set": bson.M{"last_error": reason, "updated_at": now},
+		"$inc": bson.M{"attempts": 1},
+	})
+	return err
+}
+
+func (mongoDeletions) finish(ctx context.Context, userID int, now time.Time) error {
+	_, err := db.MongoDatabase.Collection(deletionsCollection).UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{
+		"$set":   bson.M{"status": "done", "updated_at": now},
+		"$unset": bson.M{"last_error": ""},
+	})
+	return err
+}
+
+func (mongoDeletions) pending(ctx context.Context) ([]deletionJournal, error) {
+	cur, err := db.MongoDatabase.Collection(deletionsCollection).Find(ctx, bson.M{"status": "pending"})
+	if err != nil {
+		return nil, err
+	}
+	var pending []deletionJournal
+	if err := cur.All(ctx, &pending); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func (mongoDeletions) isPending(ctx context.Context, userID int) (bool, error) {
+	n, err := db.MongoDatabase.Collection(deletionsCollection).CountDocuments(ctx, bson.M{"user_id": userID, "status": "pending"})
+	return n > 0, err
+}
+
+// reauthenticate kiểm tra lại mật khẩu của user trước thao tác nhạy cảm
+func reauthenticate(userID int, password string) (gmail, username string, err error) {
+	if password == "" {
+		return "", "", newError(http.StatusBadRequest, "missing_fields", "Current password required")
+	}
+
+	var stored string
+	err = db.DB.QueryRow(`SELECT gmail, username, password FROM users WHERE id = ?`, userID).Scan(&gmail, &username, &stored)
+	if err == sql.ErrNoRows {
+		return "", "", newError(http.StatusNotFound, "not_found", "User not found")
+	} else if err != nil {
+		log.Printf("DB error: %v", err)
+		return "", "", newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+
+	if ok, _ := auth.VerifyPassword(password, stored); !ok {
+		return "", "", newError(http.StatusUnauthorized, "invalid_credentials", "Current password is incorrect")
+	}
+	return gmail, username, nil
+}
+
+// ChangeUsername đổi tên hiển thị
+func ChangeUsername(userID int, password, username string) (*Profile, error) {
+	username = strings.TrimSpace(username)
+	if n := utf8.RuneCountInString(username); n < 3 || n > 32 {
+		return nil, newError(http.StatusBadRequest, "invalid_username", "Username must be 3 to 32 characters")
+	}
+	gmail, _, err := reauthenticate(userID, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.DB.Exec(`UPDATE users SET username = ? WHERE id = ?`, username, userID); err != nil {
+		log.Printf("DB error: %v", err)
+		return nil, newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+	if err := auth.UpdateSessionIdentity(userID, gmail, username); err != nil {
+		log.Printf("Error updating sessions of user %d: %v", userID, err)
+	}
+	return GetProfile(userID)
+}
+
+// ChangeEmail đổi gmail; địa chỉ mới cần xác thực lại và địa chỉ cũ được báo tin
+func ChangeEmail(userID int, password, gmail string) (*Profile, error) {
+	gmail = strings.TrimSpace(gmail)
+	if !strings.Contains(gmail, "@") || strings.ContainsAny(gmail, " \r\n") {
+		return nil, newError(http.StatusBadRequest, "invalid_email", "Invalid email address")
+	}
+	oldGmail, username, err := reauthenticate(userID, password)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(gmail, oldGmail) {
+		return nil, newError(http.StatusBadRequest, "invalid_email", "New email is the same as the current one")
+	}
+
+	var exists int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE gmail = ?`, gmail).Scan(&exists); err != nil {
+		log.Printf("DB error: %v", err)
+		return nil, newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+	if exists > 0 {
+		return nil, newError(http.StatusConflict, "duplicate", "Gmail already registered")
+	}
+
+	if _, err := db.DB.Exec(`UPDATE users SET gmail = ?, email_verified = FALSE WHERE id = ?`, gmail, userID); err != nil {
+		// Hai request đổi cùng lúc sang một địa chỉ: UNIQUE chặn request sau
+		log.Printf("DB error: %v", err)
+		return nil, newError(http.StatusConflict, "duplicate", "Gmail already registered")
+	}
+	if err := auth.UpdateSessionIdentity(userID, gmail, username); err != nil {
+		log.Printf("Error updating sessions of user %d: %v", userID, err)
+	}
+
+	if err := SendVerification(userID, gmail); err != nil {
+		log.Printf("Error sending verification email to user %d: %v", userID, err)
+	}
+	sendMailAsync(mail.Message{
+		To:      oldGmail,
+		Subject: "Your email address was changed",
+		Body:    "The email address of your account was just changed. If this was not you, reset your password immediately.\n",
+	})
+	return GetProfile(userID)
+}
+
+// ChangePassword đổi mật khẩu và đăng xuất mọi phiên khác ngoài phiên keepSession
+func ChangePassword(userID int, oldPassword, newPassword, keepSession string) error {
+	if newPassword == "" {
+		return newError(http.StatusBadRequest, "missing_fields", "New password required")
+	}
+	gmail, _, err := reauthenticate(userID, oldPassword)
+	if err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return newError(http.StatusInternalServerError, "server_error", "Failed to hash password")
+	}
+	if _, err := db.DB.Exec(`UPDATE users SET password = ? WHERE id = ?`, hash, userID); err != nil {
+		log.Printf("DB error: %v", err)
+		return newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+
+	if _, err := auth.RevokeOtherSessions(userID, keepSession); err != nil {
+		log.Printf("Error revoking sessions of user %d: %v", userID, err)
+	}
+	utils.KickRevokedSessions(userID, keepSession, "session_revoked", "Your password was changed on another device")
+	sendMailAsync(mail.Message{
+		To:      gmail,
+		Subject: "Your password was changed",
+		Body:    "The password of your account was just changed. If this was not you, reset your password immediately.\n",
+	})
+	return nil
+}
+
+// DeleteAccount xóa tài khoản và toàn bộ dữ liệu của user trên MySQL và MongoDB
+func DeleteAccount(userID int, password string) error {
+	if _, _, err := reauthenticate(userID, password); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// user id có thể được MySQL cấp lại, nên mỗi lần xóa bắt đầu lại nhật ký từ đầu
+	if err := deletions.start(ctx, userID, time.Now()); err != nil {
+		log.Printf("Error writing deletion journal for user %d: %v", userID, err)
+		return newError(http.StatusInternalServerError, "server_error", "Database error")
+	}
+
+	if err := runDeletion(userID); err != nil {
+		log.Printf("Account deletion of user %d incomplete, will retry: %v", userID, err)
+		return newError(http.StatusAccepted, "deletion_pending", "Account deletion started and will complete shortly")
+	}
+	return nil
+}
+
+// deletionPending trả true nếu tài khoản đang trong quá trình xóa
+func deletionPending(userID int) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pending, err := deletions.isPending(ctx, userID)
+	return err == nil && pending
+}
+
+// runDeletion chạy các bước chưa xong trong nhật ký xóa của user
+func runDeletion(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	j, err := deletions.load(ctx, userID)
+	if err != nil {
+		return err
+	}
+	done := make(map[string]bool, len(j.Steps))
+	for _, s := range j.Steps {
+		done[s] = true
+	}
+
+	for _, step := range deletionSteps {
+		if done[step] {
+			continue
+		}
+		if err := runDeletionStep(ctx, userID, step); err != nil {
+			_ = deletions.stepFailed(ctx, userID, step+": "+err.Error(), time.Now())
+			return err
+		}
+		if err := deletions.stepDone(ctx, userID, step, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	if err := deletions.finish(ctx, userID, time.Now()); err != nil {
+		return err
+	}
+	log.Printf("Account of user %d deleted", userID)
+	events.Publish(events.AccountDeleted, events.AccountDeletedData{UserID: userID})
+	return nil
+}
+
+func deleteStep(ctx context.Context, userID int, step string) error {
+	mongoDB := db.MongoDatabase
+	switch step {
+	case "user_tokens":
+		return auth.DeleteSessions(ctx, userID)
+	case "mysql":
+		tx, err := db.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(`DELETE FROM user_stats WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, userID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	case "email_tokens":
+		_, err := mongoDB.Collection(emailTokensCollection).DeleteMany(ctx, bson.M{"user_id": userID})
+		return err
+	case "user_cards", "user_decks":
+		_, err := mongoDB.Collection(step).DeleteMany(ctx, bson.M{"user_id": userID})
+		return err
+	case "pve_scores":
+		// Giữ điểm của đồng đội, chỉ gỡ id của user
+		_, err := mongoDB.Collection("pve_scores").UpdateMany(ctx,
+			bson.M{"user_ids": userID},
+			bson.M{"$pull": bson.M{"user_ids": userID}},
+		)
+		return err
+	}
+	return errors.New("unknown deletion step " + step)
+}
+
+// RetryAccountDeletions chạy lại các lần xóa tài khoản chưa hoàn tất, trả về số lần xóa xong
+func RetryAccountDeletions() int {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pending, err := deletions.pending(ctx)
+	if err != nil {
+		log.Printf("Error listing pending account deletions: %v", err)
+		return 0
+	}
+
+	completed := 0
+	for _, j := range pending {
+		if err := runDeletion(j.UserID); err != nil {
+			log.Printf("Account deletion of user %d still incomplete: %v", j.UserID, err)
+			continue
+		}
+		completed++
+	}
+	return completed
+}
+
+// RunDeletionRetries chạy RetryAccountDeletions ngay và sau mỗi interval, tới khi stop đóng
+func RunDeletionRetries(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		RetryAccountDeletions()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memDeletions là deletionStore trong bộ nhớ, cùng ngữ nghĩa với bản MongoDB
+type memDeletions struct {
+	mu       sync.Mutex
+	journals map[int]*deletionJournal
+}
+
+func (m *memDeletions) start(ctx context.Context, userID int, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.journals[userID] = &deletionJournal{UserID: userID, Status: "pending", Steps: []string{}, CreatedAt: now, UpdatedAt: now}
+	return nil
+}
+
+func (m *memDeletions) load(ctx context.Context, userID int) (*deletionJournal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.journals[userID]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	copied := *j
+	copied.Steps = append([]string(nil), j.Steps...)
+	return &copied, nil
+}
+
+func (m *memDeletions) stepDone(ctx context.Context, userID int, step string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j := m.journals[userID]
+	for _, s := range j.Steps {
+		if s == step {
+			return nil
+		}
+	}
+	j.Steps = append(j.Steps, step)
+	j.UpdatedAt = now
+	return nil
+}
+
+func (m *memDeletions) stepFailed(ctx context.Context, userID int, reason string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j := m.journals[userID]
+	j.LastError = reason
+	j.Attempts++
+	j.UpdatedAt = now
+	return nil
+}
+
+func (m *memDeletions) finish(ctx context.Context, userID int, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j := m.journals[userID]
+	j.Status = "done"
+	j.LastError = ""
+	j.UpdatedAt = now
+	return nil
+}
+
+func (m *memDeletions) pending(ctx context.Context) ([]deletionJournal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []deletionJournal
+	for _, j := range m.journals {
+		if j.Status == "pending" {
+			out = append(out, *j)
+		}
+	}
+	return out, nil
+}
+
+func (m *memDeletions) isPending(ctx context.Context, userID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.journals[userID]
+	return ok && j.Status == "pending", nil
+}
+
+// useTestDeletions thay kho nhật ký và các bước xóa; bước nằm trong fail trả lỗi.
+// Trả về danh sách bước đã chạy theo thứ tự.
+func useTestDeletions(t *testing.T, fail map[string]bool) (*memDeletions, *[]string) {
+	t.Helper()
+	store := &memDeletions{journals: make(map[int]*deletionJournal)}
+	var ran []string
+
+	oldStore, oldStep := deletions, runDeletionStep
+	deletions = store
+	runDeletionStep = func(ctx context.Context, userID int, step string) error {
+		if fail[step] {
+			return errors.New("unavailable")
+		}
+		ran = append(ran, step)
+		return nil
+	}
+	t.Cleanup(func() {
+		deletions = oldStore
+		runDeletionStep = oldStep
+	})
+	return store, &ran
+}
+
+func TestDeletionJournalResumesAfterFailure(t *testing.T) {
+	fail := map[string]bool{"mysql": true}
+	store, ran := useTestDeletions(t, fail)
+	ctx := context.Background()
+
+	if err := deletions.start(ctx, 7, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := runDeletion(7); err == nil {
+		t.Fatal("runDeletion succeeded with a failing step")
+	}
+	j := store.journals[7]
+	if j.Status != "pending" || j.Attempts != 1 || j.LastError == "" || !reflect.DeepEqual(j.Steps, []string{"user_tokens"}) {
+		t.Fatalf("journal after failure = %+v", j)
+	}
+	if !deletionPending(7) {
+		t.Fatal("account not reported as pending deletion")
+	}
+
+	// Lần thử lại chỉ chạy các bước còn thiếu
+	delete(fail, "mysql")
+	*ran = nil
+	if n := RetryAccountDeletions(); n != 1 {
+		t.Fatalf("RetryAccountDeletions = %d, want 1", n)
+	}
+	if want := deletionSteps[1:]; !reflect.DeepEqual(*ran, want) {
+		t.Fatalf("retry ran %v, want %v", *ran, want)
+	}
+	if j := store.journals[7]; j.Status != "done" || j.LastError != "" {
+		t.Fatalf("journal after retry = %+v", j)
+	}
+	if deletionPending(7) {
+		t.Fatal("account still pending after deletion finished")
+	}
+}
+
+func TestDeletionJournalRestartsForReusedUserID(t *testing.T) {
+	store, ran := useTestDeletions(t, nil)
+	ctx := context.Background()
+
+	if err := deletions.start(ctx, 7, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := runDeletion(7); err != nil {
+		t.Fatal(err)
+	}
+
+	// user id 7 được cấp lại cho tài khoản mới rồi tài khoản đó bị xóa: chạy lại mọi bước
+	*ran = nil
+	if err := deletions.start(ctx, 7, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if j := store.journals[7]; j.Status != "pending" || len(j.Steps) != 0 {
+		t.Fatalf("journal after restart = %+v, want pending with no steps", j)
+	}
+	if err := runDeletion(7); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*ran, deletionSteps) {
+		t.Fatalf("second deletion ran %v, want %v", *ran, deletionSteps)
+	}
+}
@@ -0,0 +1,28 @@
+// Package service chứa logic tài khoản, bộ sưu tập và deck dùng chung cho
+// handler WebSocket (handle/message) và HTTP API (api).
+//
+// Hàm trả *Error với mã lỗi giống message "error" của WebSocket; HTTP API đổi
+// Status thành mã HTTP.
+package service
+
+import "net/http"
+
+type Error struct {
+	Code    string
+	Message string
+	Status  int // mã HTTP tương ứng
+}
+
+func (e *Error) Error() string { return e.Code + ": " + e.Message }
+
+func newError(status int, code, message string) *Error {
+	return &Error{Code: code, Message: message, Status: status}
+}
+
+// AsError đổi err bất kỳ sang *Error, lỗi không rõ thành server_error
+func AsError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return newError(http.StatusInternalServerError, "server_error", err.Error())
+}
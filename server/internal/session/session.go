@@ -4,6 +4,8 @@ import (
 	"context"
 	"server/internal/types"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Slot struct {
@@ -12,25 +14,69 @@ type Slot struct {
 }
 
 type LobbyRoom struct {
-	ID         string
-	MaxSize    int
-	Type       string
-	Slots      []*Slot
-	Match      bool
-	CancelFunc context.CancelFunc
+	ID             string
+	MaxSize        int
+	Type           string
+	Slots          []*Slot
+	Match          bool
+	CancelFunc     context.CancelFunc
+	SpectatorHands bool // cho người xem thấy bài trên tay
+	CreatedAt      time.Time
+
+	// Lý do đóng phòng khi bị hủy qua CancelFunc, đọc/ghi khi giữ LobbyMu.
+	// Để trống = "canceled".
+	CloseType    string
+	CloseMessage string
 }
 
 type MatchRoom struct {
-	ID      string
-	MaxSize int
-	Type    string
-	User    []*User
+	ID             string
+	MaxSize        int
+	Type           string
+	User           []*User
+	Resume         chan *types.Client   // client đăng nhập lại muốn quay về trận
+	Spectate       chan SpectateRequest // yêu cầu vào/rời chế độ xem
+	ForceEnd       chan ForceEndRequest // admin kết thúc trận
+	SpectatorHands bool
+	StartedAt      time.Time
+
+	Tick         atomic.Int64  // tick hiện tại của trận, goroutine trận cập nhật mỗi tick
+	TickInterval time.Duration // thời gian một tick
+}
+
+// ForceEndRequest yêu cầu goroutine trận kết thúc ngay với kết quả chỉ định.
+// Winner là side thắng, giá trị khác 0/1 nghĩa là hòa (PvE: 0 = thắng, còn lại = thua).
+type ForceEndRequest struct {
+	Winner int
+	Reason string
+}
+
+// SpectateRequest là yêu cầu vào hoặc rời chế độ xem một trận
+type SpectateRequest struct {
+	Client *types.Client
+	Leave  bool
 }
 
 type User struct {
 	ID       int
-	Client   *types.Client
+	Client   *types.Client // chỉ goroutine trận ghi, qua SetClient; goroutine khác đọc qua CurrentClient
 	DataGame DataGame
+
+	clientMu sync.RWMutex
+}
+
+// CurrentClient trả về kết nối hiện tại của người chơi, an toàn khi gọi ngoài goroutine trận
+func (u *User) CurrentClient() *types.Client {
+	u.clientMu.RLock()
+	defer u.clientMu.RUnlock()
+	return u.Client
+}
+
+// SetClient thay kết nối của người chơi (kết nối lại giữa trận)
+func (u *User) SetClient(c *types.Client) {
+	u.clientMu.Lock()
+	u.Client = c
+	u.clientMu.Unlock()
 }
 
 var (
@@ -40,7 +86,11 @@ var (
 	LobbyMu   sync.RWMutex
 
 	Clients   = make(map[*types.Client]bool)
+	Users     = make(map[int]*types.Client) // user đã đăng nhập → client đang giữ phiên, cùng khóa ClientsMu
 	ClientsMu sync.RWMutex
+
+	// Draining bật khi server đang tắt: không nhận kết nối và phòng chờ mới
+	Draining atomic.Bool
 )
 
 func AddClient(c *types.Client) {
@@ -52,19 +102,76 @@ func AddClient(c *types.Client) {
 func RemoveClient(c *types.Client) {
 	ClientsMu.Lock()
 	delete(Clients, c)
+	if id := c.User.ID; id != 0 && Users[id] == c {
+		delete(Users, id)
+	}
 	ClientsMu.Unlock()
 }
 
-func IsUserLoggedIn(id int) bool {
+// BindUser gắn client với user nếu user chưa có client nào còn sống.
+// takeover = true thì luôn gắn và trả về client cũ (nếu có) để bên gọi ngắt kết nối.
+// Trả ok = false khi user đang đăng nhập ở client khác và không cho takeover.
+func BindUser(c *types.Client, id int, takeover bool) (old *types.Client, ok bool) {
+	ClientsMu.Lock()
+	defer ClientsMu.Unlock()
+
+	if cur := Users[id]; cur != nil && cur != c && !cur.IsClosed() {
+		if !takeover {
+			return nil, false
+		}
+		old = cur
+	}
+	// Client đang giữ user khác (chưa logout): gỡ mục cũ để index không trỏ nhầm
+	if prev := c.UserID(); prev != 0 && prev != id && Users[prev] == c {
+		delete(Users, prev)
+	}
+	Users[id] = c
+	c.SetUserID(id)
+	c.User.Frame = 2
+	return old, true
+}
+
+// UnbindUser gỡ client khỏi user đang đăng nhập (logout)
+func UnbindUser(c *types.Client) {
+	ClientsMu.Lock()
+	defer ClientsMu.Unlock()
+	if id := c.User.ID; id != 0 && Users[id] == c {
+		delete(Users, id)
+	}
+}
+
+// ClientByUser trả về client đang giữ phiên của user, nil nếu user chưa đăng nhập
+func ClientByUser(id int) *types.Client {
 	ClientsMu.RLock()
 	defer ClientsMu.RUnlock()
+	return Users[id]
+}
+
+// FindMatch trả về trận đang chạy theo id, nil nếu không có
+func FindMatch(id string) *MatchRoom {
+	MatchesMu.RLock()
+	defer MatchesMu.RUnlock()
+	return Matches[id]
+}
+
+// FindMatchByUser trả về trận đang chạy có người chơi id, nil nếu không có
+func FindMatchByUser(id int) *MatchRoom {
+	MatchesMu.RLock()
+	defer MatchesMu.RUnlock()
 
-	for client := range Clients {
-		if client.User.ID == id && client.User.ID != 0 {
-			return true
+	for _, match := range Matches {
+		for _, user := range match.User {
+			if user.ID == id {
+				return match
+			}
 		}
 	}
-	return false
+	return nil
+}
+
+func IsUserLoggedIn(id int) bool {
+	c := ClientByUser(id)
+	return c != nil && !c.IsClosed()
 }
 
 type DataGame struct {
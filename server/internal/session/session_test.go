@@ -0,0 +1,74 @@
+package session
+
+import (
+	"testing"
+
+	"server/internal/types"
+)
+
+// resetUsers xóa index user giữa các test
+func resetUsers(t *testing.T) {
+	t.Helper()
+	ClientsMu.Lock()
+	Users = make(map[int]*types.Client)
+	ClientsMu.Unlock()
+	t.Cleanup(func() {
+		ClientsMu.Lock()
+		Users = make(map[int]*types.Client)
+		ClientsMu.Unlock()
+	})
+}
+
+func TestBindUserRejectWhileOtherClientAlive(t *testing.T) {
+	resetUsers(t)
+	a := &types.Client{Done: make(chan struct{})}
+	b := &types.Client{Done: make(chan struct{})}
+
+	if _, ok := BindUser(a, 1, false); !ok {
+		t.Fatal("first bind rejected")
+	}
+	if _, ok := BindUser(b, 1, false); ok {
+		t.Fatal("second bind accepted under reject policy")
+	}
+	if ClientByUser(1) != a || b.UserID() != 0 {
+		t.Fatal("rejected bind changed the index or the client")
+	}
+
+	// Kết nối cũ đã đóng thì client mới được gắn
+	close(a.Done)
+	if _, ok := BindUser(b, 1, false); !ok || ClientByUser(1) != b {
+		t.Fatal("bind after old client closed rejected")
+	}
+}
+
+func TestBindUserTakeoverReturnsOldClient(t *testing.T) {
+	resetUsers(t)
+	a := &types.Client{Done: make(chan struct{})}
+	b := &types.Client{Done: make(chan struct{})}
+
+	BindUser(a, 1, true)
+	old, ok := BindUser(b, 1, true)
+	if !ok || old != a {
+		t.Fatalf("takeover = %p, %v; want old client, true", old, ok)
+	}
+	if ClientByUser(1) != b || b.UserID() != 1 {
+		t.Fatal("takeover did not bind the new client")
+	}
+	if old, _ := BindUser(b, 1, true); old != nil {
+		t.Fatal("rebinding the same client returned an old client")
+	}
+}
+
+func TestBindUserDropsStaleEntry(t *testing.T) {
+	resetUsers(t)
+	c := &types.Client{Done: make(chan struct{})}
+
+	BindUser(c, 1, false)
+	BindUser(c, 2, false)
+	if ClientByUser(1) != nil {
+		t.Fatal("index still maps the previous user to the client")
+	}
+	if ClientByUser(2) != c {
+		t.Fatal("index does not map the new user to the client")
+	}
+}
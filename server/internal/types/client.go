@@ -2,7 +2,10 @@
 package types
 
 import (
+	"server/internal/codec"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -14,11 +17,212 @@ type User struct {
 }
 
 type Client struct {
-	Conn      *websocket.Conn
-	Send      chan []byte
-	Inbox     chan []byte
-	Once      sync.Once
-	User      User
+	Conn        *websocket.Conn
+	Out         *OutQueue // hàng đợi gửi có ưu tiên, writePump lấy message từ đây
+	Inbox       chan []byte
+	Once        sync.Once
+	User        User
+	RoomType    string
+	SlotIndex   int
+	Codec       codec.Codec   // định dạng message đã thương lượng qua subprotocol
+	Done        chan struct{} // đóng khi kết nối bị ngắt
+	SessionID   string        // cấp khi client gửi hello thành công
+	AuthSession string        // phiên đăng nhập (token) đang dùng, thu hồi khi logout
+	LastSeen    atomic.Int64  // unix nano lần cuối nhận được dữ liệu hoặc pong, cập nhật bởi tầng kết nối
+	AliveFor    time.Duration // client bị coi là chết nếu im lặng lâu hơn khoảng này
+	rtt         atomic.Int64  // RTT đã làm mượt (nano giây), 0 = chưa đo
+	Info        ClientInfo
+
+	ConnectedAt time.Time
+	RemoteAddr  string // địa chỉ lúc kết nối, giữ lại để log/admin sau khi Conn đóng
+
+	// stateMu bảo vệ User.ID, SessionID, Info, RoomType và SlotIndex khi ghi, để
+	// goroutine khác (admin API) đọc được qua State. Goroutine kết nối tự đọc không cần khóa.
+	stateMu sync.RWMutex
+}
+
+// ClientState là bản sao các trường của client đọc được từ goroutine bất kỳ
+type ClientState struct {
+	UserID    int
+	SessionID string
+	Info      ClientInfo
 	RoomType  string
 	SlotIndex int
 }
+
+// State chụp lại user, hello và phòng chờ hiện tại của client
+func (c *Client) State() ClientState {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	info := c.Info
+	info.Features = append([]string(nil), c.Info.Features...)
+	return ClientState{
+		UserID:    c.User.ID,
+		SessionID: c.SessionID,
+		Info:      info,
+		RoomType:  c.RoomType,
+		SlotIndex: c.SlotIndex,
+	}
+}
+
+// UserID đọc id user đang đăng nhập, an toàn khi gọi từ goroutine khác
+func (c *Client) UserID() int {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.User.ID
+}
+
+// SetUserID gắn id user cho client
+func (c *Client) SetUserID(id int) {
+	c.stateMu.Lock()
+	c.User.ID = id
+	c.stateMu.Unlock()
+}
+
+// ResetUser đưa client về trạng thái chưa đăng nhập
+func (c *Client) ResetUser() {
+	c.stateMu.Lock()
+	c.User = User{}
+	c.AuthSession = ""
+	c.stateMu.Unlock()
+}
+
+// SetHello ghi thông tin client gửi trong hello
+func (c *Client) SetHello(sessionID string, info ClientInfo) {
+	c.stateMu.Lock()
+	c.SessionID = sessionID
+	c.Info = info
+	c.stateMu.Unlock()
+}
+
+// SetRoom ghi phòng chờ và slot client đang giữ; roomType rỗng, slot -1 khi rời phòng
+func (c *Client) SetRoom(roomType string, slot int) {
+	c.stateMu.Lock()
+	c.RoomType = roomType
+	c.SlotIndex = slot
+	c.stateMu.Unlock()
+}
+
+// ClientInfo là thông tin client gửi trong hello
+type ClientInfo struct {
+	ProtocolVersion int
+	Platform        string
+	Build           string
+	Features        []string // tính năng đã thương lượng
+}
+
+// Touch ghi nhận client vừa phản hồi (message hoặc pong)
+func (c *Client) Touch() {
+	c.LastSeen.Store(time.Now().UnixNano())
+}
+
+// IsAlive trả về true nếu kết nối còn mở và client đã phản hồi trong AliveFor.
+// Không chạm vào socket nên gọi được từ bất kỳ goroutine nào.
+func (c *Client) IsAlive() bool {
+	if c.Conn == nil || c.IsClosed() {
+		return false
+	}
+	if c.AliveFor <= 0 {
+		return true
+	}
+	return time.Since(time.Unix(0, c.LastSeen.Load())) <= c.AliveFor
+}
+
+// ObserveRTT cập nhật RTT làm mượt theo kiểu TCP (SRTT = 7/8 SRTT + 1/8 mẫu mới)
+func (c *Client) ObserveRTT(sample time.Duration) {
+	if sample <= 0 {
+		return
+	}
+	old := c.rtt.Load()
+	if old == 0 {
+		c.rtt.Store(int64(sample))
+		return
+	}
+	c.rtt.Store(old + (int64(sample)-old)/8)
+}
+
+// RTT trả về RTT làm mượt, 0 nếu chưa có mẫu nào
+func (c *Client) RTT() time.Duration {
+	return time.Duration(c.rtt.Load())
+}
+
+// Greeted trả về true nếu client đã hoàn tất hello
+func (c *Client) Greeted() bool {
+	return c.SessionID != ""
+}
+
+// HasFeature trả về true nếu tính năng đã được thương lượng trong hello
+func (c *Client) HasFeature(name string) bool {
+	for _, f := range c.Info.Features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsClosed trả về true nếu kết nối của client đã bị ngắt
+func (c *Client) IsClosed() bool {
+	if c.Done == nil {
+		return false
+	}
+	select {
+	case <-c.Done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Send mã hóa và đưa message critical vào hàng đợi gửi
+func (c *Client) Send(v interface{}) bool {
+	data, err := c.Encode(v)
+	if err != nil {
+		return false
+	}
+	return c.SendRaw(data)
+}
+
+// SendRaw đưa message đã mã hóa vào hàng đợi gửi với mức critical
+func (c *Client) SendRaw(data []byte) bool {
+	if c.Out == nil {
+		return false
+	}
+	return c.Out.Push(data)
+}
+
+// SendUpdate đưa update đã mã hóa vào hàng đợi, gộp với update chưa gửi cùng key
+func (c *Client) SendUpdate(key string, data []byte) bool {
+	if c.Out == nil {
+		return false
+	}
+	return c.Out.PushUpdate(key, data)
+}
+
+// codecOrDefault trả về codec của client, mặc định JSON
+func (c *Client) codecOrDefault() codec.Codec {
+	if c.Codec == nil {
+		return codec.JSON
+	}
+	return c.Codec
+}
+
+// Encode mã hóa message gửi cho client theo codec của nó
+func (c *Client) Encode(v interface{}) ([]byte, error) {
+	return c.codecOrDefault().Marshal(v)
+}
+
+// Decode giải mã message nhận từ client theo codec của nó
+func (c *Client) Decode(data []byte, v interface{}) error {
+	return c.codecOrDefault().Unmarshal(data, v)
+}
+
+// EncodeBatch gói nhiều message đã mã hóa thành một frame
+func (c *Client) EncodeBatch(msgs [][]byte) ([]byte, error) {
+	return c.codecOrDefault().Batch(msgs)
+}
+
+// FrameType trả về loại frame WebSocket dùng khi ghi cho client
+func (c *Client) FrameType() int {
+	return c.codecOrDefault().FrameType()
+}
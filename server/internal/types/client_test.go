@@ -0,0 +1,51 @@
+package types
+
+import (
+	"sync"
+	"testing"
+)
+
+// Chạy với -race: State đọc song song với các setter mà không có data race
+func TestClientStateConcurrentAccess(t *testing.T) {
+	c := &Client{}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			c.SetUserID(i)
+			c.SetHello("s", ClientInfo{Features: []string{"batch"}})
+			c.SetRoom("1v1", i%2)
+			c.ResetUser()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			_ = c.State()
+			_ = c.UserID()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestClientStateCopiesFeatures(t *testing.T) {
+	c := &Client{}
+	c.SetHello("sess", ClientInfo{Platform: "web", Features: []string{"batch"}})
+	c.SetUserID(7)
+	c.SetRoom("2v2", 3)
+
+	st := c.State()
+	if st.UserID != 7 || st.SessionID != "sess" || st.Info.Platform != "web" || st.RoomType != "2v2" || st.SlotIndex != 3 {
+		t.Fatalf("State = %+v", st)
+	}
+	st.Info.Features[0] = "changed"
+	if c.Info.Features[0] != "batch" {
+		t.Fatal("State shares the Features slice with the client")
+	}
+
+	c.ResetUser()
+	if c.UserID() != 0 {
+		t.Fatalf("UserID after ResetUser = %d", c.UserID())
+	}
+}
@@ -0,0 +1,199 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// Priority là mức ưu tiên của message gửi cho client
+type Priority int
+
+const (
+	// PriorityCritical không bao giờ bị bỏ: phản hồi request, lỗi, kết quả trận, map, ...
+	PriorityCritical Priority = iota
+	// PriorityUpdate là cập nhật trạng thái; bản mới thay bản cũ cùng key nếu client chưa nhận kịp
+	PriorityUpdate
+)
+
+type queuedUpdate struct {
+	key  string
+	data []byte
+}
+
+// OutQueue là hàng đợi gửi của một client. Message critical luôn được ghi trước,
+// update cùng key được gộp lại (chỉ giữ bản mới nhất) nên client chậm sẽ nhận ít
+// update hơn chứ không mất kết quả trận. Khi message critical chờ quá StuckTimeout
+// hoặc vượt MaxCritical, hàng đợi báo client bị kẹt để tầng kết nối ngắt nó.
+//
+// Cork/Uncork giữ message lại trong lúc game đang tạo dữ liệu cho một tick; khi bật
+// batching, Next trả tất cả message đang chờ để ghi thành một frame.
+type OutQueue struct {
+	mu       sync.Mutex
+	critical [][]byte
+	waiting  time.Time // thời điểm hàng critical bắt đầu chờ hoặc lần ghi gần nhất
+	updates  []queuedUpdate
+	notify   chan struct{}
+	closed   bool
+	stuck    bool
+	corked   int  // > 0: Next chờ tới khi Uncork
+	batching bool // Next trả mọi message đang chờ thay vì từng cái
+
+	MaxCritical  int
+	StuckTimeout time.Duration
+	OnStuck      func() // gọi một lần khi phát hiện client bị kẹt
+}
+
+func NewOutQueue(maxCritical int, stuckTimeout time.Duration) *OutQueue {
+	return &OutQueue{
+		notify:       make(chan struct{}, 1),
+		MaxCritical:  maxCritical,
+		StuckTimeout: stuckTimeout,
+	}
+}
+
+// Push đưa message critical vào hàng đợi, trả false nếu hàng đợi đã đóng hoặc client bị kẹt
+func (q *OutQueue) Push(data []byte) bool {
+	q.mu.Lock()
+	if q.closed || q.stuck {
+		q.mu.Unlock()
+		return false
+	}
+	if len(q.critical) == 0 {
+		q.waiting = time.Now()
+	}
+	q.critical = append(q.critical, data)
+
+	stuck := len(q.critical) > q.MaxCritical ||
+		(q.StuckTimeout > 0 && time.Since(q.waiting) > q.StuckTimeout)
+	if stuck {
+		q.stuck = true
+	}
+	q.mu.Unlock()
+
+	if stuck {
+		if q.OnStuck != nil {
+			q.OnStuck()
+		}
+		return false
+	}
+	q.signal()
+	return true
+}
+
+// PushUpdate đưa update vào hàng đợi, thay thế update chưa gửi có cùng key
+func (q *OutQueue) PushUpdate(key string, data []byte) bool {
+	q.mu.Lock()
+	if q.closed || q.stuck {
+		q.mu.Unlock()
+		return false
+	}
+	replaced := false
+	for i := range q.updates {
+		if q.updates[i].key == key {
+			q.updates[i].data = data
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		q.updates = append(q.updates, queuedUpdate{key: key, data: data})
+	}
+	q.mu.Unlock()
+
+	q.signal()
+	return true
+}
+
+func (q *OutQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Cork giữ các message mới lại cho tới khi Uncork, dùng để gom message của một tick
+func (q *OutQueue) Cork() {
+	q.mu.Lock()
+	q.corked++
+	q.mu.Unlock()
+}
+
+// Uncork thả các message đã giữ bởi Cork
+func (q *OutQueue) Uncork() {
+	q.mu.Lock()
+	if q.corked > 0 {
+		q.corked--
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+// SetBatching bật/tắt gom nhiều message vào một lần Next
+func (q *OutQueue) SetBatching(on bool) {
+	q.mu.Lock()
+	q.batching = on
+	q.mu.Unlock()
+}
+
+// Next chờ và lấy các message cần ghi, critical trước update. Không bật batching
+// thì mỗi lần trả đúng một message. Trả false khi hàng đợi đã đóng.
+func (q *OutQueue) Next() ([][]byte, bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return nil, false
+		}
+		if q.corked == 0 && (len(q.critical) > 0 || len(q.updates) > 0) {
+			out := q.take()
+			q.mu.Unlock()
+			return out, true
+		}
+		q.mu.Unlock()
+
+		<-q.notify
+	}
+}
+
+// take lấy message khỏi hàng đợi, gọi khi đang giữ q.mu
+func (q *OutQueue) take() [][]byte {
+	if !q.batching {
+		if len(q.critical) > 0 {
+			data := q.critical[0]
+			q.critical[0] = nil
+			q.critical = q.critical[1:]
+			q.waiting = time.Now()
+			return [][]byte{data}
+		}
+		data := q.updates[0].data
+		q.updates = q.updates[1:]
+		return [][]byte{data}
+	}
+
+	out := make([][]byte, 0, len(q.critical)+len(q.updates))
+	out = append(out, q.critical...)
+	for _, u := range q.updates {
+		out = append(out, u.data)
+	}
+	q.critical = nil
+	q.updates = nil
+	q.waiting = time.Now()
+	return out
+}
+
+// Close đóng hàng đợi và đánh thức Next đang chờ
+func (q *OutQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.critical = nil
+	q.updates = nil
+	q.mu.Unlock()
+	q.signal()
+}
+
+// Len trả về số message critical và update đang chờ gửi
+func (q *OutQueue) Len() (critical, updates int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.critical), len(q.updates)
+}
@@ -0,0 +1,136 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+// next gọi Next với giới hạn thời gian để test không treo khi hàng đợi rỗng
+func next(t *testing.T, q *OutQueue) [][]byte {
+	t.Helper()
+	done := make(chan [][]byte, 1)
+	go func() {
+		msgs, _ := q.Next()
+		done <- msgs
+	}()
+	select {
+	case msgs := <-done:
+		return msgs
+	case <-time.After(time.Second):
+		t.Fatal("Next did not return")
+		return nil
+	}
+}
+
+func strs(msgs [][]byte) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = string(m)
+	}
+	return out
+}
+
+func TestOutQueueCoalescesUpdatesByKey(t *testing.T) {
+	q := NewOutQueue(16, 0)
+	q.PushUpdate("state", []byte("s1"))
+	q.PushUpdate("other", []byte("o1"))
+	q.PushUpdate("state", []byte("s2"))
+
+	if critical, updates := q.Len(); critical != 0 || updates != 2 {
+		t.Fatalf("Len = %d, %d; want 0, 2", critical, updates)
+	}
+	if got := strs(next(t, q)); len(got) != 1 || got[0] != "s2" {
+		t.Fatalf("first update = %v, want newest [s2] in original slot", got)
+	}
+	if got := strs(next(t, q)); len(got) != 1 || got[0] != "o1" {
+		t.Fatalf("second update = %v, want [o1]", got)
+	}
+}
+
+func TestOutQueueCriticalNeverCoalesced(t *testing.T) {
+	q := NewOutQueue(16, 0)
+	q.PushUpdate("state", []byte("s1"))
+	q.Push([]byte("c1"))
+	q.Push([]byte("c2"))
+
+	want := []string{"c1", "c2", "s1"}
+	for _, w := range want {
+		if got := strs(next(t, q)); len(got) != 1 || got[0] != w {
+			t.Fatalf("got %v, want [%s]", got, w)
+		}
+	}
+}
+
+func TestOutQueueCorkHoldsUntilUncork(t *testing.T) {
+	q := NewOutQueue(16, 0)
+	q.SetBatching(true)
+	q.Cork()
+	q.Push([]byte("c1"))
+	q.PushUpdate("state", []byte("s1"))
+	q.Push([]byte("c2"))
+
+	done := make(chan [][]byte, 1)
+	go func() {
+		msgs, _ := q.Next()
+		done <- msgs
+	}()
+	select {
+	case msgs := <-done:
+		t.Fatalf("Next returned %v while corked", strs(msgs))
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.Uncork()
+	select {
+	case msgs := <-done:
+		got := strs(msgs)
+		want := []string{"c1", "c2", "s1"}
+		if len(got) != len(want) {
+			t.Fatalf("batch = %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("batch = %v, want %v", got, want)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next did not return after Uncork")
+	}
+}
+
+func TestOutQueueStuckWhenCriticalOverflows(t *testing.T) {
+	q := NewOutQueue(2, 0)
+	stuck := 0
+	q.OnStuck = func() { stuck++ }
+
+	if !q.Push([]byte("1")) || !q.Push([]byte("2")) {
+		t.Fatal("push within limit failed")
+	}
+	if q.Push([]byte("3")) {
+		t.Fatal("push over MaxCritical succeeded")
+	}
+	if q.Push([]byte("4")) || q.PushUpdate("state", []byte("s")) {
+		t.Fatal("push after stuck succeeded")
+	}
+	if stuck != 1 {
+		t.Fatalf("OnStuck called %d times, want 1", stuck)
+	}
+}
+
+func TestOutQueueCloseWakesNext(t *testing.T) {
+	q := NewOutQueue(16, 0)
+	done := make(chan bool, 1)
+	go func() {
+		_, ok := q.Next()
+		done <- ok
+	}()
+	q.Close()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("Next returned ok after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next did not return after Close")
+	}
+}
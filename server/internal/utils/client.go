@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"log"
+	"time"
+
+	"server/internal/config"
+	"server/internal/session"
+	"server/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// Chính sách khi một user đăng nhập lúc đã có kết nối khác (SESSION_POLICY)
+const (
+	SessionReject   = "reject"   // từ chối lần đăng nhập mới
+	SessionTakeover = "takeover" // kết nối mới thay kết nối cũ, kết nối cũ bị ngắt
+)
+
+// ClaimUser gắn client với user theo SESSION_POLICY. Khi takeover, kết nối cũ nhận
+// "session_replaced" rồi bị đóng; trận đang chơi sẽ chuyển sang client mới qua resume.
+// Trả false nếu policy là reject và user đang đăng nhập ở nơi khác.
+func ClaimUser(c *types.Client, userID int) bool {
+	takeover := config.Config.SessionPolicy == SessionTakeover
+	old, ok := session.BindUser(c, userID, takeover)
+	if !ok {
+		return false
+	}
+	if old != nil {
+		log.Printf("User %d logged in from %s, replacing session at %s", userID, c.RemoteAddr, old.RemoteAddr)
+		Kick(old, "session_replaced", "This account was signed in on another device")
+	}
+	return true
+}
+
+// Kick gửi message typ kèm lý do rồi đóng socket bằng close frame
+func Kick(c *types.Client, typ string, reason string) {
+	SendMessage(c, "", typ, map[string]string{"reason": reason})
+
+	// Chờ writePump gửi nốt message trước khi đóng
+	time.AfterFunc(500*time.Millisecond, func() {
+		deadline := time.Now().Add(time.Second)
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+		_ = c.Conn.WriteControl(websocket.CloseMessage, msg, deadline)
+		c.Conn.Close()
+	})
+}
+
+// KickRevokedSessions ngắt kết nối đang mở của user nếu nó dùng phiên khác keep
+// (keep rỗng = ngắt mọi kết nối), gọi sau khi các phiên đó bị thu hồi trong MongoDB
+func KickRevokedSessions(userID int, keep string, typ string, reason string) {
+	c := session.ClientByUser(userID)
+	if c == nil || (keep != "" && c.AuthSession == keep) {
+		return
+	}
+	log.Printf("Disconnecting user %d at %s: session revoked (%s)", userID, c.RemoteAddr, typ)
+	Kick(c, typ, reason)
+}
@@ -4,6 +4,7 @@ package utils
 import (
 	"encoding/json"
 	"log"
+	"server/internal/types"
 )
 
 type IncomingMessage struct {
@@ -22,20 +23,22 @@ func UnmarshalMessage(data []byte, target interface{}) error {
 	return json.Unmarshal(data, target)
 }
 
-func SendJSON(send chan<- []byte, data interface{}) {
-	jsonData, err := json.Marshal(data)
+// SendJSON mã hóa message theo codec của client (JSON hoặc MessagePack) và đưa vào hàng gửi
+// với mức critical. Không bao giờ block; client bị kẹt sẽ bị ngắt ở tầng kết nối.
+func SendJSON(c *types.Client, data interface{}) {
+	encoded, err := c.Encode(data)
 	if err != nil {
-		log.Printf("Error marshaling JSON: %v", err)
+		log.Printf("Error marshaling message: %v", err)
 		return
 	}
-	send <- jsonData
+	c.SendRaw(encoded)
 }
 
-func SendError(send chan<- []byte, id, errorType, message string) {
+func SendError(c *types.Client, id, errorType, message string) {
 	if id == "" {
 		id = "unknown"
 	}
-	SendJSON(send, OutgoingMessage{
+	SendJSON(c, OutgoingMessage{
 		ID:   id,
 		Type: "error",
 		Data: map[string]string{
@@ -54,11 +57,11 @@ func ParseIncomingMessage(data []byte) (*IncomingMessage, error) {
 	return &msg, nil
 }
 
-func SendMessage(send chan<- []byte, id string, typ string, data interface{}) {
+func SendMessage(c *types.Client, id string, typ string, data interface{}) {
 	msg := OutgoingMessage{
 		ID:   id,
 		Type: typ,
 		Data: data,
 	}
-	SendJSON(send, msg)
+	SendJSON(c, msg)
 }
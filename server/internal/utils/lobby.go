@@ -2,9 +2,11 @@ package utils
 
 import (
 	"context"
+	"server/internal/events"
 	"server/internal/handle/game"
 	"server/internal/session"
 	"server/internal/types"
+	"time"
 )
 
 func CreateLobbyRoom(id string, roomType string, match bool) *session.LobbyRoom {
@@ -14,6 +16,10 @@ func CreateLobbyRoom(id string, roomType string, match bool) *session.LobbyRoom
 		maxSize = 2
 	case "2v2":
 		maxSize = 4
+	case game.RoomTypePvE:
+		maxSize = 1
+	case game.RoomTypeCoop:
+		maxSize = 2
 	default:
 		maxSize = 2
 	}
@@ -33,17 +39,41 @@ func CreateLobbyRoom(id string, roomType string, match bool) *session.LobbyRoom
 		Slots:      slots,
 		Match:      match,
 		CancelFunc: cancel,
+		CreatedAt:  time.Now(),
 	}
 
 	session.LobbyMu.Lock()
 	defer session.LobbyMu.Unlock()
 	session.Lobbies[id] = room
 
+	events.Publish(events.LobbyCreated, events.LobbyCreatedData{
+		LobbyID:  id,
+		RoomType: roomType,
+		Matching: match,
+	})
+
 	go game.ControlLobby(ctx, room)
 
 	return room
 }
 
+// LobbyOf trả về id phòng chờ mà client đang giữ slot, rỗng nếu không ở phòng nào
+func LobbyOf(c *types.Client) string {
+	if c == nil {
+		return ""
+	}
+	session.LobbyMu.RLock()
+	defer session.LobbyMu.RUnlock()
+	for id, room := range session.Lobbies {
+		for _, slot := range room.Slots {
+			if slot.Client == c {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
 // Trả về cả room, bool thành công và slotIndex
 func JoinLobbyRoom(lobbyID string, c *types.Client) (*session.LobbyRoom, bool, int) {
 	session.LobbyMu.Lock()
@@ -57,8 +87,7 @@ func JoinLobbyRoom(lobbyID string, c *types.Client) (*session.LobbyRoom, bool, i
 	for i, slot := range room.Slots {
 		if slot.Client == nil {
 			slot.Client = c
-			c.RoomType = string(room.Type)
-			c.SlotIndex = i
+			c.SetRoom(string(room.Type), i)
 			return room, true, i
 		}
 	}
@@ -101,20 +130,68 @@ func LeaveLobbyRoom(lobbyID string, client *types.Client) bool {
 	return true
 }
 
+// CloseLobbyRoom hủy phòng chờ với lý do cho trước; goroutine điều khiển phòng
+// sẽ báo lỗi cho người trong phòng và xóa phòng. Trả false nếu phòng không tồn tại.
+func CloseLobbyRoom(id string, errorType string, message string) bool {
+	session.LobbyMu.Lock()
+	room, ok := session.Lobbies[id]
+	if ok {
+		room.CloseType = errorType
+		room.CloseMessage = message
+	}
+	session.LobbyMu.Unlock()
+
+	if !ok || room.CancelFunc == nil {
+		return false
+	}
+	room.CancelFunc()
+	return true
+}
+
 func RemoveLobbyRoom(id string) {
 	delete(session.Lobbies, id)
 }
 
-func FindAvailableLobby(roomType string) *session.LobbyRoom {
+// FindAvailableLobby tìm phòng ghép trận còn chỗ, ưu tiên phòng có RTT trung bình
+// gần với rtt của người chơi để hai bên có độ trễ tương đương
+func FindAvailableLobby(roomType string, rtt time.Duration) *session.LobbyRoom {
 	session.LobbyMu.Lock()
 	defer session.LobbyMu.Unlock()
 
+	var best *session.LobbyRoom
+	var bestDiff time.Duration
 	for _, room := range session.Lobbies {
-		if room.Type == roomType && room.Match && !IsLobbyFull(room.ID) {
-			return room
+		if room.Type != roomType || !room.Match || IsLobbyFull(room.ID) {
+			continue
+		}
+		diff := lobbyRTT(room) - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		if best == nil || diff < bestDiff {
+			best, bestDiff = room, diff
+		}
+	}
+	return best
+}
+
+// lobbyRTT trả về RTT trung bình của người chơi trong phòng (bỏ qua client chưa đo)
+func lobbyRTT(room *session.LobbyRoom) time.Duration {
+	var total time.Duration
+	n := 0
+	for _, slot := range room.Slots {
+		if slot.Client == nil {
+			continue
+		}
+		if rtt := slot.Client.RTT(); rtt > 0 {
+			total += rtt
+			n++
 		}
 	}
-	return nil
+	if n == 0 {
+		return 0
+	}
+	return total / time.Duration(n)
 }
 
 func IsLobbyFull(lobbyID string) bool {
@@ -0,0 +1,18 @@
+package utils
+
+import "strings"
+
+// OriginAllowed kiểm tra origin có nằm trong danh sách (phân cách bởi dấu phẩy) hay không.
+// "*" chấp nhận mọi origin; so sánh không phân biệt hoa thường.
+func OriginAllowed(origin, list string) bool {
+	for _, allowed := range strings.Split(list, ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "" {
+			continue
+		}
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
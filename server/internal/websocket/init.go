@@ -1,22 +1,48 @@
 package websocket
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"server/internal/admin"
+	"server/internal/api"
 	"server/internal/config"
 	"strconv"
+	"syscall"
 )
 
+// InitWebSocketServer chạy server cho tới khi nhận SIGINT/SIGTERM,
+// sau đó tắt êm (xem shutdown) rồi mới trả về
 func InitWebSocketServer() {
 	mux := http.NewServeMux()
 	RegisterWebSocketRoutes(mux)
+	api.Register(mux)
+	admin.Register(mux, Metrics)
 
 	addr := config.Config.WSHost + ":" + strconv.Itoa(config.Config.WSPort)
-	log.Printf("Server running on %s", addr)
+	srv := &http.Server{Addr: addr, Handler: mux}
 
-	err := http.ListenAndServe(addr, mux)
-	if err != nil {
-		log.Fatalf("Server error: %v", err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Server running on %s", addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server error: %v", err)
+		}
+	case <-ctx.Done():
+		// Tín hiệu thứ hai trong lúc drain sẽ giết process như mặc định
+		stop()
+		shutdown(srv)
 	}
 }
 
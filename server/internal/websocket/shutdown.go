@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"server/internal/config"
+	"server/internal/session"
+	"server/internal/types"
+	"server/internal/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// shutdown tắt server theo thứ tự:
+//  1. bật Draining: từ chối kết nối và phòng chờ mới, đóng listener HTTP
+//  2. đóng các phòng chờ và báo "maintenance" cho mọi client
+//  3. chờ các trận đang chạy kết thúc trong SHUTDOWN_TIMEOUT giây
+//  4. hết giờ thì xử hòa các trận còn lại (vẫn phát thưởng và ghi sự kiện như trận thường)
+//  5. chờ các message còn trong hàng đợi được gửi, rồi đóng mọi WebSocket còn lại
+//
+// Đóng event bus và database do main đảm nhiệm sau khi hàm này trả về.
+func shutdown(srv *http.Server) {
+	timeout := time.Duration(config.Config.ShutdownTimeout) * time.Second
+	log.Printf("Shutting down, waiting up to %s for running matches", timeout)
+
+	session.Draining.Store(true)
+
+	// Shutdown chỉ đóng listener và request HTTP; socket đã hijack (WebSocket) vẫn chạy
+	httpCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := srv.Shutdown(httpCtx); err != nil {
+		log.Printf("HTTP shutdown error: %v", err)
+	}
+	cancel()
+
+	closeLobbies()
+	notifyMaintenance(timeout)
+
+	deadline := time.Now().Add(timeout)
+	if !waitForMatches(deadline) {
+		forfeitMatches()
+		// Các goroutine trận cần một tick để xử lý ForceEnd và gửi game_end
+		waitForMatches(time.Now().Add(5 * time.Second))
+	}
+
+	closeClients()
+	log.Println("Shutdown complete")
+}
+
+func closeLobbies() {
+	session.LobbyMu.RLock()
+	ids := make([]string, 0, len(session.Lobbies))
+	for id := range session.Lobbies {
+		ids = append(ids, id)
+	}
+	session.LobbyMu.RUnlock()
+
+	for _, id := range ids {
+		utils.CloseLobbyRoom(id, "maintenance", "Server is shutting down for maintenance")
+	}
+	if len(ids) > 0 {
+		log.Printf("Closed %d lobbies", len(ids))
+	}
+}
+
+func notifyMaintenance(timeout time.Duration) {
+	for _, c := range connectedClients() {
+		utils.SendMessage(c, "", "maintenance", map[string]interface{}{
+			"message":          "Server is restarting for maintenance. Running matches may finish.",
+			"deadline_seconds": int(timeout.Seconds()),
+		})
+	}
+}
+
+// waitForMatches chờ tới khi không còn trận nào hoặc quá deadline, trả true nếu hết trận
+func waitForMatches(deadline time.Time) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		session.MatchesMu.RLock()
+		n := len(session.Matches)
+		session.MatchesMu.RUnlock()
+		if n == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			log.Printf("%d matches still running at shutdown deadline", n)
+			return false
+		}
+		<-ticker.C
+	}
+}
+
+// forfeitMatches kết thúc các trận còn lại với kết quả hòa: không bên nào bị
+// xử thua vì server tắt, và kết quả vẫn được lưu như trận bình thường
+func forfeitMatches() {
+	session.MatchesMu.RLock()
+	defer session.MatchesMu.RUnlock()
+
+	for _, match := range session.Matches {
+		if match.ForceEnd == nil {
+			continue
+		}
+		select {
+		case match.ForceEnd <- session.ForceEndRequest{Winner: -1, Reason: "server_shutdown"}:
+		default:
+		}
+	}
+}
+
+// closeClients chờ hàng đợi gửi của các client cạn (game_end, maintenance vừa xếp hàng)
+// rồi mới gửi close frame; client không nhận kịp trong vài giây thì vẫn bị đóng
+func closeClients() {
+	clients := connectedClients()
+	drainClients(clients, time.Now().Add(3*time.Second))
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+	for _, c := range clients {
+		_ = c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		cleanup(c)
+	}
+}
+
+// drainClients chờ tới khi writePump của mọi client đã lấy hết message hoặc quá deadline
+func drainClients(clients []*types.Client, deadline time.Time) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		pending := 0
+		for _, c := range clients {
+			if c.IsClosed() {
+				continue
+			}
+			if critical, updates := c.Out.Len(); critical+updates > 0 {
+				pending++
+			}
+		}
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Printf("%d clients still had queued messages at shutdown", pending)
+			return
+		}
+		<-ticker.C
+	}
+}
+
+func connectedClients() []*types.Client {
+	session.ClientsMu.RLock()
+	defer session.ClientsMu.RUnlock()
+	list := make([]*types.Client, 0, len(session.Clients))
+	for c := range session.Clients {
+		list = append(list, c)
+	}
+	return list
+}
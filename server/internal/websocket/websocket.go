@@ -1,10 +1,18 @@
 package websocket
 
 import (
+	"encoding/binary"
 	"log"
 	"net/http"
+	"net/url"
+	"server/internal/codec"
+	"server/internal/config"
+	"server/internal/service"
 	"server/internal/session"
 	"server/internal/types"
+	"server/internal/utils"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -12,68 +20,235 @@ import (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
+	// Client chọn định dạng qua Sec-WebSocket-Protocol, không gửi thì dùng JSON
+	Subprotocols: codec.Subprotocols,
+	CheckOrigin:  checkOrigin,
+	// permessage-deflate, chỉ dùng khi client cũng hỗ trợ
+	EnableCompression: config.Config.WSCompression,
+}
+
+// checkOrigin chấp nhận client không gửi Origin (app native), origin cùng host
+// và origin nằm trong WSAllowedOrigins
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if utils.OriginAllowed(origin, config.Config.WSAllowedOrigins) {
 		return true
-	},
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	log.Printf("Rejected WebSocket origin %q from %s", origin, r.RemoteAddr)
+	return false
+}
+
+// bearerSubprotocol là tiền tố của subprotocol mang token ("bearer.<token>"),
+// dành cho trình duyệt không đặt được header Authorization khi mở WebSocket
+const bearerSubprotocol = "bearer."
+
+// upgradeToken lấy token từ header Authorization, query ?token= hoặc subprotocol.
+// subprotocol khác rỗng khi token đến từ subprotocol bearer.
+func upgradeToken(r *http.Request) (token, subprotocol string) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token), ""
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token, ""
+	}
+	for _, p := range websocket.Subprotocols(r) {
+		if token, ok := strings.CutPrefix(p, bearerSubprotocol); ok {
+			return token, p
+		}
+	}
+	return "", ""
 }
 
+// upgraderFor trả về upgrader chấp nhận thêm subprotocol bearer của request. Trình duyệt
+// hủy handshake nếu server không chọn một subprotocol nó đề nghị, nên khi client chỉ
+// gửi bearer thì server chọn lại đúng subprotocol đó (codec mặc định JSON); client gửi
+// kèm subprotocol codec thì codec vẫn được ưu tiên.
+func upgraderFor(bearer string) *websocket.Upgrader {
+	if bearer == "" {
+		return &upgrader
+	}
+	u := upgrader
+	u.Subprotocols = append(append([]string{}, codec.Subprotocols...), bearer)
+	return &u
+}
+
+// ServeWS nâng cấp kết nối. Nếu request mang token, token được kiểm tra trước khi
+// nâng cấp và client được gắn với user ngay từ đầu; không có token thì client chỉ
+// gọi được hello, login, re_login và register.
 func ServeWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if session.Draining.Load() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	var acc *service.Account
+	token, bearer := upgradeToken(r)
+	if token != "" {
+		var err error
+		if acc, err = service.ValidateToken(token); err != nil {
+			e := service.AsError(err)
+			http.Error(w, e.Message, e.Status)
+			return
+		}
+		if config.Config.SessionPolicy != utils.SessionTakeover && session.IsUserLoggedIn(acc.UserID) {
+			http.Error(w, "This account is already logged in on another device.", http.StatusConflict)
+			return
+		}
+	}
+
+	conn, err := upgraderFor(bearer).Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
 
+	if config.Config.WSCompression {
+		conn.EnableWriteCompression(true)
+		if err := conn.SetCompressionLevel(config.Config.WSCompressionLevel); err != nil {
+			log.Printf("Invalid compression level %d: %v", config.Config.WSCompressionLevel, err)
+		}
+	}
+
+	out := types.NewOutQueue(config.Config.SendQueueSize, time.Duration(config.Config.SendStuckTimeout)*time.Second)
 	client := &types.Client{
 		Conn:  conn,
-		Send:  make(chan []byte, 20),
+		Out:   out,
 		Inbox: make(chan []byte, 20),
+		Codec: codec.ForSubprotocol(conn.Subprotocol()),
+		Done:  make(chan struct{}),
+
+		AliveFor: time.Duration(config.Config.PongTimeout) * time.Second,
+
+		ConnectedAt: time.Now(),
+		RemoteAddr:  conn.RemoteAddr().String(),
+	}
+	client.Touch()
+
+	// Client không nhận kịp message critical: đóng socket, readPump sẽ dọn dẹp
+	client.Out.OnStuck = func() {
+		log.Printf("Client %s is not draining its send queue, disconnecting", conn.RemoteAddr())
+		conn.Close()
 	}
 
 	session.AddClient(client)
+	// Gắn user sau AddClient để RemoveClient dọn được chỉ mục nếu socket đóng ngay
+	if acc != nil {
+		if utils.ClaimUser(client, acc.UserID) {
+			client.AuthSession = acc.SessionID
+		} else {
+			log.Printf("User %d logged in elsewhere during upgrade, continuing unauthenticated", acc.UserID)
+		}
+	}
 
-	log.Printf("Client connected: %s", conn.RemoteAddr())
+	log.Printf("Client connected: %s (%s, user %d)", conn.RemoteAddr(), client.Codec.Name(), client.User.ID)
 
 	go readPump(client)
 	go writePump(client)
+	go heartbeat(client)
 	go processMessages(client)
 }
 
+// cleanup đóng kết nối và dọn trạng thái client, chỉ chạy một lần
+func cleanup(c *types.Client) {
+	c.Once.Do(func() {
+		session.RemoveClient(c)
+		Limiter.Forget(c)
+		close(c.Done)
+		c.Out.Close()
+		c.Conn.Close()
+		log.Printf("Client disconnected: %s", c.Conn.RemoteAddr())
+	})
+}
+
 func readPump(c *types.Client) {
 	defer func() {
-		c.Once.Do(func() {
-			session.RemoveClient(c)
-			close(c.Send)
-			c.Conn.Close()
-			log.Printf("Client disconnected: %s", c.Conn.RemoteAddr())
-		})
+		close(c.Inbox)
+		cleanup(c)
 	}()
 
+	// Mỗi pong hoặc message nhận được gia hạn read deadline;
+	// quá PongTimeout không nhận được gì thì ReadMessage lỗi và client bị ngắt
+	pongWait := time.Duration(config.Config.PongTimeout) * time.Second
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.SetPongHandler(func(payload string) error {
+		c.Touch()
+		// Ping mang thời điểm gửi, pong trả lại nguyên vẹn → đo được RTT
+		if len(payload) == 8 {
+			sent := int64(binary.BigEndian.Uint64([]byte(payload)))
+			c.ObserveRTT(time.Since(time.Unix(0, sent)))
+		}
+		return c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
 	for {
 		_, msg, err := c.Conn.ReadMessage()
 		if err != nil {
 			log.Printf("Read error from %s: %v", c.Conn.RemoteAddr(), err)
 			break
 		}
+		c.Touch()
+		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 		c.Inbox <- msg
 	}
 }
 
+// heartbeat gửi ping định kỳ. WriteControl được phép chạy song song với writePump.
+func heartbeat(c *types.Client) {
+	ticker := time.NewTicker(time.Duration(config.Config.PingInterval) * time.Second)
+	defer ticker.Stop()
+
+	writeTimeout := time.Duration(config.Config.WriteTimeout) * time.Second
+	for {
+		select {
+		case <-c.Done:
+			return
+		case <-ticker.C:
+			payload := make([]byte, 8)
+			binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+			if err := c.Conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(writeTimeout)); err != nil {
+				log.Printf("Ping to %s failed: %v", c.Conn.RemoteAddr(), err)
+				cleanup(c)
+				return
+			}
+		}
+	}
+}
+
 func writePump(c *types.Client) {
-	defer func() {
-		c.Once.Do(func() {
-			session.RemoveClient(c)
-			close(c.Send)
-			c.Conn.Close()
-			log.Printf("Client disconnected: %s", c.Conn.RemoteAddr())
-		})
-	}()
+	defer cleanup(c)
+	WriteLoop(c)
+}
 
-	for msg := range c.Send {
-		err := c.Conn.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
+// WriteLoop ghi message từ hàng đợi của client xuống socket cho tới khi hàng đợi
+// đóng hoặc ghi lỗi. Nhiều message lấy ra cùng lúc (batching) được gói thành một frame.
+func WriteLoop(c *types.Client) {
+	writeTimeout := time.Duration(config.Config.WriteTimeout) * time.Second
+	for {
+		msgs, ok := c.Out.Next()
+		if !ok {
+			return
+		}
+
+		frame := msgs[0]
+		if len(msgs) > 1 {
+			var err error
+			if frame, err = c.EncodeBatch(msgs); err != nil {
+				log.Printf("Error encoding batch for %s: %v", c.Conn.RemoteAddr(), err)
+				return
+			}
+		}
+
+		c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := c.Conn.WriteMessage(c.FrameType(), frame); err != nil {
 			log.Printf("Write error to %s: %v", c.Conn.RemoteAddr(), err)
-			break
+			return
 		}
 	}
 }
@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"server/internal/codec"
+	"server/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	old := config.Config.WSAllowedOrigins
+	t.Cleanup(func() { config.Config.WSAllowedOrigins = old })
+
+	tests := []struct {
+		name    string
+		allowed string
+		host    string
+		origin  string
+		want    bool
+	}{
+		{"native app without origin", "", "game.example.com", "", true},
+		{"same host", "", "game.example.com", "https://game.example.com", true},
+		{"same host different case", "", "game.example.com", "https://GAME.example.com", true},
+		{"same host different port", "", "game.example.com:8080", "https://game.example.com", false},
+		{"other site", "", "game.example.com", "https://evil.example.net", false},
+		{"listed origin", "https://web.example.com, https://admin.example.com", "api.example.com", "https://admin.example.com", true},
+		{"listed origin different case", "https://Web.Example.com", "api.example.com", "https://web.example.com", true},
+		{"unlisted origin", "https://web.example.com", "api.example.com", "https://web.example.com.evil.net", false},
+		{"wildcard", "*", "api.example.com", "https://anything.example.org", true},
+		{"garbage origin", "", "api.example.com", "::not a url", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Config.WSAllowedOrigins = tt.allowed
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := checkOrigin(r); got != tt.want {
+				t.Fatalf("checkOrigin = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpgradeToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       string
+		query      string
+		protocols  string
+		wantToken  string
+		wantBearer string
+	}{
+		{name: "none"},
+		{name: "authorization header", auth: "Bearer abc", wantToken: "abc"},
+		{name: "header wins over query", auth: "Bearer abc", query: "?token=q", wantToken: "abc"},
+		{name: "non bearer header ignored", auth: "Basic abc", query: "?token=q", wantToken: "q"},
+		{name: "query", query: "?token=q", wantToken: "q"},
+		{name: "query wins over subprotocol", query: "?token=q", protocols: "bearer.s", wantToken: "q"},
+		{name: "subprotocol", protocols: codec.SubprotocolMsgpack + ", bearer.s", wantToken: "s", wantBearer: "bearer.s"},
+		{name: "codec subprotocol only", protocols: codec.SubprotocolJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws"+tt.query, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			if tt.protocols != "" {
+				r.Header.Set("Sec-WebSocket-Protocol", tt.protocols)
+			}
+			token, bearer := upgradeToken(r)
+			if token != tt.wantToken || bearer != tt.wantBearer {
+				t.Fatalf("upgradeToken = %q, %q; want %q, %q", token, bearer, tt.wantToken, tt.wantBearer)
+			}
+		})
+	}
+}
+
+// Trình duyệt hủy handshake nếu server không chọn subprotocol nào nó đề nghị
+func TestUpgraderForSelectsSubprotocol(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols []string
+		want      string
+	}{
+		{"bearer only", []string{"bearer.s"}, "bearer.s"},
+		{"codec preferred over bearer", []string{"bearer.s", codec.SubprotocolMsgpack}, codec.SubprotocolMsgpack},
+		{"codec without bearer", []string{codec.SubprotocolJSON}, codec.SubprotocolJSON},
+		{"nothing offered", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, bearer := upgradeToken(r)
+				conn, err := upgraderFor(bearer).Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}))
+			defer srv.Close()
+
+			dialer := websocket.Dialer{Subprotocols: tt.protocols}
+			conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+			if got := conn.Subprotocol(); got != tt.want {
+				t.Fatalf("subprotocol = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Upgrader dùng chung không bị sửa khi tạo bản có bearer
+	for _, p := range upgrader.Subprotocols {
+		if strings.HasPrefix(p, bearerSubprotocol) {
+			t.Fatalf("shared upgrader gained subprotocol %q", p)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package wire định nghĩa các view model công khai gửi cho client trong trận.
+//
+// Các struct ở đây là hợp đồng với client (React frontend, Flutter app): tên
+// trường JSON cố định và chỉ được thêm trường mới. Khi cần đổi ý nghĩa hoặc xóa
+// trường phải tăng Version. Package game chuyển trạng thái nội bộ sang các kiểu
+// này trước khi gửi, nên struct nội bộ có thể đổi tên/cấu trúc tự do.
+//
+// Quy ước chung:
+//   - Tọa độ tính theo ô lưới, gốc (0,0) ở góc trên bên trái map, theo góc nhìn
+//     của người nhận: người chơi side 1 nhận map và tọa độ đã xoay 180 độ.
+//   - Side là phe gốc của entity (0 = trên, 1 = dưới trong map gốc); Own cho biết
+//     entity có thuộc phe người nhận hay không.
+//   - Tick là số tick server tính từ đầu trận, bắt đầu từ 1.
+//   - ServerTime là thời điểm server tạo payload, unix milli giây; client dùng
+//     cùng kết quả time_sync để nội suy.
+package wire
+
+// Version là phiên bản của view model, gửi trong trường "v" của mỗi payload
+const Version = 1
+
+// Loại entity
+const (
+	KindTroop      = "troop"
+	KindSpell      = "spell"
+	KindGuardTower = "guard_tower"
+	KindKingTower  = "king_tower"
+)
+
+// Entity là một đơn vị hoặc trụ trên sân
+type Entity struct {
+	ID       string `json:"id"`                  // id ổn định trong suốt trận
+	Kind     string `json:"kind"`                // một trong các Kind*
+	Side     int    `json:"side"`                // phe gốc (0 hoặc 1)
+	Own      bool   `json:"own"`                 // true nếu thuộc phe người nhận
+	Name     string `json:"name"`                // tên lá bài hoặc trụ
+	Level    int    `json:"level"`               // cấp của lá bài/trụ
+	X        int    `json:"x"`                   // ô trên cùng bên trái của footprint
+	Y        int    `json:"y"`                   //
+	Width    int    `json:"width"`               // số ô theo trục X
+	Height   int    `json:"height"`              // số ô theo trục Y
+	HP       int    `json:"hp"`                  // máu hiện tại
+	MaxHP    int    `json:"max_hp"`              // máu tối đa theo cấp
+	Shield   int    `json:"shield"`              // giáp còn lại, hấp thụ sát thương trước máu
+	TargetID string `json:"target_id,omitempty"` // entity đang bị nhắm, rỗng nếu không có
+	Active   bool   `json:"active,omitempty"`    // chỉ dùng cho king_tower: đã bị đánh thức
+}
+
+// HandCard là một lá bài trên tay hoặc lá kế tiếp
+type HandCard struct {
+	Slot   int    `json:"slot"`    // vị trí trên tay (0-3), -1 cho lá kế tiếp
+	CardID int    `json:"card_id"` // giá trị gửi lại trong Release_card
+	Name   string `json:"name"`
+	Mana   int    `json:"mana"`
+}
+
+// Elixir là tài nguyên của người chơi
+type Elixir struct {
+	Value    float64 `json:"value"`    // elixir hiện có
+	Max      int     `json:"max"`      // giới hạn trên
+	Progress float64 `json:"progress"` // tiến độ tới điểm elixir tiếp theo, 0..1
+}
+
+// PlayerView là phần trạng thái riêng của người nhận
+type PlayerView struct {
+	Hand   []HandCard `json:"hand"`
+	Next   *HandCard  `json:"next,omitempty"`
+	Elixir Elixir     `json:"elixir"`
+}
+
+// MatchMap là lưới địa hình, gửi khi bắt đầu trận và khi MapVersion đổi
+type MatchMap struct {
+	V          int     `json:"v"`
+	MapVersion int     `json:"map_version"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Tiles      [][]int `json:"tiles"` // Tiles[y][x]; 0 = chặn, 1 = đi được, 2 = sông, 3/4 = trụ
+}
+
+// Snapshot là toàn bộ trạng thái trận tại Tick, dùng làm baseline mới
+type Snapshot struct {
+	V          int        `json:"v"`
+	Tick       int64      `json:"tick"`
+	ServerTime int64      `json:"server_time"`
+	Entities   []Entity   `json:"entities"`
+	Player     PlayerView `json:"player"`
+}
+
+// Delta là thay đổi từ BaseTick (baseline client đã ack) tới Tick
+type Delta struct {
+	V          int        `json:"v"`
+	Tick       int64      `json:"tick"`
+	ServerTime int64      `json:"server_time"`
+	BaseTick   int64      `json:"base_tick"`
+	Upserts    []Entity   `json:"upserts"` // entity mới hoặc đã thay đổi, thay thế toàn bộ bản cũ
+	Removed    []string   `json:"removed"` // id entity đã biến mất
+	Player     PlayerView `json:"player"`
+}
+
+// Update là trạng thái đầy đủ gửi mỗi tick cho client không hỗ trợ snapshot_delta.
+// Giữ tên trường của message "update" cũ (map, allies, elixir, hand, nextCard).
+type Update struct {
+	V          int         `json:"v"`
+	Tick       int64       `json:"tick"`
+	ServerTime int64       `json:"server_time"`
+	Map        [][]int     `json:"map"`      // lưới địa hình theo góc nhìn người nhận, như MatchMap.Tiles
+	Allies     [2][]Entity `json:"allies"`   // entity theo phe gốc
+	Elixir     float64     `json:"elixir"`   // elixir hiện có
+	Hand       []int       `json:"hand"`     // card id trên tay
+	NextCard   int         `json:"nextCard"` // card id lá kế tiếp, -1 nếu không có
+	Player     PlayerView  `json:"player"`
+}
+
+// Deck là trạng thái tay bài gửi sau mỗi lần thả bài
+type Deck struct {
+	V      int        `json:"v"`
+	Player PlayerView `json:"player"`
+}
+
+// Event là một sự kiện combat trong tick
+type Event struct {
+	Type     string `json:"type"` // damage_dealt, unit_spawned, unit_died, spell_cast, tower_destroyed, crit
+	Tick     int64  `json:"tick"`
+	Side     int    `json:"side"` // phe của entity gây ra sự kiện
+	SourceID string `json:"source_id,omitempty"`
+	TargetID string `json:"target_id,omitempty"`
+	Kind     string `json:"kind,omitempty"` // loại entity gây ra sự kiện
+	Name     string `json:"name,omitempty"` // tên lá bài/trụ gây ra sự kiện
+	Amount   int    `json:"amount,omitempty"`
+	Position *Point `json:"position,omitempty"` // vị trí diễn ra, theo góc nhìn người nhận
+}
+
+// EventBatch là tất cả sự kiện của một tick
+type EventBatch struct {
+	V          int     `json:"v"`
+	Tick       int64   `json:"tick"`
+	ServerTime int64   `json:"server_time"`
+	Events     []Event `json:"events"`
+}
+
+// SpectatorPlayer là thông tin công khai của một người chơi khi xem trận
+type SpectatorPlayer struct {
+	UserID int        `json:"user_id"`
+	Side   int        `json:"side"`
+	Elixir Elixir     `json:"elixir"`
+	Hand   []HandCard `json:"hand,omitempty"` // chỉ có khi trận cho phép xem bài trên tay
+	Next   *HandCard  `json:"next,omitempty"`
+}
+
+// SpectatorFrame là trạng thái trận gửi cho người xem, đã bị trễ theo cấu hình.
+// Góc nhìn trung lập: tọa độ theo map gốc (không đảo), Own luôn false.
+type SpectatorFrame struct {
+	V          int               `json:"v"`
+	Tick       int64             `json:"tick"`
+	ServerTime int64             `json:"server_time"`
+	Entities   []Entity          `json:"entities"`
+	Players    []SpectatorPlayer `json:"players"`
+	Events     []Event           `json:"events"`
+}
+
+// Point là một ô trên map
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
@@ -2,12 +2,26 @@ package main
 
 import (
 	"log"
+	"server/internal/auth"
 	"server/internal/db"
+	"server/internal/events"
+	"server/internal/mail"
+	"server/internal/service"
 	"server/internal/utils"
 	"server/internal/websocket"
+	"time"
 )
 
 func main() {
+	// Không có khóa ký hợp lệ thì dừng ngay, trước khi mở kết nối nào
+	auth.InitKeyring()
+	if err := auth.CheckPasswordParams(); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+	if err := mail.Init(); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+
 	if utils.CheckAndPrintNetwork() {
 		defer func() {
 			if err := db.DB.Close(); err != nil {
@@ -17,6 +31,20 @@ func main() {
 		}()
 		db.InitMySQL()
 		db.InitMongo()
+		if err := auth.EnsureTokenIndexes(); err != nil {
+			log.Printf("Error creating user_tokens indexes: %v", err)
+		}
+		if err := service.EnsureEmailTokenIndexes(); err != nil {
+			log.Printf("Error creating email_tokens indexes: %v", err)
+		}
+
+		events.InitSinks()
+		defer events.Close()
+
+		// Hoàn tất các lần xóa tài khoản bị lỗi giữa chừng (kể cả từ lần chạy trước)
+		stopRetries := make(chan struct{})
+		defer close(stopRetries)
+		go service.RunDeletionRetries(10*time.Minute, stopRetries)
 
 		websocket.InitWebSocketServer()
 	}